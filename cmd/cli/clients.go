@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	_ "github.com/jackc/pgconn"
+	_ "github.com/jackc/pgx/v4"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"strings"
+	"webapp/pkg/data"
+)
+
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// registerClient creates an OAuth client and prints its credentials. The secret is
+// only stored hashed, so this is the one chance to copy it.
+func (app *application) registerClient() error {
+	if app.ClientName == "" || app.RedirectURIs == "" {
+		return fmt.Errorf("-name and -redirect-uris are required")
+	}
+
+	clientID, err := randomString(16)
+	if err != nil {
+		return err
+	}
+
+	client := data.OAuthClient{
+		ClientID: clientID,
+		Name:     app.ClientName,
+	}
+	for _, uri := range strings.Split(app.RedirectURIs, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			client.RedirectURIs = append(client.RedirectURIs, uri)
+		}
+	}
+
+	var secret string
+	if !app.Public {
+		secret, err = randomString(32)
+		if err != nil {
+			return err
+		}
+		client.SecretHash = data.HashClientSecret(secret)
+	}
+
+	if _, err = app.DB.InsertOAuthClient(client); err != nil {
+		return err
+	}
+
+	fmt.Println("client_id:    ", clientID)
+	if secret != "" {
+		fmt.Println("client_secret:", secret)
+	} else {
+		fmt.Println("public client, use PKCE without a secret")
+	}
+	return nil
+}
+
+func (app *application) listClients() error {
+	clients, err := app.DB.AllOAuthClients()
+	if err != nil {
+		return err
+	}
+	for _, c := range clients {
+		kind := "confidential"
+		if c.IsPublic() {
+			kind = "public"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", c.ClientID, c.Name, kind, strings.Join(c.RedirectURIs, " "))
+	}
+	return nil
+}
+
+func (app *application) deleteClient() error {
+	if app.ClientID == "" {
+		return fmt.Errorf("-client-id is required")
+	}
+	return app.DB.DeleteOAuthClient(app.ClientID)
+}
@@ -6,24 +6,146 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 	"log"
 	"time"
+	"webapp/pkg/mailer"
+	"webapp/pkg/passwords"
+	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
 )
 
 type application struct {
-	JWTSecret string
-	Action    string
+	JWTSecret    string
+	Action       string
+	DSN          string
+	ClientName   string
+	ClientID     string
+	RedirectURIs string
+	Public       bool
+	Slug         string
+	Organization int
+	Email        string
+	Role         string
+	BaseURL      string
+	File         string
+	Format       string
+	DryRun       bool
+	BatchSize    int
+	Passwords    passwords.Rules
+	Breached     string
+	Mailer       mailer.Mailer
+	DB           repository.DatabaseRepo
 }
 
 // This is used to generate a token, so that we can test our api. Run this with go run ./cmd/cli and copy
 // the token that is printed out.
 // go run ./cmd/cli -action=valid     // will produce a valid token
 // go run ./cmd/cli -action=expired   // will produce an expired token
+//
+// It also manages the clients allowed to sign in through our OpenID Connect provider:
+// go run ./cmd/cli -action=register-client -name="Wiki" -redirect-uris="https://wiki.example.com/callback"
+// go run ./cmd/cli -action=list-clients
+// go run ./cmd/cli -action=delete-client -client-id=...
+//
+// And organizations:
+// go run ./cmd/cli -action=create-organization -name="Acme" -slug=acme
+// go run ./cmd/cli -action=add-member -organization=1 -email=admin@example.com -role=admin
+// go run ./cmd/cli -action=invite -organization=1 -email=colleague@example.com -role=viewer
+//
+// And users in bulk, from CSV or NDJSON:
+// go run ./cmd/cli -action=import-users -organization=1 -file=users.csv -dry-run
+// go run ./cmd/cli -action=import-users -organization=1 -file=users.ndjson -batch-size=100
+// go run ./cmd/cli -action=export-users -organization=1 -format=ndjson > users.ndjson
+//
+// And the index of breached passwords the web app refuses, from a list of passwords
+// or of SHA-1 hashes like Pwned Passwords publishes:
+// go run ./cmd/cli -action=index-breached-passwords -file=pwned-passwords-sha1.txt -breached-passwords=./breached
+//
+// And whether every message of the templates and catalogs is translated:
+// go run ./cmd/cli -action=check-translations
 
 func main() {
 	var app application
 	flag.StringVar(&app.JWTSecret, "jwt-secret", "jwt-secret", "secret")
-	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired")
+	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired|register-client|list-clients|delete-client|create-organization|add-member|invite|import-users|export-users|index-breached-passwords|check-translations")
+	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5433 user=webapp password=webapp dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
+	flag.StringVar(&app.ClientName, "name", "", "name of the client shown on the consent page, or of the organization")
+	flag.StringVar(&app.ClientID, "client-id", "", "client to delete")
+	flag.StringVar(&app.RedirectURIs, "redirect-uris", "", "comma separated redirect URIs of the client")
+	flag.BoolVar(&app.Public, "public", false, "register a public client (no secret, PKCE only)")
+	flag.StringVar(&app.Slug, "slug", "", "short unique name of the organization")
+	flag.IntVar(&app.Organization, "organization", 0, "organization to add the member to, invite, import or export users")
+	flag.StringVar(&app.Email, "email", "", "email of the user to add or invite")
+	flag.StringVar(&app.Role, "role", "", "role to give the new member")
+	flag.StringVar(&app.File, "file", "", "CSV or NDJSON file of users to import, - for standard input")
+	flag.StringVar(&app.Format, "format", "", "csv|ndjson, by default picked from the file extension on import and csv on export")
+	flag.BoolVar(&app.DryRun, "dry-run", false, "only validate the users to import")
+	flag.IntVar(&app.BatchSize, "batch-size", 0, "users to import per transaction, 0 for all in one")
+	flag.IntVar(&app.Passwords.MinLength, "password-min-length", 8, "fewest characters of an imported password")
+	flag.IntVar(&app.Passwords.MinScore, "password-min-score", 2, "lowest strength score, from 0 to 4, of an imported password")
+	flag.StringVar(&app.Breached, "breached-passwords", "", "directory of the breached password index to build, or to check imported passwords against")
+	flag.StringVar(&app.BaseURL, "base-url", "https://localhost:8085", "public URL of the web app, used in mailed links")
+	smtp := mailer.SMTPMailer{}
+	flag.StringVar(&smtp.Host, "smtp-host", "", "SMTP relay host; mail is only logged when empty")
+	flag.IntVar(&smtp.Port, "smtp-port", 587, "SMTP relay port")
+	flag.StringVar(&smtp.Username, "smtp-user", "", "SMTP username")
+	flag.StringVar(&smtp.Password, "smtp-password", "", "SMTP password")
+	flag.StringVar(&smtp.From, "mail-from", "no-reply@example.com", "sender address of outgoing mail")
 	flag.Parse()
 
+	app.Mailer = &mailer.LogMailer{}
+	if smtp.Host != "" {
+		app.Mailer = &smtp
+	}
+
+	if app.Breached != "" {
+		app.Passwords.Breached = &passwords.BreachedList{Dir: app.Breached}
+	}
+
+	switch app.Action {
+	case "valid", "expired":
+		app.printToken()
+	case "index-breached-passwords":
+		if err := app.indexBreachedPasswords(); err != nil {
+			log.Fatal(err)
+		}
+	case "check-translations":
+		if err := app.checkTranslations(); err != nil {
+			log.Fatal(err)
+		}
+	case "register-client", "list-clients", "delete-client", "create-organization", "add-member", "invite", "import-users", "export-users":
+		conn, err := openDB(app.DSN)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+		app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+
+		switch app.Action {
+		case "register-client":
+			err = app.registerClient()
+		case "list-clients":
+			err = app.listClients()
+		case "delete-client":
+			err = app.deleteClient()
+		case "create-organization":
+			err = app.createOrganization()
+		case "add-member":
+			err = app.addMember()
+		case "invite":
+			err = app.invite()
+		case "import-users":
+			err = app.importUsers()
+		case "export-users":
+			err = app.exportUsers()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown action %q", app.Action)
+	}
+}
+
+func (app *application) printToken() {
 	// generate a token
 	token := jwt.New(jwt.SigningMethodHS256)
 
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/repository"
+)
+
+func (app *application) createOrganization() error {
+	if app.ClientName == "" || app.Slug == "" {
+		return fmt.Errorf("-name and -slug are required")
+	}
+
+	id, err := app.DB.InsertOrganization(data.Organization{Name: app.ClientName, Slug: app.Slug, CreatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("organization:", id)
+	return nil
+}
+
+// addMember makes a user a member of an organization, optionally with a role there.
+func (app *application) addMember() error {
+	if app.Organization == 0 || app.Email == "" {
+		return fmt.Errorf("-organization and -email are required")
+	}
+
+	user, err := app.DB.GetUserByEmail(app.Email)
+	if err != nil {
+		return fmt.Errorf("no user %s: %w", app.Email, err)
+	}
+
+	return app.DB.WithTx(context.Background(), func(repo repository.DatabaseRepo) error {
+		if err := repo.AddOrganizationMember(app.Organization, user.ID); err != nil {
+			return err
+		}
+		if app.Role != "" {
+			return repo.ForOrganization(app.Organization).AssignUserRole(user.ID, app.Role)
+		}
+		return nil
+	})
+}
+
+// invite mails someone a link to create an account in an organization, like the
+// admin page does.
+func (app *application) invite() error {
+	if app.Organization == 0 || app.Email == "" {
+		return fmt.Errorf("-organization and -email are required")
+	}
+
+	organization, err := app.DB.GetOrganization(app.Organization)
+	if err != nil {
+		return fmt.Errorf("no organization %d: %w", app.Organization, err)
+	}
+	if _, err = app.DB.GetUserByEmail(app.Email); err == nil {
+		return fmt.Errorf("%s already has an account, use -action=add-member", app.Email)
+	}
+
+	token, err := randomString(32)
+	if err != nil {
+		return err
+	}
+
+	_, err = app.DB.InsertInvitation(data.Invitation{
+		OrganizationID: organization.ID,
+		Email:          app.Email,
+		Role:           app.Role,
+		TokenHash:      data.HashClientSecret(token),
+		ExpiresAt:      time.Now().Add(data.InvitationLifetime),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/invitations/%s", app.BaseURL, token)
+	err = app.Mailer.Send(mailer.Message{
+		To:      app.Email,
+		Subject: "You're invited to join " + organization.Name,
+		Body: fmt.Sprintf("You've been invited to join %s. Use this link to set up your account. It expires in %d days.\n\n%s\n",
+			organization.Name, int(data.InvitationLifetime.Hours()/24), link),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("invited", app.Email, link)
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"webapp/pkg/passwords"
+)
+
+// indexBreachedPasswords builds the breached password index the web app checks new
+// passwords against from a list of passwords or SHA-1 hashes.
+func (app *application) indexBreachedPasswords() error {
+	if app.File == "" || app.Breached == "" {
+		return fmt.Errorf("-file and -breached-passwords are required, -file=- reads standard input")
+	}
+
+	var in io.Reader = os.Stdin
+	if app.File != "-" {
+		f, err := os.Open(app.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	_, n, err := passwords.BuildBreachedList(app.Breached, in)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("indexed %d breached passwords in %s\n", n, app.Breached)
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"webapp/pkg/i18n"
+	"webapp/templates"
+)
+
+// checkTranslations lists the messages templates use that aren't in the English
+// catalog, and the messages other catalogs don't translate yet.
+func (app *application) checkTranslations() error {
+	keys, err := i18n.TemplateKeys(templates.Html, "html/*.gohtml", "html/partials/*.gohtml")
+	if err != nil {
+		return err
+	}
+
+	missing := i18n.Messages.Missing(keys...)
+	count := 0
+	for _, locale := range i18n.Messages.Locales() {
+		for _, key := range missing[locale] {
+			fmt.Printf("%s: missing %q\n", locale, key)
+			count++
+		}
+	}
+
+	if count > 0 {
+		return fmt.Errorf("%d missing translations", count)
+	}
+	fmt.Printf("all messages are translated into %v\n", i18n.Messages.Locales())
+	return nil
+}
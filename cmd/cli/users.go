@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"webapp/pkg/bulk"
+)
+
+// importUsers creates the users of a CSV or NDJSON file, printing the problems of
+// each invalid row. Nobody is created while there are any.
+func (app *application) importUsers() error {
+	if app.File == "" {
+		return fmt.Errorf("-file is required, - reads standard input")
+	}
+
+	var in io.Reader = os.Stdin
+	if app.File != "-" {
+		f, err := os.Open(app.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	format := app.Format
+	if format == "" {
+		format = bulk.FormatOf(app.File)
+	}
+
+	importer := bulk.Importer{
+		DB:           app.DB,
+		Organization: app.Organization,
+		DryRun:       app.DryRun,
+		BatchSize:    app.BatchSize,
+		Rules:        app.Passwords,
+	}
+	result, err := importer.Import(in, format)
+	if result == nil {
+		return err
+	}
+	for _, e := range result.Errors {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	if err != nil {
+		return fmt.Errorf("%w (%d users were imported)", err, result.Imported)
+	}
+
+	switch {
+	case len(result.Errors) > 0:
+		return fmt.Errorf("%d problems in %d rows, nobody was imported", len(result.Errors), result.Rows)
+	case result.DryRun:
+		fmt.Printf("all %d rows are valid\n", result.Rows)
+	default:
+		fmt.Printf("imported %d users\n", result.Imported)
+	}
+	return nil
+}
+
+// exportUsers writes the users, of one organization or all of them, to standard
+// output. Password hashes are left out.
+func (app *application) exportUsers() error {
+	format := app.Format
+	if format == "" {
+		format = bulk.CSV
+	}
+
+	repo := app.DB
+	if app.Organization != 0 {
+		repo = app.DB.ForOrganization(app.Organization)
+	}
+	uw, err := bulk.NewUserWriter(os.Stdout, format)
+	if err != nil {
+		return err
+	}
+	if err = repo.EachUser(context.Background(), uw.Write); err != nil {
+		return err
+	}
+
+	return uw.Flush()
+}
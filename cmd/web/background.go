@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+const (
+	// backgroundWorkers is how many background jobs run at a time.
+	backgroundWorkers = 4
+	// backgroundQueue is how many background jobs can wait for a worker.
+	backgroundQueue = 100
+)
+
+// background runs jobs off the request path on a fixed number of workers, so a burst
+// of requests can't start an unbounded number of goroutines.
+type background struct {
+	queue   chan func()
+	pending sync.WaitGroup
+
+	mu sync.Mutex
+	// keys are the keys of the jobs queued by GoOnce that haven't finished yet.
+	keys map[string]bool
+}
+
+// newBackground starts workers that take jobs from a queue of the given size.
+func newBackground(workers, size int) *background {
+	b := &background{queue: make(chan func(), size), keys: map[string]bool{}}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range b.queue {
+				b.run(job)
+			}
+		}()
+	}
+	return b
+}
+
+func (b *background) run(job func()) {
+	defer b.pending.Done()
+	defer func() {
+		if rvr := recover(); rvr != nil {
+			log.Printf("background job: panic: %v\n%s", rvr, debug.Stack())
+		}
+	}()
+	job()
+}
+
+// Go queues a job. It reports false, and drops the job, when the queue is full.
+func (b *background) Go(job func()) bool {
+	b.pending.Add(1)
+	select {
+	case b.queue <- job:
+		return true
+	default:
+		b.pending.Done()
+		return false
+	}
+}
+
+// GoOnce queues a job unless one with the same key is still queued or running. It
+// reports false, and drops the job, when there is one or the queue is full.
+func (b *background) GoOnce(key string, job func()) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.keys[key] {
+		return false
+	}
+
+	queued := b.Go(func() {
+		defer func() {
+			b.mu.Lock()
+			delete(b.keys, key)
+			b.mu.Unlock()
+		}()
+		job()
+	})
+	if queued {
+		b.keys[key] = true
+	}
+	return queued
+}
+
+// Wait blocks until the queued jobs have run.
+func (b *background) Wait() {
+	b.pending.Wait()
+}
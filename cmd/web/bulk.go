@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"webapp/pkg/bulk"
+	"webapp/pkg/data"
+)
+
+// maxImportSize is the largest import file accepted, in bytes.
+const maxImportSize = 10 << 20
+
+// AdminImportUsers shows the form to upload an import file.
+func (app *application) AdminImportUsers(w http.ResponseWriter, r *http.Request) {
+	_ = app.render(w, r, "admin-import.page.gohtml", &TemplateData{Data: map[string]any{}})
+}
+
+// PostAdminImportUsers creates the users of an uploaded CSV or NDJSON file in the
+// current organization, or only checks the file on a dry run. Per-row errors are
+// shown on the page; nobody is created while there are any.
+func (app *application) PostAdminImportUsers(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	err := r.ParseMultipartForm(maxImportSize)
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	td := &TemplateData{Data: map[string]any{}}
+
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	organization := app.currentOrganization(r, user.ID)
+	if organization == nil {
+		app.flash(r.Context(), FlashError, app.T(r, "switch to an organization first"))
+		_ = app.render(w, r, "admin-import.page.gohtml", td)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		app.flash(r.Context(), FlashError, app.T(r, "choose a file to import"))
+		_ = app.render(w, r, "admin-import.page.gohtml", td)
+		return
+	}
+	defer file.Close()
+
+	// rows can carry a role, which only admins who may hand out roles can give
+	permissions, err := app.repo(r).GetUserPermissions(user.ID)
+	if err != nil {
+		log.Println(err)
+	}
+
+	batchSize, _ := strconv.Atoi(r.PostForm.Get("batch_size"))
+	importer := bulk.Importer{
+		DB:           app.DB,
+		Organization: organization.ID,
+		DryRun:       r.PostForm.Get("dry_run") != "",
+		BatchSize:    batchSize,
+		Rules:        app.PasswordRules,
+		NoRoles:      !slices.Contains(permissions, data.PermissionRolesWrite),
+	}
+
+	result, err := importer.Import(file, bulk.FormatOf(header.Filename))
+	if err != nil {
+		log.Println("importing users:", err)
+		app.flash(r.Context(), FlashError, app.T(r, "the import failed"))
+	}
+	td.Data["Result"] = result
+	_ = app.render(w, r, "admin-import.page.gohtml", td)
+}
+
+// AdminExportUsers downloads the users of the current organization as CSV, or as
+// NDJSON with ?format=ndjson. The users are written as they are read from the
+// database, so large organizations needn't fit in memory.
+func (app *application) AdminExportUsers(w http.ResponseWriter, r *http.Request) {
+	format := bulk.CSV
+	contentType := "text/csv"
+	if r.URL.Query().Get("format") == bulk.NDJSON {
+		format = bulk.NDJSON
+		contentType = "application/x-ndjson"
+	}
+
+	uw, err := bulk.NewUserWriter(w, format)
+	if err != nil {
+		app.serveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="users.`+format+`"`)
+	err = app.repo(r).EachUser(r.Context(), uw.Write)
+	if err == nil {
+		err = uw.Flush()
+	}
+	if err != nil && !uw.Started() {
+		// nothing was sent yet, so the error can still be shown instead
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Disposition")
+		app.serveError(w, r, err)
+		return
+	}
+	if err != nil {
+		log.Println("exporting users:", err)
+	}
+}
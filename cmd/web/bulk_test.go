@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+)
+
+func Test_app_PostAdminImportUsers(t *testing.T) {
+	var tests = []struct {
+		name          string
+		fileName      string
+		content       string
+		dryRun        bool
+		organization  int
+		expectedCodes []string
+	}{
+		{
+			name:          "valid csv",
+			fileName:      "users.csv",
+			content:       "first_name,last_name,email,password,role\nJane,Doe,jane@example.com,correct horse battery staple,admin\nJohn,Doe,john@example.com,correct horse battery staple,\n",
+			expectedCodes: []string{"Imported 2 of 2 users"},
+		},
+		{
+			name:          "dry run",
+			fileName:      "users.csv",
+			content:       "first_name,last_name,email,password\nJane,Doe,jane@example.com,correct horse battery staple\n",
+			dryRun:        true,
+			expectedCodes: []string{"All 1 rows are valid"},
+		},
+		{
+			name:          "invalid ndjson",
+			fileName:      "users.ndjson",
+			content:       `{"first_name":"Jane","last_name":"Doe","email":"admin@example.com","password":"correct horse battery staple"}` + "\n" + `{"first_name":"John","email":"john@example.com","password":"correct horse battery staple","role":"owner"}` + "\n",
+			expectedCodes: []string{"3 problems in 2 rows", "This field cannot be blank", "A user with this email address already exists", "There is no role called owner"},
+		},
+		{
+			name:          "role without roles:write",
+			fileName:      "users.csv",
+			content:       "first_name,last_name,email,password,role\nJane,Doe,jane@example.com,correct horse battery staple,admin\n",
+			organization:  2,
+			expectedCodes: []string{"1 problems in 1 rows", "You can&#39;t hand out roles"},
+		},
+	}
+
+	for _, e := range tests {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		fw, _ := mw.CreateFormFile("file", e.fileName)
+		_, _ = fw.Write([]byte(e.content))
+		if e.dryRun {
+			_ = mw.WriteField("dry_run", "1")
+		}
+		_ = mw.Close()
+
+		req := httptest.NewRequest("POST", "/admin/users/import", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		organization := e.organization
+		if organization == 0 {
+			organization = 1
+		}
+		app.Session.Put(req.Context(), organizationKey, organization)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.PostAdminImportUsers).ServeHTTP(rr, req)
+
+		for _, code := range e.expectedCodes {
+			if !strings.Contains(rr.Body.String(), code) {
+				t.Errorf("%s: expected to find %s", e.name, code)
+			}
+		}
+	}
+}
+
+func Test_app_AdminExportUsers(t *testing.T) {
+	var tests = []struct {
+		name                string
+		query               string
+		expectedContentType string
+		expectedCodes       []string
+	}{
+		{name: "csv", expectedContentType: "text/csv", expectedCodes: []string{"id,first_name,last_name,email,status,created_at", "1,Admin,User,admin@example.com,active"}},
+		{name: "ndjson", query: "?format=ndjson", expectedContentType: "application/x-ndjson", expectedCodes: []string{`"email":"admin@example.com"`}},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/admin/users/export"+e.query, nil)
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		app.Session.Put(req.Context(), organizationKey, 1)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.AdminExportUsers).ServeHTTP(rr, req)
+
+		if ct := rr.Header().Get("Content-Type"); ct != e.expectedContentType {
+			t.Errorf("%s: expected content type %s, but got %s", e.name, e.expectedContentType, ct)
+		}
+		for _, code := range e.expectedCodes {
+			if !strings.Contains(rr.Body.String(), code) {
+				t.Errorf("%s: expected to find %s in %s", e.name, code, rr.Body.String())
+			}
+		}
+		if strings.Contains(rr.Body.String(), "password") || strings.Contains(rr.Body.String(), "$2a$") {
+			t.Errorf("%s: export contains passwords", e.name)
+		}
+	}
+}
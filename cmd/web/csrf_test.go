@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func Test_app_csrf(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var tests = []struct {
+		name               string
+		method             string
+		token              string
+		bearer             bool
+		expectedStatusCode int
+	}{
+		{name: "get", method: "GET", expectedStatusCode: http.StatusOK},
+		{name: "post without token", method: "POST", expectedStatusCode: http.StatusForbidden},
+		{name: "post with bad token", method: "POST", token: "bad", expectedStatusCode: http.StatusForbidden},
+		{name: "post with token", method: "POST", token: "valid", expectedStatusCode: http.StatusOK},
+		{name: "patch with token", method: "PATCH", token: "valid", expectedStatusCode: http.StatusOK},
+		{name: "put without token", method: "PUT", expectedStatusCode: http.StatusForbidden},
+		{name: "bearer api call", method: "POST", bearer: true, expectedStatusCode: http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req := newFormRequest(e.method, "/", url.Values{})
+		if e.token != "valid" {
+			req.PostForm = url.Values{csrfFormField: {e.token}}
+		}
+		if e.bearer {
+			req.Header.Set("Authorization", "Bearer some.jwt.token")
+		}
+
+		rr := httptest.NewRecorder()
+		app.csrf(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_csrfHeader(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", nil)
+	req = addContextAndSessionToRequest(req, app)
+	req = addCSRFHeader(req)
+
+	rr := httptest.NewRecorder()
+	app.csrf(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected a request with the CSRF header to pass, but got %d", rr.Code)
+	}
+}
+
+func Test_app_renewCSRFToken(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req = addContextAndSessionToRequest(req, app)
+
+	first := app.csrfToken(req.Context())
+	if first == "" || app.csrfToken(req.Context()) != first {
+		t.Error("expected a stable token within the session")
+	}
+
+	if app.renewCSRFToken(req.Context()) == first {
+		t.Error("expected renewCSRFToken to issue a new token")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// errorPage is the page serveError renders.
+const errorPage = "error.page.gohtml"
+
+// appError is a request that failed. Status and Message are what the user is told;
+// Err is the cause, which only goes to the log.
+type appError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *appError) Error() string {
+	if e.Err == nil {
+		return e.message()
+	}
+	return fmt.Sprintf("%s: %v", e.message(), e.Err)
+}
+
+func (e *appError) Unwrap() error {
+	return e.Err
+}
+
+// message is the public message, the status text unless there is a better one.
+func (e *appError) message() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return http.StatusText(e.Status)
+}
+
+// httpError is a failed request whose status says it all.
+func httpError(status int, cause error) *appError {
+	return &appError{Status: status, Err: cause}
+}
+
+// serveError answers a failed request with an error page, or an error object for
+// JSON clients, and logs what caused it under the request's id. Errors that aren't
+// an *appError are internal server errors, whose causes users never see.
+func (app *application) serveError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *appError
+	if !errors.As(err, &e) {
+		e = httpError(http.StatusInternalServerError, err)
+	}
+
+	id := middleware.GetReqID(r.Context())
+	if e.Err != nil || e.Status >= http.StatusInternalServerError {
+		log.Printf("request %s: %s %s: %v", id, r.Method, r.URL.Path, e)
+	}
+
+	msg := app.T(r, e.message())
+	switch {
+	case wantsJSON(r):
+		writeJSON(w, e.Status, map[string]string{"error": msg, "request_id": id})
+	case isHTMX(r):
+		triggerMessages(w, Flash{Level: FlashError, Message: msg})
+		w.WriteHeader(e.Status)
+	default:
+		td := &TemplateData{Data: map[string]any{"Status": e.Status, "Message": msg, "RequestID": id}}
+		_ = app.renderStatus(w, r, e.Status, errorPage, td)
+	}
+}
+
+// NotFound serves the error page for paths no route matches.
+func (app *application) NotFound(w http.ResponseWriter, r *http.Request) {
+	app.serveError(w, r, httpError(http.StatusNotFound, nil))
+}
+
+// MethodNotAllowed serves the error page for routes that don't take a method.
+func (app *application) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	app.serveError(w, r, httpError(http.StatusMethodNotAllowed, nil))
+}
+
+// recoverPanic turns a panicking handler into an internal server error, logging
+// the stack with the request's id.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			if rvr == http.ErrAbortHandler {
+				// the connection is gone; let the server handle it
+				panic(rvr)
+			}
+			w.Header().Set("Connection", "close")
+			app.serveError(w, r, fmt.Errorf("panic: %v\n%s", rvr, debug.Stack()))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_app_errorPages(t *testing.T) {
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		accept             string
+		expectedStatusCode int
+		expected           string
+	}{
+		{name: "not found", method: "GET", url: "/nothing-here", expectedStatusCode: http.StatusNotFound, expected: "There&#39;s no page at this address"},
+		{name: "method not allowed", method: "DELETE", url: "/login/magic/redeem", expectedStatusCode: http.StatusMethodNotAllowed, expected: "405 · Method Not Allowed"},
+		{name: "json", method: "GET", url: "/nothing-here", accept: "application/json", expectedStatusCode: http.StatusNotFound, expected: `"error":"Not Found"`},
+		{name: "csrf", method: "POST", url: "/login", expectedStatusCode: http.StatusForbidden, expected: "this form has expired"},
+	}
+
+	routes := app.routes()
+	for _, e := range tests {
+		req := httptest.NewRequest(e.method, e.url, nil)
+		req.Header.Set("Accept", e.accept)
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expected) {
+			t.Errorf("%s: expected %q in %s", e.name, e.expected, rr.Body.String())
+		}
+	}
+}
+
+func Test_app_serveError(t *testing.T) {
+	var tests = []struct {
+		name               string
+		err                error
+		expectedStatusCode int
+		expected           string
+	}{
+		{name: "app error", err: &appError{Status: http.StatusBadRequest, Message: "choose a role", Err: errors.New("pq: secret detail")}, expectedStatusCode: http.StatusBadRequest, expected: "choose a role"},
+		{name: "wrapped", err: errors.Join(errors.New("context"), httpError(http.StatusForbidden, nil)), expectedStatusCode: http.StatusForbidden, expected: "Forbidden"},
+		{name: "other error", err: errors.New("pq: secret detail"), expectedStatusCode: http.StatusInternalServerError, expected: "Something went wrong on our side"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req = addContextAndSessionToRequest(req, app)
+		rr := httptest.NewRecorder()
+
+		app.serveError(rr, req, e.err)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expected) {
+			t.Errorf("%s: expected %q on the page", e.name, e.expected)
+		}
+		if strings.Contains(rr.Body.String(), "secret detail") {
+			t.Errorf("%s: expected the cause to stay out of the page", e.name)
+		}
+	}
+}
+
+func Test_app_recoverPanic(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req = addContextAndSessionToRequest(req, app)
+	rr := httptest.NewRecorder()
+
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	app.recoverPanic(panicking).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, but got %d", http.StatusInternalServerError, rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), "boom") {
+		t.Error("expected the panic to stay out of the page")
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+)
+
+var exportPath = "./exports"
+
+// exportLifetime is how long the download link of a data export works. The file is
+// removed with the next purge after that.
+const exportLifetime = 24 * time.Hour
+
+const (
+	// exportWorkers is how many exports are built at a time.
+	exportWorkers = 2
+	// exportQueue is how many exports can wait for a worker.
+	exportQueue = 20
+)
+
+// exportFile is where the export a token was issued for is kept. The user id prefix
+// lets erasure find a user's exports without their tokens.
+func exportFile(userID int, tokenHash string) string {
+	return filepath.Join(exportPath, fmt.Sprintf("%d-%s.zip", userID, tokenHash))
+}
+
+// exportedUser is the user row as it goes into an export, including the fields the
+// JSON API leaves out.
+type exportedUser struct {
+	*data.User
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type exportedImage struct {
+	*data.UserImage
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type exportedOrganization struct {
+	*data.Organization
+	Roles []string `json:"roles"`
+}
+
+// writeExport writes a ZIP of everything we hold about a user: JSON of their row,
+// profile image, linked identities and organizations, and the image file itself.
+func (app *application) writeExport(w io.Writer, userID int) error {
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		return err
+	}
+
+	var images []exportedImage
+	image, err := app.DB.GetUserImage(userID)
+	if err == nil {
+		images = append(images, exportedImage{UserImage: image, CreatedAt: image.CreatedAt, UpdatedAt: image.UpdatedAt})
+	}
+
+	identities, err := app.DB.GetUserIdentities(userID)
+	if err != nil {
+		return err
+	}
+
+	organizations, err := app.DB.GetUserOrganizations(userID)
+	if err != nil {
+		return err
+	}
+	memberships := make([]exportedOrganization, 0, len(organizations))
+	for _, o := range organizations {
+		roles, err := app.DB.ForOrganization(o.ID).GetUserRoles(userID)
+		if err != nil {
+			return err
+		}
+		memberships = append(memberships, exportedOrganization{Organization: o, Roles: roles})
+	}
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"user.json", exportedUser{User: user, CreatedAt: user.CreatedAt, UpdatedAt: user.UpdatedAt}},
+		{"images.json", images},
+		{"identities.json", identities},
+		{"organizations.json", memberships},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(f.v); err != nil {
+			return err
+		}
+	}
+
+	for _, i := range images {
+		name := filepath.Base(i.FileName)
+		in, err := os.Open(filepath.Join(uploadPath, name))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		fw, err := zw.Create("images/" + name)
+		if err == nil {
+			_, err = io.Copy(fw, in)
+		}
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// exportUserData builds a user's export and mails them a link to download it.
+func (app *application) exportUserData(user data.User) error {
+	token, hash, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(exportPath, 0700); err != nil {
+		return err
+	}
+
+	// write to a temporary file, so a half written export is never served
+	path := exportFile(user.ID, hash)
+	f, err := os.CreateTemp(exportPath, "export-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	err = app.writeExport(f, user.ID)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	_, err = app.DB.InsertUserToken(data.UserToken{
+		UserID:    user.ID,
+		TokenHash: hash,
+		Purpose:   data.TokenDataExport,
+		ExpiresAt: time.Now().Add(exportLifetime),
+	})
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	return app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your data export is ready",
+		Body: fmt.Sprintf("Download your data with this link. It expires in %d hours.\n\n%s/user/export/%s\n",
+			int(exportLifetime.Hours()), app.BaseURL, token),
+	})
+}
+
+// PostExport starts building an export of the user's data in the background. Each
+// user has at most one export pending.
+func (app *application) PostExport(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	queued := app.Exports.GoOnce(strconv.Itoa(user.ID), func() {
+		if err := app.exportUserData(user); err != nil {
+			log.Println("exporting data of user", user.ID, err)
+		}
+	})
+	if !queued {
+		app.flash(r.Context(), FlashError, app.T(r, "we're still preparing an export, try again later"))
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	app.flash(r.Context(), FlashInfo, app.T(r, "we're preparing your data, you'll get an email with a download link"))
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// Export downloads an export. The link only works for the user it was made for.
+func (app *application) Export(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	hash := hashToken(chi.URLParam(r, "token"))
+
+	var f *os.File
+	token, err := app.DB.GetUserToken(hash, data.TokenDataExport)
+	if err == nil && token.UserID == user.ID {
+		f, err = os.Open(exportFile(user.ID, hash))
+	}
+	if f == nil {
+		if err != nil {
+			log.Println(err)
+		}
+		app.flash(r.Context(), FlashError, app.T(r, "this download link is invalid or has expired"))
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		app.serveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="webapp-export.zip"`)
+	http.ServeContent(w, r, "webapp-export.zip", stat.ModTime(), f)
+}
+
+// removeExpiredExports deletes the export files whose download links have expired.
+func removeExpiredExports() error {
+	files, err := filepath.Glob(filepath.Join(exportPath, "*.zip"))
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if stat, err := os.Stat(name); err == nil && time.Since(stat.ModTime()) > exportLifetime {
+			if err = os.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// eraseUser removes a user's stored files and then the user, with everything the
+// database holds about them.
+func (app *application) eraseUser(id int) error {
+	image := ""
+	if i, err := app.DB.GetUserImage(id); err == nil {
+		image = i.FileName
+	}
+
+	if err := app.removeUserFiles(id, image); err != nil {
+		return err
+	}
+
+	return app.DB.EraseUser(id)
+}
+
+// removeUserFiles deletes a user's exports and their profile image. Uploads keep the
+// name they were uploaded with in a directory all users share, so the image stays
+// while another user's image has the same name.
+func (app *application) removeUserFiles(id int, image string) error {
+	if image != "" {
+		inUse, err := app.DB.ImageInUse(image, id)
+		if err != nil {
+			return err
+		}
+		if !inUse {
+			err = os.Remove(filepath.Join(uploadPath, filepath.Base(image)))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	exports, err := filepath.Glob(filepath.Join(exportPath, fmt.Sprintf("%d-*.zip", id)))
+	if err != nil {
+		return err
+	}
+	for _, name := range exports {
+		if err = os.Remove(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PostErase erases the user's account and data for good, once they confirmed by
+// typing their email address.
+func (app *application) PostErase(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	form := app.form(r, r.PostForm)
+	form.Required("email")
+	form.Check(strings.EqualFold(strings.TrimSpace(form.Data.Get("email")), user.Email), "email", app.T(r, "that isn't your email address"))
+	if !form.Valid() {
+		app.flash(r.Context(), FlashError, app.T(r, "type your email address to confirm the erasure"))
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	if err = app.eraseUser(user.ID); err != nil {
+		log.Println(err)
+		app.flash(r.Context(), FlashError, app.T(r, "could not erase your account"))
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	_ = app.Session.Destroy(r.Context())
+	app.flash(r.Context(), FlashSuccess, app.T(r, "your account and data have been erased"))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+)
+
+// useTempDirs points the upload and export directories at temporary ones for one
+// test, with a copy of the admin's profile image.
+func useTempDirs(t *testing.T) {
+	oldUploadPath, oldExportPath := uploadPath, exportPath
+	t.Cleanup(func() { uploadPath, exportPath = oldUploadPath, oldExportPath })
+
+	uploadPath, exportPath = t.TempDir(), t.TempDir()
+	img, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(uploadPath, "img.png"), img, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_app_writeExport(t *testing.T) {
+	useTempDirs(t)
+
+	var buf bytes.Buffer
+	if err := app.writeExport(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := map[string]string{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		contents[f.Name] = string(b)
+	}
+
+	for _, name := range []string{"user.json", "images.json", "identities.json", "organizations.json", "images/img.png"} {
+		if _, ok := contents[name]; !ok {
+			t.Errorf("expected %s in the export", name)
+		}
+	}
+	if !strings.Contains(contents["user.json"], "admin@example.com") || !strings.Contains(contents["user.json"], "created_at") {
+		t.Errorf("unexpected user.json %s", contents["user.json"])
+	}
+	if !strings.Contains(contents["organizations.json"], `"admin"`) {
+		t.Errorf("expected the roles in organizations.json, but got %s", contents["organizations.json"])
+	}
+}
+
+func Test_app_PostExport(t *testing.T) {
+	useTempDirs(t)
+	mail := app.Mailer.(*testMailer)
+	mail.sent = nil
+
+	req := newFormRequest("POST", "/user/export", url.Values{})
+	app.Session.Put(req.Context(), "user", data.User{ID: 1, Email: "admin@example.com"})
+
+	rr := httptest.NewRecorder()
+	app.csrf(http.HandlerFunc(app.PostExport)).ServeHTTP(rr, req)
+	app.Exports.Wait()
+
+	if rr.Code != http.StatusSeeOther || flashed(req, FlashInfo) == "" {
+		t.Errorf("expected a redirect with a flash, but got %d", rr.Code)
+	}
+	if len(mail.sent) != 1 || !strings.Contains(mail.sent[0].Body, "https://example.com/user/export/") {
+		t.Fatalf("expected a mail with a download link, but got %v", mail.sent)
+	}
+	if files, _ := filepath.Glob(filepath.Join(exportPath, "1-*.zip")); len(files) != 1 {
+		t.Errorf("expected one export file, but got %v", files)
+	}
+}
+
+func Test_app_PostExportPending(t *testing.T) {
+	useTempDirs(t)
+
+	// hold a pending export of the user until the request is done
+	release := make(chan struct{})
+	app.Exports.GoOnce("1", func() { <-release })
+	defer app.Exports.Wait()
+	defer close(release)
+
+	req := newFormRequest("POST", "/user/export", url.Values{})
+	app.Session.Put(req.Context(), "user", data.User{ID: 1, Email: "admin@example.com"})
+
+	rr := httptest.NewRecorder()
+	app.csrf(http.HandlerFunc(app.PostExport)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || flashed(req, FlashError) == "" {
+		t.Errorf("expected a second export to be refused, but got %d", rr.Code)
+	}
+}
+
+func Test_app_Export(t *testing.T) {
+	useTempDirs(t)
+	if err := os.WriteFile(exportFile(1, dbrepo.TestUserTokenHash), []byte("zip"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name               string
+		userID             int
+		token              string
+		expectedStatusCode int
+	}{
+		{name: "own export", userID: 1, token: "valid-token", expectedStatusCode: http.StatusOK},
+		{name: "someone else's export", userID: 3, token: "valid-token", expectedStatusCode: http.StatusSeeOther},
+		{name: "unknown token", userID: 1, token: "other-token", expectedStatusCode: http.StatusSeeOther},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/user/export/"+e.token, nil)
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: e.userID})
+		req = withURLParam(req, "token", e.token)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.Export).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedStatusCode == http.StatusOK && !strings.HasPrefix(rr.Header().Get("Content-Disposition"), "attachment") {
+			t.Errorf("%s: expected the export as an attachment", e.name)
+		}
+	}
+}
+
+func Test_removeExpiredExports(t *testing.T) {
+	useTempDirs(t)
+
+	expired, fresh := exportFile(1, "expired"), exportFile(1, "fresh")
+	for _, name := range []string{expired, fresh} {
+		if err := os.WriteFile(name, []byte("zip"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-exportLifetime - time.Minute)
+	_ = os.Chtimes(expired, old, old)
+
+	if err := removeExpiredExports(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Error("expected the expired export to be removed")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Error("expected the fresh export to be kept")
+	}
+}
+
+func Test_app_PostErase(t *testing.T) {
+	useTempDirs(t)
+	export := exportFile(1, "some-hash")
+	_ = os.WriteFile(export, []byte("zip"), 0600)
+
+	var tests = []struct {
+		name        string
+		email       string
+		expectedLoc string
+		erased      bool
+	}{
+		{name: "wrong email", email: "someone@example.com", expectedLoc: "/user/profile"},
+		{name: "confirmed", email: "Admin@example.com", expectedLoc: "/", erased: true},
+	}
+
+	for _, e := range tests {
+		req := newFormRequest("POST", "/user/erase", url.Values{"email": {e.email}})
+		app.Session.Put(req.Context(), "user", data.User{ID: 1, Email: "admin@example.com"})
+
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostErase)).ServeHTTP(rr, req)
+
+		if loc, _ := rr.Result().Location(); loc == nil || loc.String() != e.expectedLoc {
+			t.Errorf("%s: expected location %s, but got %v", e.name, e.expectedLoc, loc)
+		}
+		_, err := os.Stat(filepath.Join(uploadPath, "img.png"))
+		if removed := os.IsNotExist(err); removed != e.erased {
+			t.Errorf("%s: expected image removed %v, but got %v", e.name, e.erased, removed)
+		}
+		_, err = os.Stat(export)
+		if removed := os.IsNotExist(err); removed != e.erased {
+			t.Errorf("%s: expected export removed %v, but got %v", e.name, e.erased, removed)
+		}
+		if e.erased && app.Session.Exists(req.Context(), "user") {
+			t.Errorf("%s: expected the user to be logged out", e.name)
+		}
+	}
+}
+
+func Test_app_eraseUserKeepsSharedImage(t *testing.T) {
+	useTempDirs(t)
+	shared := filepath.Join(uploadPath, "shared.png")
+	if err := os.WriteFile(shared, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.eraseUser(5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(shared); err != nil {
+		t.Error("expected an image another user has too to be kept")
+	}
+}
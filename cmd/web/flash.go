@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"webapp/pkg/forms"
+)
+
+// The levels of flash messages.
+const (
+	FlashSuccess = "success"
+	FlashInfo    = "info"
+	FlashWarning = "warning"
+	FlashError   = "error"
+)
+
+const (
+	// flashKey is the session key of the messages queued for the next page.
+	flashKey = "flashes"
+	// flashFormKey is the session key of a form to show again on the next page.
+	flashFormKey = "flash_form"
+)
+
+// Flash is a message shown once, on the next page the user sees.
+type Flash struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// flashedForm is what's kept of a form across a redirect.
+type flashedForm struct {
+	Data   url.Values
+	Errors map[string][]string
+}
+
+// flash queues a message for the next page the user sees, after any queued before.
+func (app *application) flash(ctx context.Context, level, message string) {
+	flashes, _ := app.Session.Get(ctx, flashKey).([]Flash)
+	app.Session.Put(ctx, flashKey, append(flashes, Flash{Level: level, Message: message}))
+}
+
+// popFlashes returns the queued messages and removes them.
+func (app *application) popFlashes(ctx context.Context) []Flash {
+	flashes, _ := app.Session.Pop(ctx, flashKey).([]Flash)
+	return flashes
+}
+
+// flashForm keeps a form that didn't validate for the page the user is sent back
+// to, so it shows their input with the errors next to the fields. Passwords and the
+// CSRF token aren't kept.
+func (app *application) flashForm(ctx context.Context, form *forms.Form) {
+	kept := url.Values{}
+	for field, values := range form.Data {
+		if field != csrfFormField && !strings.Contains(field, "password") {
+			kept[field] = values
+		}
+	}
+	app.Session.Put(ctx, flashFormKey, flashedForm{Data: kept, Errors: form.Errors})
+}
+
+// popForm returns the form flashForm kept, or nil.
+func (app *application) popForm(ctx context.Context) *forms.Form {
+	kept, ok := app.Session.Pop(ctx, flashFormKey).(flashedForm)
+	if !ok {
+		return nil
+	}
+	form := forms.New(kept.Data)
+	for field, messages := range kept.Errors {
+		for _, msg := range messages {
+			form.Errors.Add(field, msg)
+		}
+	}
+	return form
+}
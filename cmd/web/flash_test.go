@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/forms"
+)
+
+// flashed returns the first queued message of a level, leaving the queue alone.
+func flashed(req *http.Request, level string) string {
+	flashes, _ := app.Session.Get(req.Context(), flashKey).([]Flash)
+	for _, f := range flashes {
+		if f.Level == level {
+			return f.Message
+		}
+	}
+	return ""
+}
+
+func Test_app_flash(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req = addContextAndSessionToRequest(req, app)
+
+	app.flash(req.Context(), FlashSuccess, "saved")
+	app.flash(req.Context(), FlashWarning, "almost full")
+	app.flash(req.Context(), FlashError, "one failed")
+
+	rr := httptest.NewRecorder()
+	if err := app.render(rr, req, "home.page.gohtml", &TemplateData{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`alert-success" role="alert">
+                    saved`,
+		`alert-warning" role="alert">
+                    almost full`,
+		`alert-danger" role="alert">
+                    one failed`,
+	} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected %q on the page", expected)
+		}
+	}
+	if flashes := app.popFlashes(req.Context()); len(flashes) != 0 {
+		t.Errorf("expected the flashes to be shown once, but %v are left", flashes)
+	}
+}
+
+func Test_app_flashForm(t *testing.T) {
+	form := url.Values{"email": {"not an address"}, "role": {"admin"}}
+	req := newFormRequest("POST", "/admin/invitations", form)
+	app.Session.Put(req.Context(), "user", data.User{ID: 1})
+	app.Session.Put(req.Context(), organizationKey, 1)
+	rr := httptest.NewRecorder()
+	app.csrf(http.HandlerFunc(app.PostAdminInvitation)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected status 303, but got %d", rr.Code)
+	}
+
+	// the page the admin is sent back to
+	page := httptest.NewRequest("GET", "/admin/invitations", nil)
+	page = page.WithContext(req.Context())
+	rr = httptest.NewRecorder()
+	app.AdminInvitations(rr, page)
+
+	for _, expected := range []string{`value="not an address"`, "is-invalid", "This is not a valid email address"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected %q on the page", expected)
+		}
+	}
+	if app.popForm(page.Context()) != nil {
+		t.Error("expected the form to be shown once")
+	}
+
+	app.flashForm(req.Context(), forms.New(url.Values{csrfFormField: {"token"}, "confirm_password": {"secret"}, "email": {"a@example.com"}}))
+	kept := app.popForm(req.Context())
+	if kept.Has(csrfFormField) || kept.Has("confirm_password") || !kept.Has("email") {
+		t.Errorf("expected only the email to be kept, but got %v", kept.Data)
+	}
+}
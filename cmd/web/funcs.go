@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+	"webapp/pkg/data"
+)
+
+// timezoneKey is the session key of the timezone a user picked; dates show in UTC
+// until they do.
+const timezoneKey = "timezone"
+
+// dateLayout is how date shows times when it's given no layout.
+const dateLayout = "2 Jan 2006 15:04"
+
+// defaultAvatar is the picture of users who didn't upload one.
+const defaultAvatar = "/static/img/avatar.svg"
+
+// routeNames are the patterns of the routes templates link to with route and url,
+// so a route can move without hunting down its links.
+var routeNames = map[string]string{
+	"home":                    "/",
+	"locale":                  "/locale",
+	"login":                   "/login",
+	"login.2fa":               "/login/2fa",
+	"login.magic":             "/login/magic",
+	"login.magic.redeem":      "/login/magic/redeem",
+	"login.oidc":              "/login/oidc",
+	"oauth.authorize":         "/oauth/authorize",
+	"invitation":              "/invitations/{token}",
+	"invitation.accept":       "/invitations/accept",
+	"profile":                 "/user/profile",
+	"profile.pic":             "/user/upload-profile-pic",
+	"profile.password":        "/user/password",
+	"profile.timezone":        "/user/timezone",
+	"profile.organization":    "/user/organization",
+	"profile.export":          "/user/export",
+	"profile.erase":           "/user/erase",
+	"2fa.setup":               "/user/2fa/setup",
+	"admin.users":             "/admin/users",
+	"admin.users.export":      "/admin/users/export",
+	"admin.users.import":      "/admin/users/import",
+	"admin.user.roles":        "/admin/users/{id}/roles",
+	"admin.user.delete":       "/admin/users/{id}/delete",
+	"admin.user.status":       "/admin/users/{id}/status",
+	"admin.invitations":       "/admin/invitations",
+	"admin.invitation.revoke": "/admin/invitations/{id}/revoke",
+}
+
+// routeParam matches the URL parameters of a route pattern.
+var routeParam = regexp.MustCompile(`\{[^}]+\}`)
+
+// route returns the path of a named route, with its URL parameters filled in by
+// params in order.
+func route(name string, params ...any) (string, error) {
+	pattern, ok := routeNames[name]
+	if !ok {
+		return "", fmt.Errorf("there is no route %s", name)
+	}
+
+	if n := len(routeParam.FindAllString(pattern, -1)); n != len(params) {
+		return "", fmt.Errorf("route %s takes %d parameters, not %d", name, n, len(params))
+	}
+	i := 0
+	return routeParam.ReplaceAllStringFunc(pattern, func(string) string {
+		i++
+		return url.PathEscape(fmt.Sprint(params[i-1]))
+	}), nil
+}
+
+// pluralize picks the singular or plural of a message by a count, to translate:
+//
+//	{{T (pluralize .Count "%d user" "%d users") .Count}}
+func pluralize(count int, singular, plural string) string {
+	if count == 1 {
+		return singular
+	}
+	return plural
+}
+
+// dict makes a map of key and value pairs, to pass several values to a partial:
+//
+//	{{template "field" dict "Form" .Form "Name" "password" "Label" "Password" "Type" "password"}}
+func dict(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict needs pairs of keys and values")
+	}
+	m := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict key %v isn't a string", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
+
+// avatarURL returns the address of a user's profile picture.
+func avatarURL(user data.User) string {
+	if user.ProfilePic.FileName == "" {
+		return defaultAvatar
+	}
+	return "/static/img/" + url.PathEscape(user.ProfilePic.FileName)
+}
+
+// templateFuncs are the functions of every template. The ones that depend on the
+// request are placeholders here; render binds them, see requestFuncs.
+var templateFuncs = template.FuncMap{
+	"T":         fmt.Sprintf,
+	"date":      func(t any, layout ...string) (string, error) { return "", nil },
+	"hasPerm":   func(permission string) bool { return false },
+	"url":       func(name string, params ...any) (string, error) { return "", nil },
+	"route":     route,
+	"pluralize": pluralize,
+	"dict":      dict,
+	"avatarURL": avatarURL,
+}
+
+// requestFuncs binds the template functions that depend on the request: T and date
+// to the user's locale and timezone, hasPerm to their permissions, and url to the
+// app's public address.
+func (app *application) requestFuncs(r *http.Request, td *TemplateData) template.FuncMap {
+	loc := app.location(r)
+	return template.FuncMap{
+		"T": app.printer(r).T,
+		"date": func(t any, layout ...string) (string, error) {
+			return formatDate(t, loc, layout...)
+		},
+		"hasPerm": func(permission string) bool {
+			return slices.Contains(td.Permissions, permission)
+		},
+		"url": func(name string, params ...any) (string, error) {
+			path, err := route(name, params...)
+			return app.BaseURL + path, err
+		},
+	}
+}
+
+// formatDate formats a time in loc. A nil or zero time is left blank.
+func formatDate(t any, loc *time.Location, layout ...string) (string, error) {
+	var tm time.Time
+	switch t := t.(type) {
+	case time.Time:
+		tm = t
+	case *time.Time:
+		if t != nil {
+			tm = *t
+		}
+	default:
+		return "", fmt.Errorf("date can't format a %T", t)
+	}
+	if tm.IsZero() {
+		return "", nil
+	}
+
+	l := dateLayout
+	if len(layout) > 0 {
+		l = layout[0]
+	}
+	return tm.In(loc).Format(l), nil
+}
+
+// location is the timezone the user picked, or UTC.
+func (app *application) location(r *http.Request) *time.Location {
+	if name := app.Session.GetString(r.Context(), timezoneKey); name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// PostTimezone remembers the timezone a user wants dates in for the rest of their
+// session.
+func (app *application) PostTimezone(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	form := app.form(r, r.PostForm)
+	form.Required("timezone")
+	if form.Valid() {
+		// Local is whatever the server runs in, not a zone of the user's
+		_, err = time.LoadLocation(form.Data.Get("timezone"))
+		form.Check(err == nil && form.Data.Get("timezone") != "Local", "timezone", app.T(r, "This is not a known timezone"))
+	}
+	if !form.Valid() {
+		app.flash(r.Context(), FlashError, form.Errors.Get("timezone"))
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	app.Session.Put(r.Context(), timezoneKey, form.Data.Get("timezone"))
+	app.flash(r.Context(), FlashSuccess, app.T(r, "Dates now show in %s", form.Data.Get("timezone")))
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+)
+
+func Test_routeNames_registered(t *testing.T) {
+	patterns := map[string]bool{}
+	_ = chi.Walk(app.routes().(chi.Routes), func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		patterns[route] = true
+		return nil
+	})
+
+	for name, pattern := range routeNames {
+		if !patterns[pattern] {
+			t.Errorf("route %s: %s is not registered", name, pattern)
+		}
+	}
+}
+
+func Test_route(t *testing.T) {
+	var tests = []struct {
+		name     string
+		route    string
+		params   []any
+		expected string
+		errors   bool
+	}{
+		{name: "plain", route: "profile", expected: "/user/profile"},
+		{name: "param", route: "admin.user.roles", params: []any{7}, expected: "/admin/users/7/roles"},
+		{name: "escaped", route: "invitation", params: []any{"a/b c"}, expected: "/invitations/a%2Fb%20c"},
+		{name: "unknown", route: "nope", errors: true},
+		{name: "missing param", route: "admin.user.roles", errors: true},
+	}
+
+	for _, e := range tests {
+		got, err := route(e.route, e.params...)
+		if (err != nil) != e.errors {
+			t.Errorf("%s: expected error %v, but got %v", e.name, e.errors, err)
+		}
+		if got != e.expected {
+			t.Errorf("%s: expected %q, but got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func Test_formatDate(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 7, 1, 22, 30, 0, 0, time.UTC)
+	var never *time.Time
+
+	var tests = []struct {
+		name     string
+		t        any
+		layout   []string
+		expected string
+	}{
+		{name: "default layout", t: at, expected: "2 Jul 2024 00:30"},
+		{name: "layout", t: &at, layout: []string{"2006-01-02"}, expected: "2024-07-02"},
+		{name: "nil", t: never, expected: ""},
+		{name: "zero", t: time.Time{}, expected: ""},
+	}
+
+	for _, e := range tests {
+		got, err := formatDate(e.t, berlin, e.layout...)
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+		}
+		if got != e.expected {
+			t.Errorf("%s: expected %q, but got %q", e.name, e.expected, got)
+		}
+	}
+
+	if _, err = formatDate("yesterday", berlin); err == nil {
+		t.Error("expected an error formatting a string")
+	}
+}
+
+func Test_helpers(t *testing.T) {
+	if got := pluralize(1, "%d user", "%d users"); got != "%d user" {
+		t.Errorf("expected the singular, but got %q", got)
+	}
+	if got := pluralize(0, "%d user", "%d users"); got != "%d users" {
+		t.Errorf("expected the plural, but got %q", got)
+	}
+
+	if m, err := dict("Name", "email", "Type", 3); err != nil || m["Name"] != "email" || m["Type"] != 3 {
+		t.Errorf("unexpected dict %v, %v", m, err)
+	}
+	if _, err := dict("Name"); err == nil {
+		t.Error("expected an error for a key without a value")
+	}
+
+	if got := avatarURL(data.User{}); got != defaultAvatar {
+		t.Errorf("expected the default avatar, but got %s", got)
+	}
+	if got := avatarURL(data.User{ProfilePic: data.UserImage{FileName: "me.png"}}); got != "/static/img/me.png" {
+		t.Errorf("expected the profile pic, but got %s", got)
+	}
+}
+
+func Test_app_PostTimezone(t *testing.T) {
+	var tests = []struct {
+		name     string
+		timezone string
+		expected string
+	}{
+		{name: "valid", timezone: "Europe/Berlin", expected: "Europe/Berlin"},
+		{name: "unknown", timezone: "Mars/Olympus", expected: "UTC"},
+		{name: "server's", timezone: "Local", expected: "UTC"},
+		{name: "empty", expected: "UTC"},
+	}
+
+	for _, e := range tests {
+		req := newFormRequest("POST", "/user/timezone", url.Values{"timezone": {e.timezone}})
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostTimezone)).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if got := app.location(req).String(); got != e.expected {
+			t.Errorf("%s: expected timezone %s, but got %s", e.name, e.expected, got)
+		}
+		if e.expected == "UTC" && flashed(req, FlashError) == "" {
+			t.Errorf("%s: expected an error message", e.name)
+		}
+	}
+}
+
+func Test_app_render_funcs(t *testing.T) {
+	req := httptest.NewRequest("GET", "/admin/invitations", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), timezoneKey, "Asia/Tokyo")
+
+	rr := httptest.NewRecorder()
+	td := &TemplateData{Data: map[string]any{"Invitations": []*data.Invitation{
+		{ID: 3, Email: "new@example.com", ExpiresAt: time.Date(2024, 7, 1, 22, 30, 0, 0, time.UTC)},
+	}}}
+	if err := app.render(rr, req, "admin-invitations.page.gohtml", td); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"2 Jul 2024 07:30", `action="/admin/invitations/3/revoke"`} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected %q on the page", expected)
+		}
+	}
+}
@@ -1,19 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
-	"html/template"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 	"webapp/pkg/data"
-	"webapp/templates"
+	"webapp/pkg/forms"
+	"webapp/pkg/i18n"
+	"webapp/pkg/repository"
 )
 
-var pathToTemplates = "../../templates/"
 var uploadPath = "./static/img"
 
 func (app *application) Home(w http.ResponseWriter, r *http.Request) {
@@ -24,108 +26,249 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 	} else {
 		app.Session.Put(r.Context(), "test", "Hit this page at "+time.Now().UTC().String())
 	}
+	if app.OIDC != nil {
+		td["OIDCProvider"] = app.OIDC.Name
+	}
 	_ = app.render(w, r, "home.page.gohtml", &TemplateData{Data: td})
 }
 func (app *application) Profile(w http.ResponseWriter, r *http.Request) {
 	_ = app.render(w, r, "profile.page.gohtml", &TemplateData{})
 }
 
+// TemplateData is what pages show, and what JSON clients get instead of a page.
 type TemplateData struct {
-	IP    string
-	Data  map[string]any
-	Error string
-	Flash string
-	User  data.User
+	IP   string         `json:"ip"`
+	Data map[string]any `json:"data,omitempty"`
+	// Flashes are the messages queued for this page, see flash.
+	Flashes   []Flash   `json:"flashes,omitempty"`
+	User      data.User `json:"user"`
+	CSRFToken string    `json:"csrf_token"`
+	CSPNonce  string    `json:"-"`
+	// Roles and Permissions of the logged in user in the current Organization.
+	// Templates check permissions with hasPerm.
+	Roles         []string             `json:"roles"`
+	Permissions   []string             `json:"permissions"`
+	Organization  *data.Organization   `json:"organization,omitempty"`
+	Organizations []*data.Organization `json:"organizations,omitempty"`
+	// Form is a submitted form to show again, with its errors next to the fields;
+	// render fills in one kept by flashForm.
+	Form *forms.Form `json:"-"`
+	// Locale the page is in, out of Locales.
+	Locale  string   `json:"locale"`
+	Locales []string `json:"locales"`
+	// Timezone dates are shown in.
+	Timezone string `json:"timezone"`
 }
 
+// render shows a page, or sends its data as JSON to clients that asked for that.
+// htmx requests get just a block of the page, see fragment, with the flashes the
+// layout would have shown triggered instead.
 func (app *application) render(w http.ResponseWriter, r *http.Request, t string, td *TemplateData) error {
+	return app.renderStatus(w, r, http.StatusOK, t, td)
+}
 
-	parsedTemplate, err := template.ParseFS(templates.Html, "html/"+t, "html/base.layout.gohtml")
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "rendering bad request", http.StatusBadRequest)
-		return err
-	}
+// renderStatus is render with a status other than 200 OK.
+func (app *application) renderStatus(w http.ResponseWriter, r *http.Request, status int, t string, td *TemplateData) error {
+	w.Header().Add("Vary", "Accept, HX-Request")
 
-	td.IP = app.ipFromContext(r.Context())
+	var err error
+	td.IP = "unknown"
+	if ip := app.ipFromContext(r.Context()); ip != nil {
+		td.IP = ip.String()
+	}
 
-	td.Error = app.Session.PopString(r.Context(), "error")
-	td.Flash = app.Session.PopString(r.Context(), "flash")
+	td.Locale = app.printer(r).Locale
+	td.Locales = i18n.Messages.Locales()
+	td.Timezone = app.location(r).String()
+	td.CSRFToken = app.csrfToken(r.Context())
+	td.CSPNonce = app.nonceFromContext(r.Context())
+	td.Flashes = app.popFlashes(r.Context())
+	if td.Form == nil {
+		td.Form = app.popForm(r.Context())
+	}
 
 	if app.Session.Exists(r.Context(), "user") {
 		td.User = app.Session.Get(r.Context(), "user").(data.User)
+		// in one unit of work, so the organization is set once for both
+		err = app.repo(r).WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+			roles, err := repo.GetUserRoles(td.User.ID)
+			if err != nil {
+				return err
+			}
+			permissions, err := repo.GetUserPermissions(td.User.ID)
+			if err != nil {
+				return err
+			}
+			td.Roles, td.Permissions = roles, permissions
+			return nil
+		})
+		if err != nil {
+			log.Println(err)
+		}
+		if td.Organizations, err = app.DB.GetUserOrganizations(td.User.ID); err != nil {
+			log.Println(err)
+		}
+		current := app.Session.GetInt(r.Context(), organizationKey)
+		for _, o := range td.Organizations {
+			if o.ID == current {
+				td.Organization = o
+			}
+		}
+	}
+
+	if wantsJSON(r) {
+		renderJSON(w, status, td)
+		return nil
 	}
 
-	err = parsedTemplate.Execute(w, td)
+	parsedTemplate, err := app.Templates.page(t)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		app.renderFailed(w, r, t, err)
+		return err
+	}
+	parsedTemplate.Funcs(app.requestFuncs(r, td))
+
+	name := t
+	if isHTMX(r) {
+		name = fragment(r, parsedTemplate)
+		triggerMessages(w, td.Flashes...)
+	}
+
+	// a page that fails halfway mustn't be sent
+	var buf bytes.Buffer
+	if err = parsedTemplate.ExecuteTemplate(&buf, name, td); err != nil {
+		app.renderFailed(w, r, t, err)
 		return err
 	}
-	return nil
+	w.WriteHeader(status)
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// renderFailed serves the error page for a page that couldn't be rendered, unless
+// that was the error page.
+func (app *application) renderFailed(w http.ResponseWriter, r *http.Request, t string, err error) {
+	if t != errorPage {
+		app.serveError(w, r, err)
+		return
+	}
+	log.Println(err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
 func (app *application) Login(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+	values, err := formValues(r)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "bad request", http.StatusBadRequest)
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
 		return
 	}
 
 	//validate data
 
-	form := NewForm(r.PostForm)
+	form := app.form(r, values)
 	form.Required("email", "password")
 
 	if !form.Valid() {
 		//redirect to a login page with an error message
-		app.Session.Put(r.Context(), "error", "invalid login credentials")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		app.fail(w, r, http.StatusUnprocessableEntity, app.T(r, "invalid login credentials"), "/")
 		return
 
 	}
 
-	email := r.Form.Get("email")
-	password := r.Form.Get("password")
+	email := form.Data.Get("email")
+	password := form.Data.Get("password")
 
 	user, err := app.DB.GetUserByEmail(email)
 	if err != nil {
-		app.Session.Put(r.Context(), "error", "invalid login")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		app.fail(w, r, http.StatusUnauthorized, app.T(r, "invalid login"), "/")
 		return
 	}
 
 	if !app.authenticate(r, user, password) {
-		app.Session.Put(r.Context(), "error", "invalid login")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		app.fail(w, r, http.StatusUnauthorized, app.T(r, "invalid login"), "/")
 		return
 	}
 
-	//authenticate the user
-	//if not authenticated - then redirect to the home page with an error
+	app.completeLogin(w, r, user)
+}
 
-	//renew the user's token
-	_ = app.Session.RenewToken(r.Context())
+// completeLogin finishes a login once the user proved who they are, by password or
+// by a mailed link.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	if !user.IsActive() {
+		app.fail(w, r, http.StatusForbidden, app.T(r, "this account has been deactivated"), "/")
+		return
+	}
+
+	//enrolled users still have to pass the second factor before they are logged in
+	if user.TOTPEnabled {
+		_ = app.Session.RenewToken(r.Context())
+		app.Session.Put(r.Context(), twoFactorUserKey, user.ID)
+		app.redirect(w, r, "/login/2fa", "")
+		return
+	}
+
+	app.logIn(r, user)
+
+	if app.RequireAdmin2FA && app.hasRole(user.ID, data.RoleAdmin) {
+		app.Session.Put(r.Context(), twoFactorSetupRequiredKey, true)
+		app.flash(r.Context(), FlashWarning, app.T(r, "administrators must set up two-factor authentication"))
+		app.redirect(w, r, "/user/2fa/setup", "")
+		return
+	}
 
 	//redirect to some other page
-	app.Session.Put(r.Context(), "flash message", "successfully logged in")
-	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+	app.redirect(w, r, app.afterLoginURL(r), app.T(r, "successfully logged in"))
+}
+
+// afterLoginURL returns where a page that required a login asked to be sent back to,
+// or the profile page.
+func (app *application) afterLoginURL(r *http.Request) string {
+	target := app.Session.PopString(r.Context(), redirectAfterLoginKey)
+	if strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+		return target
+	}
+	return "/user/profile"
 }
 
 func (app *application) authenticate(r *http.Request, user *data.User, password string) bool {
 	if valid, err := user.PasswordMatches(password); err != nil || !valid {
 		return false
 	}
-	app.Session.Put(r.Context(), "user", user)
+
+	// while we have the password, upgrade a hash made by an older policy
+	if app.Passwords.NeedsRehash(user.Password) {
+		hash, err := app.Passwords.Hash(password)
+		if err == nil {
+			err = app.DB.SetPasswordHash(user.ID, hash)
+		}
+		if err != nil {
+			log.Println("upgrading password hash of user", user.ID, err)
+		}
+	}
+
 	return true
 }
 
+// logIn puts the user into a fresh session.
+func (app *application) logIn(r *http.Request, user *data.User) {
+	_ = app.Session.RenewToken(r.Context())
+	app.renewCSRFToken(r.Context())
+	app.Session.Put(r.Context(), "user", *user)
+	app.Session.Put(r.Context(), activeCheckedKey, time.Now().Unix())
+	app.selectOrganization(r, user)
+}
+
 func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request) {
 	// call a function that extracts a file from an upload (request)
 	files, err := app.UploadFiles(r, uploadPath)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Println(err)
+		app.fail(w, r, http.StatusBadRequest, app.T(r, "the image could not be uploaded, it may be larger than 5 MB"), "/user/profile")
+		return
+	}
+	if len(files) == 0 {
+		app.fail(w, r, http.StatusBadRequest, app.T(r, "choose an image to upload"), "/user/profile")
 		return
 	}
 	// get the user from the session
@@ -139,16 +282,23 @@ func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request)
 	// insert UserImage into user_images
 	_, err = app.DB.InsertUserImage(userImage)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		app.serveError(w, r, err)
 		return
 	}
 	// refresh the session variable "user"
 	updatedUser, err := app.DB.GetUser(user.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		app.serveError(w, r, err)
+		return
+	}
+	app.Session.Put(r.Context(), "user", *updatedUser)
+
+	// htmx swaps in the new picture
+	if isHTMX(r) {
+		app.flash(r.Context(), FlashSuccess, app.T(r, "your profile picture was updated"))
+		_ = app.render(w, r, "profile.page.gohtml", &TemplateData{})
 		return
 	}
-	app.Session.Put(r.Context(), "user", updatedUser)
 	//redirect back to profile page
 	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 }
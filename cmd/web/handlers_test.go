@@ -5,10 +5,12 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"github.com/go-chi/chi/v5"
 	"image"
 	"image/png"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -18,6 +20,7 @@ import (
 	"sync"
 	"testing"
 	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
 )
 
 func Test_application_handlers(t *testing.T) {
@@ -109,8 +112,11 @@ func TestAppHome(t *testing.T) {
 }
 
 func TestApp_renderWithBadTemplate(t *testing.T) {
-	//set pathToTemplates to a location with a bad template
-	pathToTemplates = "./testdata/"
+	//a location with a bad template fails when the templates are loaded
+	if _, err := newTemplateCache(templateFS("./testdata/"), false); err == nil {
+		t.Errorf("expected error from bad template but did not get one")
+	}
+
 	req, _ := http.NewRequest("GET", "/", nil)
 	//add the context and session
 	req = addContextAndSessionToRequest(req, app)
@@ -119,15 +125,15 @@ func TestApp_renderWithBadTemplate(t *testing.T) {
 	//set err to app.render
 	err := app.render(rr, req, "bad.page.gohtml", &TemplateData{})
 	if err == nil {
-		t.Errorf("expected error from bad template but did not get one")
+		t.Errorf("expected error from a missing template but did not get one")
+	}
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, but got %d", http.StatusInternalServerError, rr.Code)
 	}
-
-	pathToTemplates = "./../../templates/"
-
 }
 
 func getCtx(req *http.Request) context.Context {
-	return context.WithValue(req.Context(), contextUserKey, "any context")
+	return context.WithValue(req.Context(), contextUserKey, net.ParseIP("127.0.0.1"))
 }
 
 func addContextAndSessionToRequest(req *http.Request, app application) *http.Request {
@@ -137,6 +143,39 @@ func addContextAndSessionToRequest(req *http.Request, app application) *http.Req
 	return req.WithContext(ctx)
 }
 
+// newFormRequest builds a url-encoded form request with a loaded session and a valid
+// CSRF token, so it passes the csrf middleware.
+func newFormRequest(method, target string, form url.Values) *http.Request {
+	req, _ := http.NewRequest(method, target, nil)
+	req = addContextAndSessionToRequest(req, app)
+
+	posted := url.Values{}
+	for key, values := range form {
+		posted[key] = values
+	}
+	posted.Set(csrfFormField, app.csrfToken(req.Context()))
+
+	body := posted.Encode()
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// addCSRFHeader sets the session's CSRF token as a header, for requests whose body
+// we don't want to touch (multipart uploads, JSON).
+func addCSRFHeader(req *http.Request) *http.Request {
+	req.Header.Set(csrfHeader, app.csrfToken(req.Context()))
+	return req
+}
+
+// withURLParam sets a chi URL parameter, for calling handlers without the router.
+func withURLParam(req *http.Request, key, value string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add(key, value)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
 func Test_app_Login(t *testing.T) {
 	var tests = []struct {
 		name               string
@@ -189,14 +228,30 @@ func Test_app_Login(t *testing.T) {
 			expectedStatusCode: http.StatusSeeOther,
 			expectedLoc:        "/user/profile",
 		},
+		{
+			name: "suspended user",
+			postedData: url.Values{
+				"email":    {"suspended@example.com"},
+				"password": {"secret"},
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLoc:        "/",
+		},
+		{
+			name: "deleted user",
+			postedData: url.Values{
+				"email":    {"deleted@example.com"},
+				"password": {"secret"},
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLoc:        "/",
+		},
 	}
 
 	for _, e := range tests {
-		req, _ := http.NewRequest("POST", "/login", strings.NewReader(e.postedData.Encode()))
-		req = addContextAndSessionToRequest(req, app)
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req := newFormRequest("POST", "/login", e.postedData)
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(app.Login)
+		handler := app.csrf(http.HandlerFunc(app.Login))
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
@@ -212,6 +267,48 @@ func Test_app_Login(t *testing.T) {
 		} else {
 			t.Errorf("%s: no location header set", e.name)
 		}
+		if e.expectedLoc == "/user/profile" && flashed(req, FlashSuccess) != "successfully logged in" {
+			t.Errorf("%s: expected a flash for the login", e.name)
+		}
+	}
+}
+
+// rehashRepo records the password hashes stored by SetPasswordHash.
+type rehashRepo struct {
+	dbrepo.TestDBRepo
+	hashes map[int]string
+}
+
+func (m *rehashRepo) SetPasswordHash(id int, hash string) error {
+	m.hashes[id] = hash
+	return nil
+}
+
+func Test_app_authenticate(t *testing.T) {
+	repo := &rehashRepo{hashes: map[int]string{}}
+	oldDB := app.DB
+	app.DB = repo
+	defer func() { app.DB = oldDB }()
+
+	req := httptest.NewRequest("POST", "/login", nil)
+	user, _ := repo.GetUserByEmail("admin@example.com")
+
+	if app.authenticate(req, user, "wrong") || len(repo.hashes) != 0 {
+		t.Error("expected a wrong password to be refused without touching the hash")
+	}
+
+	// the seeded hash is bcrypt, which the default policy upgrades to argon2id
+	if !app.authenticate(req, user, "secret") {
+		t.Fatal("expected the right password to be accepted")
+	}
+	if !strings.HasPrefix(repo.hashes[1], "$argon2id$") {
+		t.Errorf("expected the hash to be upgraded, but got %q", repo.hashes[1])
+	}
+
+	user.Password = repo.hashes[1]
+	delete(repo.hashes, 1)
+	if !app.authenticate(req, user, "secret") || len(repo.hashes) != 0 {
+		t.Error("expected an up to date hash to be kept")
 	}
 }
 
@@ -315,10 +412,11 @@ func Test_app_UploadProfilePic(t *testing.T) {
 	request = addContextAndSessionToRequest(request, app)
 	app.Session.Put(request.Context(), "user", data.User{ID: 1})
 	request.Header.Add("Content-type", multiWriter.FormDataContentType())
+	request = addCSRFHeader(request)
 
 	rr := httptest.NewRecorder()
 
-	handler := http.HandlerFunc(app.UploadProfilePic)
+	handler := app.csrf(http.HandlerFunc(app.UploadProfilePic))
 	handler.ServeHTTP(rr, request)
 
 	if rr.Code != http.StatusSeeOther {
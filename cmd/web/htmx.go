@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+)
+
+// isHTMX reports whether htmx asked for part of a page to swap in. Boosted links and
+// forms load whole pages, so they don't count.
+func isHTMX(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true" && r.Header.Get("HX-Boosted") != "true"
+}
+
+// fragment picks the block of a page an htmx request gets: the one named after the
+// id of the element it swaps, or else the content block.
+func fragment(r *http.Request, t *template.Template) string {
+	if target := r.Header.Get("HX-Target"); target != "" && t.Lookup(target) != nil {
+		return target
+	}
+	return "content"
+}
+
+// triggerMessages has htmx show messages once it got the response, see the messages
+// script of the base layout.
+func triggerMessages(w http.ResponseWriter, messages ...Flash) {
+	if len(messages) == 0 {
+		return
+	}
+	b, _ := json.Marshal(map[string]any{"messages": messages})
+	w.Header().Set("HX-Trigger", string(b))
+}
+
+// htmxRedirect has htmx load a whole page instead of swapping in a response.
+func htmxRedirect(w http.ResponseWriter, to string) {
+	w.Header().Set("HX-Redirect", to)
+	w.WriteHeader(http.StatusOK)
+}
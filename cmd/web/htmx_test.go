@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+)
+
+func Test_app_render_htmx(t *testing.T) {
+	var tests = []struct {
+		name        string
+		headers     map[string]string
+		expected    string
+		notExpected string
+	}{
+		{name: "target block", headers: map[string]string{"HX-Request": "true", "HX-Target": "profile-pic"}, expected: `<div id="profile-pic">`, notExpected: "<html"},
+		{name: "content", headers: map[string]string{"HX-Request": "true", "HX-Target": "nothing-like-it"}, expected: "Change password", notExpected: "<html"},
+		{name: "boosted", headers: map[string]string{"HX-Request": "true", "HX-Boosted": "true"}, expected: "<html"},
+		{name: "page", expected: "<html"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/user/profile", nil)
+		for k, v := range e.headers {
+			req.Header.Set(k, v)
+		}
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		app.flash(req.Context(), FlashSuccess, "saved")
+		rr := httptest.NewRecorder()
+
+		if err := app.render(rr, req, "profile.page.gohtml", &TemplateData{}); err != nil {
+			t.Fatal(err)
+		}
+
+		body := rr.Body.String()
+		if !strings.Contains(body, e.expected) {
+			t.Errorf("%s: expected %q in the response", e.name, e.expected)
+		}
+		if e.notExpected != "" && strings.Contains(body, e.notExpected) {
+			t.Errorf("%s: didn't expect %q in the response", e.name, e.notExpected)
+		}
+		if trigger := rr.Header().Get("HX-Trigger"); (trigger != "") != (e.notExpected != "") {
+			t.Errorf("%s: expected the flash triggered only for fragments, but got %q", e.name, trigger)
+		}
+	}
+}
+
+func Test_app_Login_htmx(t *testing.T) {
+	var tests = []struct {
+		name               string
+		password           string
+		expectedStatusCode int
+		expectedHeader     string
+		expectedValue      string
+	}{
+		{name: "valid login", password: "secret", expectedStatusCode: http.StatusOK, expectedHeader: "HX-Redirect", expectedValue: "/user/profile"},
+		{name: "bad credentials", password: "imperium", expectedStatusCode: http.StatusUnauthorized, expectedHeader: "HX-Trigger", expectedValue: `{"messages":[{"level":"error","message":"invalid login"}]}`},
+	}
+
+	for _, e := range tests {
+		req := newFormRequest("POST", "/login", url.Values{"email": {"admin@example.com"}, "password": {e.password}})
+		req.Header.Set("HX-Request", "true")
+		rr := httptest.NewRecorder()
+
+		app.csrf(http.HandlerFunc(app.Login)).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if got := rr.Header().Get(e.expectedHeader); got != e.expectedValue {
+			t.Errorf("%s: expected %s %s, but got %q", e.name, e.expectedHeader, e.expectedValue, got)
+		}
+	}
+}
+
+func Test_app_UploadProfilePic_htmx(t *testing.T) {
+	body := new(bytes.Buffer)
+	multiWriter := multipart.NewWriter(body)
+	_ = multiWriter.WriteField("note", "no image")
+	multiWriter.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/user/upload-profile-pic", body)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: 1})
+	req.Header.Set("Content-Type", multiWriter.FormDataContentType())
+	req.Header.Set("HX-Request", "true")
+	req.Header.Set("HX-Target", "profile-pic")
+	req = addCSRFHeader(req)
+	rr := httptest.NewRecorder()
+
+	app.csrf(http.HandlerFunc(app.UploadProfilePic)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	if !strings.Contains(rr.Header().Get("HX-Trigger"), "choose an image to upload") {
+		t.Errorf("expected the error triggered, but got %q", rr.Header().Get("HX-Trigger"))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"webapp/pkg/forms"
+	"webapp/pkg/i18n"
+)
+
+// localeKey is the session key of the locale a user picked over their browser's.
+const localeKey = "locale"
+
+// printer translates into the locale of the request: the one the user picked, or
+// else the best fit for their Accept-Language header.
+func (app *application) printer(r *http.Request) *i18n.Printer {
+	if locale := app.Session.GetString(r.Context(), localeKey); i18n.Messages.Supports(locale) {
+		return i18n.Messages.Printer(locale)
+	}
+	return i18n.Messages.Printer(i18n.Messages.Negotiate(r.Header.Get("Accept-Language")))
+}
+
+// T translates a message into the locale of the request, see i18n.Printer.T.
+func (app *application) T(r *http.Request, key string, args ...any) string {
+	return app.printer(r).T(key, args...)
+}
+
+// form makes a form whose error messages are in the locale of the request.
+func (app *application) form(r *http.Request, data url.Values) *forms.Form {
+	form := forms.New(data)
+	form.Translate = app.printer(r).T
+	return form
+}
+
+// PostLocale remembers the locale a user picked for the rest of their session, and
+// sends them back to the page they picked it on.
+func (app *application) PostLocale(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	form := app.form(r, r.PostForm)
+	form.Required("locale")
+	form.In("locale", i18n.Messages.Locales()...)
+	if !form.Valid() {
+		app.serveError(w, r, httpError(http.StatusBadRequest, nil))
+		return
+	}
+	app.Session.Put(r.Context(), localeKey, form.Data.Get("locale"))
+
+	// only ever back to a page of ours
+	back := "/"
+	if referer, err := url.Parse(r.Referer()); err == nil && referer.Host == r.Host &&
+		strings.HasPrefix(referer.Path, "/") && !strings.HasPrefix(referer.Path, "//") {
+		back = (&url.URL{Path: referer.Path, RawQuery: referer.RawQuery}).String()
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
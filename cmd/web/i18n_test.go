@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/i18n"
+	"webapp/templates"
+)
+
+func Test_templates_translated(t *testing.T) {
+	keys, err := i18n.TemplateKeys(templates.Html, "html/*.gohtml", "html/partials/*.gohtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := i18n.Messages.Missing(keys...); len(missing) != 0 {
+		t.Errorf("missing translations, see go run ./cmd/cli -action=check-translations: %v", missing)
+	}
+}
+
+func Test_app_printer(t *testing.T) {
+	var tests = []struct {
+		name           string
+		acceptLanguage string
+		picked         string
+		expected       string
+	}{
+		{name: "default", expected: "en"},
+		{name: "browser", acceptLanguage: "de-DE,de;q=0.9", expected: "de"},
+		{name: "picked", acceptLanguage: "de-DE,de;q=0.9", picked: "en", expected: "en"},
+		{name: "picked unsupported", acceptLanguage: "de", picked: "xx", expected: "de"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Language", e.acceptLanguage)
+		req = addContextAndSessionToRequest(req, app)
+		if e.picked != "" {
+			app.Session.Put(req.Context(), localeKey, e.picked)
+		}
+
+		if got := app.printer(req).Locale; got != e.expected {
+			t.Errorf("%s: expected %s, but got %s", e.name, e.expected, got)
+		}
+	}
+}
+
+func Test_app_PostLocale(t *testing.T) {
+	var tests = []struct {
+		name               string
+		locale             string
+		referer            string
+		expectedStatusCode int
+		expectedLoc        string
+	}{
+		{name: "back", locale: "de", referer: "http://example.com/user/profile?tab=1", expectedStatusCode: http.StatusSeeOther, expectedLoc: "/user/profile?tab=1"},
+		{name: "other site", locale: "de", referer: "https://evil.example/", expectedStatusCode: http.StatusSeeOther, expectedLoc: "/"},
+		{name: "scheme-relative", locale: "de", referer: "http://example.com//evil.example/", expectedStatusCode: http.StatusSeeOther, expectedLoc: "/"},
+		{name: "unsupported", locale: "xx", expectedStatusCode: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req := newFormRequest("POST", "/locale", url.Values{"locale": {e.locale}})
+		req.Host = "example.com"
+		req.Header.Set("Referer", e.referer)
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostLocale)).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if e.expectedLoc == "" {
+			continue
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLoc {
+			t.Errorf("%s: expected location %s, but got %s", e.name, e.expectedLoc, loc)
+		}
+		if got := app.Session.GetString(req.Context(), localeKey); got != e.locale {
+			t.Errorf("%s: expected locale %s in the session, but got %q", e.name, e.locale, got)
+		}
+	}
+}
+
+func Test_app_translatedForm(t *testing.T) {
+	form := url.Values{"token": {"valid-invitation"}, "first_name": {"New"}, "last_name": {"User"}, "password": {"short"}}
+	req := newFormRequest("POST", "/invitations/accept", form)
+	req.Header.Set("Accept-Language", "de")
+	rr := httptest.NewRecorder()
+	app.csrf(http.HandlerFunc(app.PostAcceptInvitation)).ServeHTTP(rr, req)
+
+	for _, expected := range []string{`<html lang="de">`, "Verwenden Sie mindestens 8 Zeichen", "Konto anlegen"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected %q on the page", expected)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/repository"
+)
+
+// errInvalidInvitation is returned when an invitation to accept is unknown, used or expired.
+var errInvalidInvitation = fmt.Errorf("invalid invitation")
+
+// sendInvitation stores an invitation and mails its link. The link is the only
+// copy of the token.
+func (app *application) sendInvitation(i data.Invitation) error {
+	token, hash, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	i.TokenHash = hash
+	i.ExpiresAt = time.Now().Add(data.InvitationLifetime)
+	if _, err = app.DB.InsertInvitation(i); err != nil {
+		return err
+	}
+
+	return app.Mailer.Send(mailer.Message{
+		To:      i.Email,
+		Subject: "You're invited to join " + i.OrganizationName,
+		Body: fmt.Sprintf("You've been invited to join %s. Use this link to set up your account. It expires in %d days.\n\n%s/invitations/%s\n",
+			i.OrganizationName, int(data.InvitationLifetime.Hours()/24), app.BaseURL, token),
+	})
+}
+
+// AdminInvitations lists the pending invitations of the current organization.
+func (app *application) AdminInvitations(w http.ResponseWriter, r *http.Request) {
+	invitations, err := app.DB.PendingInvitations(app.Session.GetInt(r.Context(), organizationKey))
+	if err != nil {
+		log.Println(err)
+	}
+
+	roles, err := app.DB.AllRoles()
+	if err != nil {
+		log.Println(err)
+	}
+
+	td := map[string]any{"Invitations": invitations, "Roles": roles}
+	_ = app.render(w, r, "admin-invitations.page.gohtml", &TemplateData{Data: td})
+}
+
+// PostAdminInvitation invites someone to the current organization. Only admins who
+// may change roles can pick the role the invitee starts with.
+func (app *application) PostAdminInvitation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	form := app.form(r, r.PostForm)
+	form.Required("email")
+	form.Email("email")
+	if !form.Valid() {
+		app.flashForm(r.Context(), form)
+		app.invitationError(w, r, "enter a valid address to invite")
+		return
+	}
+
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	organization := app.currentOrganization(r, user.ID)
+	if organization == nil {
+		app.invitationError(w, r, "switch to an organization first")
+		return
+	}
+
+	if form.Data.Get("role") != "" {
+		permissions, err := app.repo(r).GetUserPermissions(user.ID)
+		if err != nil {
+			log.Println(err)
+		}
+		if !slices.Contains(permissions, data.PermissionRolesWrite) {
+			app.invitationError(w, r, "you can't hand out roles")
+			return
+		}
+	}
+
+	if _, err = app.DB.GetUserByEmail(form.Data.Get("email")); err == nil {
+		app.invitationError(w, r, "that address already has an account")
+		return
+	}
+
+	err = app.sendInvitation(data.Invitation{
+		OrganizationID:   organization.ID,
+		OrganizationName: organization.Name,
+		Email:            form.Data.Get("email"),
+		Role:             form.Data.Get("role"),
+		InvitedBy:        user.ID,
+	})
+	if err != nil {
+		log.Println(err)
+		app.invitationError(w, r, "could not send the invitation")
+		return
+	}
+
+	app.flash(r.Context(), FlashSuccess, app.T(r, "invitation sent to %s", form.Data.Get("email")))
+	http.Redirect(w, r, "/admin/invitations", http.StatusSeeOther)
+}
+
+func (app *application) invitationError(w http.ResponseWriter, r *http.Request, msg string) {
+	app.flash(r.Context(), FlashError, app.T(r, msg))
+	http.Redirect(w, r, "/admin/invitations", http.StatusSeeOther)
+}
+
+// PostAdminRevokeInvitation withdraws a pending invitation of the current organization.
+func (app *application) PostAdminRevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	if err = app.DB.RevokeInvitation(app.Session.GetInt(r.Context(), organizationKey), id); err != nil {
+		log.Println(err)
+		app.invitationError(w, r, "could not revoke that invitation")
+		return
+	}
+
+	app.flash(r.Context(), FlashSuccess, app.T(r, "invitation revoked"))
+	http.Redirect(w, r, "/admin/invitations", http.StatusSeeOther)
+}
+
+// Invitation shows the form an invitee sets up their account with.
+func (app *application) Invitation(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	invitation, err := app.DB.GetInvitation(hashToken(token))
+	if err != nil {
+		app.flash(r.Context(), FlashError, app.T(r, "this invitation is invalid or has expired"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	td := map[string]any{"Token": token, "Invitation": invitation}
+	_ = app.render(w, r, "invitation.page.gohtml", &TemplateData{Data: td})
+}
+
+// PostAcceptInvitation creates the invitee's account, in the organization and with
+// the role they were invited to.
+func (app *application) PostAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	form := app.form(r, r.PostForm)
+	form.Required("token", "first_name", "last_name", "password")
+	form.MaxLength("first_name", 255)
+	form.MaxLength("last_name", 255)
+	invitation, err := app.DB.GetInvitation(hashToken(form.Data.Get("token")))
+	if err != nil {
+		app.flash(r.Context(), FlashError, app.T(r, "this invitation is invalid or has expired"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if form.Has("password") {
+		app.checkPassword(r, form, "password", 0, form.Data.Get("first_name"), form.Data.Get("last_name"), invitation.Email)
+	}
+	if !form.Valid() {
+		td := map[string]any{"Token": form.Data.Get("token"), "Invitation": invitation}
+		_ = app.render(w, r, "invitation.page.gohtml", &TemplateData{Data: td, Form: form})
+		return
+	}
+
+	// the invitation is only used up once the account exists, with its membership and role
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		invitation, err = repo.ConsumeInvitation(hashToken(form.Data.Get("token")))
+		if err != nil {
+			return errInvalidInvitation
+		}
+
+		id, err := repo.InsertUser(data.User{
+			Email:     invitation.Email,
+			FirstName: form.Data.Get("first_name"),
+			LastName:  form.Data.Get("last_name"),
+			Password:  form.Data.Get("password"),
+		})
+		if err != nil {
+			return err
+		}
+
+		if err = repo.AddOrganizationMember(invitation.OrganizationID, id); err != nil {
+			return err
+		}
+		if invitation.Role != "" {
+			return repo.ForOrganization(invitation.OrganizationID).AssignUserRole(id, invitation.Role)
+		}
+		return nil
+	})
+	if err == errInvalidInvitation {
+		app.flash(r.Context(), FlashError, app.T(r, "this invitation is invalid or has expired"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		app.flash(r.Context(), FlashError, app.T(r, "could not create your account"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.flash(r.Context(), FlashSuccess, app.T(r, "welcome to %s, log in with your new password", invitation.OrganizationName))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_app_PostAdminInvitation(t *testing.T) {
+	mail := app.Mailer.(*testMailer)
+
+	var tests = []struct {
+		name          string
+		organization  int
+		form          url.Values
+		expectedSent  int
+		expectedError bool
+	}{
+		{name: "with role", organization: 1, form: url.Values{"email": {"new@example.com"}, "role": {"viewer"}}, expectedSent: 1},
+		{name: "without role", organization: 1, form: url.Values{"email": {"new@example.com"}}, expectedSent: 1},
+		{name: "existing account", organization: 1, form: url.Values{"email": {"admin@example.com"}}, expectedError: true},
+		{name: "no email", organization: 1, form: url.Values{"email": {""}}, expectedError: true},
+		{name: "unknown role", organization: 1, form: url.Values{"email": {"new@example.com"}, "role": {"owner"}}, expectedError: true},
+		{name: "role without roles:write", organization: 2, form: url.Values{"email": {"new@example.com"}, "role": {"admin"}}, expectedError: true},
+		{name: "no organization", form: url.Values{"email": {"new@example.com"}}, expectedError: true},
+	}
+
+	for _, e := range tests {
+		mail.sent = nil
+
+		req := newFormRequest("POST", "/admin/invitations", e.form)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		if e.organization != 0 {
+			app.Session.Put(req.Context(), organizationKey, e.organization)
+		}
+
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostAdminInvitation)).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status 303, but got %d", e.name, rr.Code)
+		}
+		if hasError := flashed(req, FlashError) != ""; hasError != e.expectedError {
+			t.Errorf("%s: expected error %v, but got %v", e.name, e.expectedError, hasError)
+		}
+		if len(mail.sent) != e.expectedSent {
+			t.Errorf("%s: expected %d mails, but got %d", e.name, e.expectedSent, len(mail.sent))
+		}
+		if e.expectedSent > 0 && !strings.Contains(mail.sent[0].Body, "https://example.com/invitations/") {
+			t.Errorf("%s: expected an invite link in the mail, but got %q", e.name, mail.sent[0].Body)
+		}
+	}
+}
+
+func Test_app_PostAdminRevokeInvitation(t *testing.T) {
+	for _, id := range []string{"1", "2"} {
+		req := newFormRequest("POST", "/admin/invitations/"+id+"/revoke", url.Values{})
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		app.Session.Put(req.Context(), organizationKey, 1)
+		req = withURLParam(req, "id", id)
+
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostAdminRevokeInvitation)).ServeHTTP(rr, req)
+
+		if hasError := flashed(req, FlashError) != ""; hasError != (id == "2") {
+			t.Errorf("%s: unexpected error %q", id, flashed(req, FlashError))
+		}
+	}
+}
+
+func Test_app_Invitation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+	}{
+		{name: "pending", token: dbrepo.TestInvitationToken, expectedStatusCode: http.StatusOK},
+		{name: "unknown", token: "some-token", expectedStatusCode: http.StatusSeeOther},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/invitations/"+e.token, nil)
+		req = addContextAndSessionToRequest(req, app)
+		rr := httptest.NewRecorder()
+
+		app.routes().ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedStatusCode == http.StatusOK && !strings.Contains(rr.Body.String(), "Join Acme") {
+			t.Errorf("%s: expected the organization on the page", e.name)
+		}
+	}
+}
+
+func Test_app_PostAcceptInvitation(t *testing.T) {
+	var tests = []struct {
+		name          string
+		form          url.Values
+		expectedLoc   string
+		expectedError string
+		expectFlash   bool
+	}{
+		{
+			name:        "valid",
+			form:        url.Values{"token": {dbrepo.TestInvitationToken}, "first_name": {"New"}, "last_name": {"User"}, "password": {"correct horse battery staple"}},
+			expectedLoc: "/",
+			expectFlash: true,
+		},
+		{
+			name:          "missing password",
+			form:          url.Values{"token": {dbrepo.TestInvitationToken}, "first_name": {"New"}, "last_name": {"User"}},
+			expectedError: "This field cannot be blank",
+		},
+		{
+			name:          "short password",
+			form:          url.Values{"token": {dbrepo.TestInvitationToken}, "first_name": {"New"}, "last_name": {"User"}, "password": {"secret"}},
+			expectedError: "Use at least 8 characters",
+		},
+		{
+			name:        "unknown token",
+			form:        url.Values{"token": {"other-token"}, "first_name": {"New"}, "last_name": {"User"}, "password": {"correct horse battery staple"}},
+			expectedLoc: "/",
+		},
+	}
+
+	for _, e := range tests {
+		req := newFormRequest("POST", "/invitations/accept", e.form)
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostAcceptInvitation)).ServeHTTP(rr, req)
+
+		if e.expectedError != "" {
+			if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), e.expectedError) {
+				t.Errorf("%s: expected the form again with %q, but got %d", e.name, e.expectedError, rr.Code)
+			}
+			continue
+		}
+		if loc, _ := rr.Result().Location(); loc == nil || loc.String() != e.expectedLoc {
+			t.Errorf("%s: expected location %s, but got %v", e.name, e.expectedLoc, loc)
+		}
+		if hasFlash := flashed(req, FlashSuccess) != ""; hasFlash != e.expectFlash {
+			t.Errorf("%s: expected flash %v, but got %v", e.name, e.expectFlash, hasFlash)
+		}
+	}
+}
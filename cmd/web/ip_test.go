@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_parseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.1 ,, 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 3 {
+		t.Errorf("expected 3 networks, but got %d", len(proxies))
+	}
+
+	_, err = parseTrustedProxies("10.0.0.0/8,not-an-ip")
+	if err == nil {
+		t.Error("expected an error for a bad proxy entry")
+	}
+}
+
+func Test_application_getIP(t *testing.T) {
+	proxies, _ := parseTrustedProxies("10.0.0.0/8,2001:db8::/32")
+	testApp := app
+	testApp.TrustedProxies = proxies
+
+	var tests = []struct {
+		name       string
+		remoteAddr string
+		header     string
+		value      string
+		expectedIP string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:1234", expectedIP: "203.0.113.7"},
+		{name: "untrusted peer spoofing", remoteAddr: "203.0.113.7:1234", header: "X-Forwarded-For", value: "1.2.3.4", expectedIP: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.2:1234", header: "X-Forwarded-For", value: "198.51.100.9", expectedIP: "198.51.100.9"},
+		{name: "spoofed chain", remoteAddr: "10.0.0.2:1234", header: "X-Forwarded-For", value: "1.2.3.4, 198.51.100.9, 10.0.0.3", expectedIP: "198.51.100.9"},
+		{name: "only proxies", remoteAddr: "10.0.0.2:1234", header: "X-Forwarded-For", value: "10.0.0.4, 10.0.0.3", expectedIP: "10.0.0.4"},
+		{name: "garbage in chain", remoteAddr: "10.0.0.2:1234", header: "X-Forwarded-For", value: "198.51.100.9, garbage", expectedIP: "10.0.0.2"},
+		{name: "no header", remoteAddr: "10.0.0.2:1234", expectedIP: "10.0.0.2"},
+		{name: "forwarded", remoteAddr: "10.0.0.2:1234", header: "Forwarded", value: `for=192.0.2.60;proto=http;by=203.0.113.43, for="10.1.1.1:8080"`, expectedIP: "192.0.2.60"},
+		{name: "forwarded ipv6", remoteAddr: "10.0.0.2:1234", header: "Forwarded", value: `For="[2001:db8:cafe::17]:4711"`, expectedIP: "2001:db8:cafe::17"},
+		{name: "forwarded obfuscated", remoteAddr: "10.0.0.2:1234", header: "Forwarded", value: `for=_hidden`, expectedIP: "10.0.0.2"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		if e.header != "" {
+			req.Header.Set(e.header, e.value)
+		}
+
+		ip, err := testApp.getIP(req)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+			continue
+		}
+		if ip.String() != e.expectedIP {
+			t.Errorf("%s: expected %s, but got %s", e.name, e.expectedIP, ip)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+)
+
+const (
+	magicLinkLifetime = 15 * time.Minute
+	// magicLinkLimit is how many links one address can request per magicLinkLifetime.
+	magicLinkLimit = 3
+)
+
+// generateToken returns a random URL-safe token and the hash we store for it.
+func generateToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// PostMagicLink mails a single-use sign-in link. The response is the same whether
+// or not the address has an account, and the link is looked up and mailed in the
+// background, so neither the answer nor its timing can be used to probe for users.
+func (app *application) PostMagicLink(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	form := app.form(r, r.PostForm)
+	form.Required("email")
+	if !form.Valid() {
+		app.flash(r.Context(), FlashError, app.T(r, "enter your email address"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	email := form.Data.Get("email")
+	queued := app.Background.Go(func() {
+		if err := app.sendMagicLink(email); err != nil {
+			log.Println(err)
+		}
+	})
+	if !queued {
+		log.Println("magic link dropped, the background queue is full")
+	}
+
+	app.flash(r.Context(), FlashInfo, app.T(r, "if that address has an account, a sign-in link is on its way"))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) sendMagicLink(email string) error {
+	user, err := app.DB.GetUserByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	token, hash, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	stored, err := app.DB.InsertUserTokenLimited(data.UserToken{
+		UserID:    user.ID,
+		TokenHash: hash,
+		Purpose:   data.TokenMagicLink,
+		ExpiresAt: time.Now().Add(magicLinkLifetime),
+	}, magicLinkLimit, time.Now().Add(-magicLinkLifetime))
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return fmt.Errorf("magic link rate limit reached for user %d", user.ID)
+	}
+
+	return app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Use this link to sign in. It works once and expires in %d minutes.\n\n%s/login/magic/%s\n",
+			int(magicLinkLifetime.Minutes()), app.BaseURL, token),
+	})
+}
+
+// MagicLink asks the user to confirm the sign-in. Following the link alone doesn't
+// log anyone in, so mail scanners that prefetch links can't burn the token.
+func (app *application) MagicLink(w http.ResponseWriter, r *http.Request) {
+	td := map[string]any{"Token": chi.URLParam(r, "token")}
+	_ = app.render(w, r, "magic-link.page.gohtml", &TemplateData{Data: td})
+}
+
+// PostMagicLinkRedeem consumes the token and logs the user in.
+func (app *application) PostMagicLinkRedeem(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	form := app.form(r, r.PostForm)
+	form.Required("token")
+
+	var user *data.User
+	if form.Valid() {
+		var token *data.UserToken
+		token, err = app.DB.ConsumeUserToken(hashToken(form.Data.Get("token")), data.TokenMagicLink)
+		if err == nil {
+			user, err = app.DB.GetUser(token.UserID)
+		}
+	}
+
+	if user == nil {
+		app.flash(r.Context(), FlashError, app.T(r, "this sign-in link is invalid or has expired"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_app_PostMagicLink(t *testing.T) {
+	mail := app.Mailer.(*testMailer)
+
+	var tests = []struct {
+		name         string
+		email        string
+		expectedSent int
+	}{
+		{name: "known user", email: "admin@example.com", expectedSent: 1},
+		{name: "unknown user", email: "nobody@example.com", expectedSent: 0},
+		{name: "rate limited", email: "totp@example.com", expectedSent: 0},
+	}
+
+	for _, e := range tests {
+		mail.sent = nil
+
+		req := newFormRequest("POST", "/login/magic", url.Values{"email": {e.email}})
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostMagicLink)).ServeHTTP(rr, req)
+		app.Background.Wait()
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status 303, but got %d", e.name, rr.Code)
+		}
+		if flashed(req, FlashInfo) == "" {
+			t.Errorf("%s: expected the same confirmation for every address", e.name)
+		}
+		if len(mail.sent) != e.expectedSent {
+			t.Errorf("%s: expected %d mails, but got %d", e.name, e.expectedSent, len(mail.sent))
+		}
+		if e.expectedSent > 0 && !strings.Contains(mail.sent[0].Body, "https://example.com/login/magic/") {
+			t.Errorf("%s: expected a sign-in link in the mail, but got %q", e.name, mail.sent[0].Body)
+		}
+	}
+}
+
+func Test_app_MagicLink(t *testing.T) {
+	req := httptest.NewRequest("GET", "/login/magic/some-token", nil)
+	req = addContextAndSessionToRequest(req, app)
+	rr := httptest.NewRecorder()
+
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200, but got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `value="some-token"`) {
+		t.Error("expected the token in the confirmation form")
+	}
+}
+
+func Test_app_PostMagicLinkRedeem(t *testing.T) {
+	var tests = []struct {
+		name        string
+		token       string
+		expectedLoc string
+		loggedIn    bool
+	}{
+		{name: "valid token", token: "valid-token", expectedLoc: "/user/profile", loggedIn: true},
+		{name: "used or unknown token", token: "other-token", expectedLoc: "/"},
+		{name: "no token", token: "", expectedLoc: "/"},
+	}
+
+	for _, e := range tests {
+		req := newFormRequest("POST", "/login/magic/redeem", url.Values{"token": {e.token}})
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostMagicLinkRedeem)).ServeHTTP(rr, req)
+
+		if loc, _ := rr.Result().Location(); loc == nil || loc.String() != e.expectedLoc {
+			t.Errorf("%s: expected location %s, but got %v", e.name, e.expectedLoc, loc)
+		}
+		if app.Session.Exists(req.Context(), "user") != e.loggedIn {
+			t.Errorf("%s: expected logged in to be %v", e.name, e.loggedIn)
+		}
+	}
+}
+
+func Test_hashToken(t *testing.T) {
+	if hashToken("valid-token") != dbrepo.TestUserTokenHash {
+		t.Error("hashToken doesn't match the stored test hash")
+	}
+
+	token, hash, err := generateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashToken(token) != hash {
+		t.Error("expected the returned hash to be the hash of the token")
+	}
+}
@@ -1,28 +1,125 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
 	"github.com/alexedwards/scs/v2"
 	"log"
+	"net"
 	"net/http"
+	"time"
+	// the timezones users pick must load on hosts without zoneinfo
+	_ "time/tzdata"
 	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/passwords"
 	"webapp/pkg/repository"
 	"webapp/pkg/repository/dbrepo"
 )
 
 type application struct {
-	DSN     string
-	DB      repository.DatabaseRepo
-	Session *scs.SessionManager
+	DSN              string
+	DB               repository.DatabaseRepo
+	Session          *scs.SessionManager
+	TrustedProxies   []*net.IPNet
+	Security         securityConfig
+	RequireAdmin2FA  bool
+	Mailer           mailer.Mailer
+	BaseURL          string
+	OIDC             *oidcClient
+	IDP              *identityProvider
+	SCIMToken        string
+	SCIMOrganization int
+	UserRetention    time.Duration
+	Passwords        passwords.Policy
+	PasswordRules    passwords.Rules
+	Templates        *templateCache
+	Background       *background
+	Exports          *background
 }
 
 func main() {
 	gob.Register(data.User{})
-	app := application{}
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5433 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
+	gob.Register([]Flash{})
+	gob.Register(flashedForm{})
+	app := application{Security: defaultSecurityConfig()}
+	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5433 user=webapp password=webapp dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated CIDRs of proxies allowed to set X-Forwarded-For / Forwarded")
+	flag.BoolVar(&app.Security.CSPReportOnly, "csp-report-only", false, "send the CSP in report-only mode")
+	flag.IntVar(&app.Security.HSTSMaxAge, "hsts-max-age", app.Security.HSTSMaxAge, "Strict-Transport-Security max-age in seconds, 0 to disable")
+	flag.BoolVar(&app.RequireAdmin2FA, "require-admin-2fa", false, "require two-factor authentication for admin accounts")
+	flag.StringVar(&app.BaseURL, "base-url", "https://localhost:8085", "public URL of the app, used in mailed links")
+	smtp := mailer.SMTPMailer{}
+	flag.StringVar(&smtp.Host, "smtp-host", "", "SMTP relay host; mail is only logged when empty")
+	flag.IntVar(&smtp.Port, "smtp-port", 587, "SMTP relay port")
+	flag.StringVar(&smtp.Username, "smtp-user", "", "SMTP username")
+	flag.StringVar(&smtp.Password, "smtp-password", "", "SMTP password")
+	flag.StringVar(&smtp.From, "mail-from", "no-reply@example.com", "sender address of outgoing mail")
+	oidcName := flag.String("oidc-name", "SSO", "name of the OpenID Connect provider shown on the login page")
+	oidcIssuer := flag.String("oidc-issuer", "", "issuer URL of an OpenID Connect provider to sign in with; disabled when empty")
+	oidcClientID := flag.String("oidc-client-id", "", "OpenID Connect client id")
+	oidcClientSecret := flag.String("oidc-client-secret", "", "OpenID Connect client secret")
+	signingKey := flag.String("oidc-signing-key", "", "PEM file with the RSA key ID tokens we issue are signed with")
+	flag.StringVar(&app.SCIMToken, "scim-token", "", "bearer token directories use for SCIM provisioning; disabled when empty")
+	flag.IntVar(&app.SCIMOrganization, "scim-organization", 0, "id of the organization SCIM provisions users into; all users when 0")
+	flag.DurationVar(&app.UserRetention, "user-retention", 30*24*time.Hour, "how long deleted users can be restored before they are purged; never purged when 0")
+	flag.StringVar(&app.Passwords.Algorithm, "password-hash", passwords.Argon2id, "algorithm new password hashes are made with: argon2id|bcrypt; older hashes are upgraded on login")
+	flag.IntVar(&app.Passwords.BcryptCost, "bcrypt-cost", 12, "bcrypt cost of new password hashes")
+	argon2Memory := flag.Uint("argon2-memory", 19*1024, "argon2id memory of new password hashes, in KiB")
+	argon2Iterations := flag.Uint("argon2-iterations", 2, "argon2id iterations of new password hashes")
+	flag.IntVar(&app.PasswordRules.MinLength, "password-min-length", 8, "fewest characters of a new password")
+	flag.IntVar(&app.PasswordRules.MinScore, "password-min-score", 2, "lowest strength score, from 0 to 4, of a new password")
+	flag.IntVar(&app.PasswordRules.History, "password-history", 5, "how many of a user's latest passwords they can't use again")
+	breachedPasswords := flag.String("breached-passwords", "", "directory of the breached password index, see the cli index-breached-passwords action; not checked when empty")
+	flag.StringVar(&pathToTemplates, "templates", "", "directory of the page and layout templates; the embedded ones when empty")
+	dev := flag.Bool("dev", false, "development mode: read the templates from -templates, ./templates/html by default, and reload them when they change")
 	flag.Parse()
 
+	app.Passwords.Argon2Memory = uint32(*argon2Memory)
+	app.Passwords.Argon2Iterations = uint32(*argon2Iterations)
+	if *breachedPasswords != "" {
+		app.PasswordRules.Breached = &passwords.BreachedList{Dir: *breachedPasswords}
+	}
+	if err := app.Passwords.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	app.Background = newBackground(backgroundWorkers, backgroundQueue)
+	app.Exports = newBackground(exportWorkers, exportQueue)
+
+	app.Mailer = &mailer.LogMailer{}
+	if smtp.Host != "" {
+		app.Mailer = &smtp
+	}
+
+	if *dev && pathToTemplates == "" {
+		pathToTemplates = "./templates/html"
+	}
+	var err error
+	app.Templates, err = newTemplateCache(templateFS(pathToTemplates), *dev)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app.TrustedProxies, err = parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *oidcIssuer != "" {
+		app.OIDC, err = newOIDCClient(context.Background(), *oidcName, *oidcIssuer, *oidcClientID, *oidcClientSecret, app.BaseURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	key, err := loadSigningKey(*signingKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.IDP = newIdentityProvider(app.BaseURL, key)
+
 	//connect to a db
 
 	conn, err := app.connectToDB()
@@ -32,7 +129,9 @@ func main() {
 
 	defer conn.Close()
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	app.DB = &dbrepo.PostgresDBRepo{DB: conn, Passwords: app.Passwords}
+
+	go app.schedulePurge(context.Background(), purgeInterval)
 	//get a session manager
 
 	app.Session = getSession()
@@ -2,69 +2,80 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"time"
+	"webapp/pkg/data"
 )
 
 type contextKey string
 
+const (
+	// activeCheckedKey holds when auth last found the logged in account active, as a
+	// Unix time.
+	activeCheckedKey = "active_checked_at"
+	// activeCheckInterval is how long auth trusts that, rather than reading the user
+	// on every request. A suspended or deleted account is logged out within it.
+	activeCheckInterval = time.Minute
+)
+
 const contextUserKey contextKey = "user ip"
 
-func (app *application) ipFromContext(ctx context.Context) string {
-	if value, ok := ctx.Value(contextUserKey).(string); ok {
+func (app *application) ipFromContext(ctx context.Context) net.IP {
+	if value, ok := ctx.Value(contextUserKey).(net.IP); ok {
 		return value
 	}
-	return ""
+	return nil
 }
 
 func (app *application) addIPToContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var ctx = context.Background()
 		//get the IP as accurately as possible
-		ip, err := getIP(r)
+		ip, err := app.getIP(r)
 		if err != nil {
-			ip, _, _ = net.SplitHostPort(r.RemoteAddr)
-			if len(ip) == 0 {
-				ip = "unknown"
-			}
-			ctx = context.WithValue(r.Context(), contextUserKey, ip)
-		} else {
-			ctx = context.WithValue(r.Context(), contextUserKey, ip)
+			log.Println(err)
+			next.ServeHTTP(w, r)
+			return
 		}
+		ctx := context.WithValue(r.Context(), contextUserKey, ip)
 		next.ServeHTTP(w, r.WithContext(ctx))
-
 	})
-
-}
-
-func getIP(r *http.Request) (string, error) {
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return "unknown", err
-	}
-	userIP := net.ParseIP(ip)
-	if userIP == nil {
-		return "", fmt.Errorf("userIP: %q is not IP:port", r.RemoteAddr)
-	}
-
-	forward := r.Header.Get("X-Forwarded-For")
-	if len(forward) > 0 {
-		ip = forward
-	}
-	if len(ip) == 0 {
-		ip = "forward"
-
-	}
-	return ip, nil
-
 }
 
 func (app *application) auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// deny answers JSON clients with status, and flashes msg to browsers and
+		// redirects them to the page where they can sort it out
+		deny := func(status int, msg, to string, redirect int) {
+			if wantsJSON(r) {
+				writeJSON(w, status, jsonMessage{Error: msg})
+				return
+			}
+			app.flash(r.Context(), FlashError, msg)
+			http.Redirect(w, r, to, redirect)
+		}
+
 		if !app.Session.Exists(r.Context(), "user") {
-			app.Session.Put(r.Context(), "error", "log in first!")
-			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			deny(http.StatusUnauthorized, app.T(r, "log in first!"), "/", http.StatusTemporaryRedirect)
+			return
+		}
+		// accounts suspended or deleted since they logged in are logged out here
+		if time.Since(time.Unix(app.Session.GetInt64(r.Context(), activeCheckedKey), 0)) >= activeCheckInterval {
+			user := app.Session.Get(r.Context(), "user").(data.User)
+			if current, err := app.DB.GetUser(user.ID); err != nil || !current.IsActive() {
+				if err != nil {
+					log.Println(err)
+				}
+				_ = app.Session.Destroy(r.Context())
+				deny(http.StatusUnauthorized, app.T(r, "this account has been deactivated"), "/", http.StatusSeeOther)
+				return
+			}
+			app.Session.Put(r.Context(), activeCheckedKey, time.Now().Unix())
+		}
+		if app.Session.GetBool(r.Context(), twoFactorSetupRequiredKey) && r.URL.Path != "/user/2fa/setup" {
+			// a 307 would send whatever the request posted on to the setup form
+			deny(http.StatusForbidden, app.T(r, "set up two-factor authentication first!"), "/user/2fa/setup", http.StatusSeeOther)
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -2,10 +2,11 @@ package main
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"net/http/httptest"
-	"strings"
 	"testing"
+	"time"
 	"webapp/pkg/data"
 )
 
@@ -15,25 +16,27 @@ func Test_application_addIPToContext(t *testing.T) {
 		headerValue string
 		addr        string
 		emptyAddr   bool
+		expectedIP  string
 	}{
-		{headerName: "", headerValue: "", addr: "", emptyAddr: false},
-		{headerName: "", headerValue: "", addr: "", emptyAddr: true},
-		{headerName: "X-Forwarded_For", headerValue: "192:3:2:1", addr: "", emptyAddr: false},
-		{headerName: "", headerValue: "", addr: "hello:world", emptyAddr: false},
+		{headerName: "", headerValue: "", addr: "", emptyAddr: false, expectedIP: "192.0.2.1"},
+		{headerName: "", headerValue: "", addr: "", emptyAddr: true, expectedIP: ""},
+		{headerName: "X-Forwarded-For", headerValue: "192:3:2:1", addr: "", emptyAddr: false, expectedIP: "192.0.2.1"},
+		{headerName: "", headerValue: "", addr: "hello:world", emptyAddr: false, expectedIP: ""},
 	}
-	newHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		val := r.Context().Value(contextUserKey)
-		if val == nil {
-			t.Errorf("%v not present", contextUserKey)
-		}
-		ip, ok := val.(string)
-		if !ok {
-			t.Errorf("%v not string", ip)
-		}
-		t.Log(ip)
-	})
 
 	for _, e := range tests {
+		newHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := app.ipFromContext(r.Context())
+			if e.expectedIP == "" {
+				if ip != nil {
+					t.Errorf("expected no ip in context, but got %v", ip)
+				}
+				return
+			}
+			if ip == nil || ip.String() != e.expectedIP {
+				t.Errorf("expected ip %s in context, but got %v", e.expectedIP, ip)
+			}
+		})
 		handlerToTest := app.addIPToContext(newHandler)
 
 		req := httptest.NewRequest("GET", "http://testing", nil)
@@ -53,12 +56,12 @@ func Test_application_addIPToContext(t *testing.T) {
 }
 
 func Test_application_ipFromContext(t *testing.T) {
-	ctx := context.WithValue(context.Background(), contextUserKey, "shit happens")
+	ctx := context.WithValue(context.Background(), contextUserKey, net.ParseIP("10.0.0.1"))
 
 	result := app.ipFromContext(ctx)
-	expected := "shit happens"
+	expected := "10.0.0.1"
 
-	if result != expected {
+	if result.String() != expected {
 		t.Errorf("Expected %v, got %v", expected, result)
 	}
 }
@@ -67,9 +70,8 @@ func Test_app_ipFromContext(t *testing.T) {
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, contextUserKey, "some data")
 	result := app.ipFromContext(ctx)
-	if !strings.EqualFold("some data", result) {
-		t.Errorf("Expected %v but got %v", "some data", result)
-
+	if result != nil {
+		t.Errorf("Expected nil for a non IP value but got %v", result)
 	}
 }
 
@@ -79,29 +81,49 @@ func Test_app_auth(t *testing.T) {
 	})
 
 	var tests = []struct {
-		name   string
-		isAuth bool
+		name               string
+		method             string
+		userID             int
+		setupRequired      bool
+		checkedAt          time.Time
+		isAuth             bool
+		expectedStatusCode int
+		expectedLoc        string
 	}{
-		{name: "logged in", isAuth: true},
-		{name: "not logged in", isAuth: false},
+		{name: "logged in", method: "GET", userID: 1, isAuth: true, expectedStatusCode: http.StatusOK},
+		{name: "not logged in", method: "GET", isAuth: false, expectedStatusCode: http.StatusTemporaryRedirect, expectedLoc: "/"},
+		{name: "suspended since login", method: "GET", userID: 4, isAuth: false, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/"},
+		{name: "deleted since login", method: "GET", userID: 5, isAuth: false, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/"},
+		{name: "suspended, checked recently", method: "GET", userID: 4, checkedAt: time.Now().Add(-activeCheckInterval / 2), isAuth: true, expectedStatusCode: http.StatusOK},
+		{name: "suspended, checked a while ago", method: "GET", userID: 4, checkedAt: time.Now().Add(-activeCheckInterval), isAuth: false, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/"},
+		{name: "2fa setup required", method: "POST", userID: 1, setupRequired: true, isAuth: true, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/user/2fa/setup"},
 	}
 
 	for _, e := range tests {
 		handlerToTest := app.auth(nextHandler)
-		req := httptest.NewRequest("GET", "http://testing", nil)
+		req := httptest.NewRequest(e.method, "http://testing", nil)
 		req = addContextAndSessionToRequest(req, app)
-		if e.isAuth {
-			app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		if e.userID != 0 {
+			app.Session.Put(req.Context(), "user", data.User{ID: e.userID})
+		}
+		if e.setupRequired {
+			app.Session.Put(req.Context(), twoFactorSetupRequiredKey, true)
+		}
+		if !e.checkedAt.IsZero() {
+			app.Session.Put(req.Context(), activeCheckedKey, e.checkedAt.Unix())
 		}
 
 		rr := httptest.NewRecorder()
 		handlerToTest.ServeHTTP(rr, req)
 
-		if e.isAuth && rr.Code != http.StatusOK {
-			t.Errorf("%s: expected status code of 200 but got %d", e.name, rr.Code)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status code %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLoc != "" && rr.Header().Get("Location") != e.expectedLoc {
+			t.Errorf("%s: expected to be sent to %s, but got %s", e.name, e.expectedLoc, rr.Header().Get("Location"))
 		}
-		if !e.isAuth && rr.Code != http.StatusTemporaryRedirect {
-			t.Errorf("%s: expected status code 307 but got %d", e.name, rr.Code)
+		if !e.isAuth && app.Session.Exists(req.Context(), "user") {
+			t.Errorf("%s: expected the user to be logged out", e.name)
 		}
 	}
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"webapp/pkg/forms"
+)
+
+// jsonMessage is what JSON clients get where a browser is redirected: the message it
+// would have flashed, and the page it would have been sent to.
+type jsonMessage struct {
+	Error    string              `json:"error,omitempty"`
+	Errors   map[string][]string `json:"errors,omitempty"`
+	Flash    string              `json:"flash,omitempty"`
+	Redirect string              `json:"redirect,omitempty"`
+}
+
+// wantsJSON reports whether a client asked for JSON over HTML. Only an explicit
+// application/json counts, so browsers, which accept */*, keep getting pages.
+func wantsJSON(r *http.Request) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json":
+			jsonQ = q
+		case "text/html":
+			htmlQ = q
+		}
+	}
+	return jsonQ > 0 && jsonQ >= htmlQ
+}
+
+// formValues returns the values posted as a form, or as a JSON object.
+func formValues(r *http.Request) (url.Values, error) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		return forms.DecodeJSON(r.Body)
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	return r.PostForm, nil
+}
+
+// fail tells the user why a request failed: browsers see msg on the page they're
+// sent to, htmx shows it on the page it's on, and JSON clients get it with the
+// status.
+func (app *application) fail(w http.ResponseWriter, r *http.Request, status int, msg, to string) {
+	if wantsJSON(r) {
+		writeJSON(w, status, jsonMessage{Error: msg})
+		return
+	}
+	if isHTMX(r) {
+		triggerMessages(w, Flash{Level: FlashError, Message: msg})
+		w.WriteHeader(status)
+		return
+	}
+	app.flash(r.Context(), FlashError, msg)
+	http.Redirect(w, r, to, http.StatusSeeOther)
+}
+
+// redirect sends the user on to a page that shows flash, unless it's empty; htmx
+// loads it whole. JSON clients get the message and where to go next.
+func (app *application) redirect(w http.ResponseWriter, r *http.Request, to, flash string) {
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, jsonMessage{Flash: flash, Redirect: to})
+		return
+	}
+	if flash != "" {
+		app.flash(r.Context(), FlashSuccess, flash)
+	}
+	if isHTMX(r) {
+		htmxRedirect(w, to)
+		return
+	}
+	http.Redirect(w, r, to, http.StatusSeeOther)
+}
+
+// renderJSON sends the data of a page, with the errors of its form if it has one.
+func renderJSON(w http.ResponseWriter, status int, td *TemplateData) {
+	page := struct {
+		*TemplateData
+		Errors map[string][]string `json:"errors,omitempty"`
+	}{TemplateData: td}
+
+	if td.Form != nil && !td.Form.Valid() {
+		page.Errors = td.Form.Errors
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, page)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_wantsJSON(t *testing.T) {
+	var tests = []struct {
+		accept   string
+		expected bool
+	}{
+		{accept: "", expected: false},
+		{accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", expected: false},
+		{accept: "application/json", expected: true},
+		{accept: "application/json, text/plain, */*", expected: true},
+		{accept: "text/html, application/json;q=0.5", expected: false},
+		{accept: "application/json;q=0", expected: false},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", e.accept)
+		if got := wantsJSON(req); got != e.expected {
+			t.Errorf("%q: expected %v, but got %v", e.accept, e.expected, got)
+		}
+	}
+}
+
+func Test_app_render_JSON(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json")
+	req = addContextAndSessionToRequest(req, app)
+	app.flash(req.Context(), FlashError, "something went wrong")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(app.Home).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON, but got %s", ct)
+	}
+	if vary := rr.Header().Get("Vary"); !strings.Contains(vary, "Accept") {
+		t.Errorf("expected to vary by Accept, but got %q", vary)
+	}
+
+	if !strings.Contains(rr.Body.String(), `"flashes":[{"level":"error","message":"something went wrong"}]`) {
+		t.Errorf("expected the flash in %s", rr.Body.String())
+	}
+	var page map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page["csrf_token"] == "" || page["locale"] != "en" {
+		t.Errorf("unexpected page %v", page)
+	}
+	if _, ok := page["CSPNonce"]; ok {
+		t.Error("expected no nonce in the JSON")
+	}
+}
+
+func Test_app_Login_JSON(t *testing.T) {
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expected           jsonMessage
+	}{
+		{name: "valid login", body: `{"email": "admin@example.com", "password": "secret"}`, expectedStatusCode: http.StatusOK, expected: jsonMessage{Flash: "successfully logged in", Redirect: "/user/profile"}},
+		{name: "missing data", body: `{"email": "admin@example.com"}`, expectedStatusCode: http.StatusUnprocessableEntity, expected: jsonMessage{Error: "invalid login credentials"}},
+		{name: "bad credentials", body: `{"email": "admin@example.com", "password": "imperium"}`, expectedStatusCode: http.StatusUnauthorized, expected: jsonMessage{Error: "invalid login"}},
+		{name: "suspended user", body: `{"email": "suspended@example.com", "password": "secret"}`, expectedStatusCode: http.StatusForbidden, expected: jsonMessage{Error: "this account has been deactivated"}},
+		{name: "malformed", body: `{"email":`, expectedStatusCode: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(e.body))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set(csrfHeader, app.csrfToken(req.Context()))
+		rr := httptest.NewRecorder()
+
+		app.csrf(http.HandlerFunc(app.Login)).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if rr.Code == http.StatusBadRequest {
+			continue
+		}
+		var got jsonMessage
+		if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+			t.Errorf("%s: %v", e.name, err)
+		}
+		if got.Error != e.expected.Error || got.Flash != e.expected.Flash || got.Redirect != e.expected.Redirect {
+			t.Errorf("%s: expected %+v, but got %+v", e.name, e.expected, got)
+		}
+	}
+}
+
+func Test_app_auth_JSON(t *testing.T) {
+	req := httptest.NewRequest("GET", "/user/profile", nil)
+	req.Header.Set("Accept", "application/json")
+	req = addContextAndSessionToRequest(req, app)
+	rr := httptest.NewRecorder()
+
+	app.auth(http.HandlerFunc(app.Profile)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, but got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"error":"log in first!"`) {
+		t.Errorf("expected an error object, but got %s", rr.Body.String())
+	}
+}
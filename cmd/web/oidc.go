@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"log"
+	"net/http"
+	"webapp/pkg/data"
+)
+
+const (
+	oidcStateKey    = "oidc_state"
+	oidcNonceKey    = "oidc_nonce"
+	oidcVerifierKey = "oidc_verifier"
+)
+
+// oidcClient signs users in with an external OpenID Connect provider.
+type oidcClient struct {
+	Name     string
+	Issuer   string
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// newOIDCClient runs discovery against issuer; the callback is served under baseURL.
+func newOIDCClient(ctx context.Context, name, issuer, clientID, clientSecret, baseURL string) (*oidcClient, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	return &oidcClient{
+		Name:   name,
+		Issuer: issuer,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  baseURL + "/login/oidc/callback",
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+// oidcClaims are the ID token claims we use.
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Nonce         string `json:"nonce"`
+}
+
+// OIDCLogin sends the user to the provider, with state, nonce and a PKCE challenge
+// kept in the session for the callback.
+func (app *application) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	state, _, err := generateToken()
+	if err != nil {
+		app.serveError(w, r, err)
+		return
+	}
+	nonce, _, err := generateToken()
+	if err != nil {
+		app.serveError(w, r, err)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	app.Session.Put(r.Context(), oidcStateKey, state)
+	app.Session.Put(r.Context(), oidcNonceKey, nonce)
+	app.Session.Put(r.Context(), oidcVerifierKey, verifier)
+
+	url := app.OIDC.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// OIDCCallback finishes the provider login and links or creates the local user.
+func (app *application) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	state := app.Session.PopString(r.Context(), oidcStateKey)
+	nonce := app.Session.PopString(r.Context(), oidcNonceKey)
+	verifier := app.Session.PopString(r.Context(), oidcVerifierKey)
+
+	user, err := app.oidcUser(r, state, nonce, verifier)
+	if err != nil {
+		log.Println("oidc login:", err)
+		app.flash(r.Context(), FlashError, app.T(r, "could not sign in with %s", app.OIDC.Name))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+func (app *application) oidcUser(r *http.Request, state, nonce, verifier string) (*data.User, error) {
+	if errParam := r.URL.Query().Get("error"); errParam != "" {
+		return nil, fmt.Errorf("provider returned %s", errParam)
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(r.URL.Query().Get("state"))) != 1 {
+		return nil, fmt.Errorf("state mismatch")
+	}
+
+	token, err := app.OIDC.config.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("no id_token in token response")
+	}
+	idToken, err := app.OIDC.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(claims.Nonce)) != 1 {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+
+	if user, err := app.DB.GetUserByIdentity(app.OIDC.Issuer, idToken.Subject); err == nil {
+		return user, nil
+	}
+
+	// only a verified address may be linked to, or create, a local account
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, fmt.Errorf("provider did not return a verified email")
+	}
+
+	user, err := app.DB.GetUserByEmail(claims.Email)
+	if err != nil {
+		user, err = app.createOIDCUser(claims)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// completeLogin turns a deactivated account away; don't link it on the way
+	if !user.IsActive() {
+		return user, nil
+	}
+
+	_, err = app.DB.InsertUserIdentity(data.UserIdentity{
+		UserID:  user.ID,
+		Issuer:  app.OIDC.Issuer,
+		Subject: idToken.Subject,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// createOIDCUser creates a local user for a first-time provider login. The random
+// password is never shown to anyone; the user signs in through the provider.
+func (app *application) createOIDCUser(claims oidcClaims) (*data.User, error) {
+	password, _, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	user := data.User{
+		FirstName: claims.GivenName,
+		LastName:  claims.FamilyName,
+		Email:     claims.Email,
+		Password:  password,
+	}
+	user.ID, err = app.DB.InsertUser(user)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+
+	return &user, nil
+}
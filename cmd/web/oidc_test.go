@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockOIDCProvider is an in-process OpenID Connect provider. Every authorization
+// request is approved straight away for the configured subject.
+type mockOIDCProvider struct {
+	*httptest.Server
+	key           *rsa.PrivateKey
+	Subject       string
+	Email         string
+	EmailVerified bool
+
+	mu    sync.Mutex
+	codes map[string]mockAuthRequest
+}
+
+type mockAuthRequest struct {
+	clientID  string
+	nonce     string
+	challenge string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDCProvider{key: key, codes: map[string]mockAuthRequest{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func (p *mockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *mockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE required", http.StatusBadRequest)
+		return
+	}
+
+	code, _, _ := generateToken()
+	p.mu.Lock()
+	p.codes[code] = mockAuthRequest{clientID: q.Get("client_id"), nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	p.mu.Unlock()
+
+	redirect, _ := url.Parse(q.Get("redirect_uri"))
+	v := redirect.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+
+	p.mu.Lock()
+	req, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != req.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.URL,
+		"aud":            req.clientID,
+		"sub":            p.Subject,
+		"email":          p.Email,
+		"email_verified": p.EmailVerified,
+		"given_name":     "New",
+		"family_name":    "User",
+		"nonce":          req.nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+	idToken.Header["kid"] = "test"
+	signed, _ := idToken.SignedString(p.key)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+func (p *mockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func Test_app_OIDCLogin(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+
+	var handler http.Handler
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	testApp := app
+	client, err := newOIDCClient(context.Background(), "Mock", provider.URL, "webapp", "webapp-secret", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testApp.OIDC = client
+	handler = testApp.routes()
+
+	var tests = []struct {
+		name          string
+		subject       string
+		email         string
+		emailVerified bool
+		expectedPath  string
+	}{
+		{name: "linked identity", subject: "linked-subject", expectedPath: "/user/profile"},
+		{name: "link by verified email", subject: "new-subject", email: "admin@example.com", emailVerified: true, expectedPath: "/user/profile"},
+		{name: "create new user", subject: "new-subject", email: "new@example.com", emailVerified: true, expectedPath: "/user/profile"},
+		{name: "unverified email", subject: "new-subject", email: "admin@example.com", emailVerified: false, expectedPath: "/"},
+		{name: "deactivated account", subject: "new-subject", email: "suspended@example.com", emailVerified: true, expectedPath: "/"},
+	}
+
+	for _, e := range tests {
+		provider.Subject = e.subject
+		provider.Email = e.email
+		provider.EmailVerified = e.emailVerified
+
+		jar, _ := cookiejar.New(nil)
+		browser := &http.Client{
+			Jar:       jar,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+
+		resp, err := browser.Get(ts.URL + "/login/oidc")
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		resp.Body.Close()
+
+		if resp.Request.URL.Path != e.expectedPath {
+			t.Errorf("%s: expected to end up at %s, but got %s", e.name, e.expectedPath, resp.Request.URL.Path)
+		}
+	}
+
+	if identities, _ := testApp.DB.GetUserIdentities(4); len(identities) != 0 {
+		t.Errorf("expected no identity linked to the deactivated account, but got %d", len(identities))
+	}
+}
+
+func Test_app_OIDCCallbackBadState(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	testApp := app
+	client, err := newOIDCClient(context.Background(), "Mock", provider.URL, "webapp", "webapp-secret", "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testApp.OIDC = client
+
+	req := httptest.NewRequest("GET", "/login/oidc/callback?code=abc&state=forged", nil)
+	req = addContextAndSessionToRequest(req, testApp)
+	app.Session.Put(req.Context(), oidcStateKey, "expected")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(testApp.OIDCCallback).ServeHTTP(rr, req)
+
+	if loc, _ := rr.Result().Location(); loc == nil || loc.String() != "/" {
+		t.Errorf("expected a forged state to be rejected, but got %v", loc)
+	}
+	if app.Session.Exists(req.Context(), "user") {
+		t.Error("forged callback logged a user in")
+	}
+}
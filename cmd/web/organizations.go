@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// organizationKey holds the id of the organization the user is working in.
+const organizationKey = "organization_id"
+
+// repo returns the repository scoped to the current user's organization. Without
+// one it is scoped to no organization at all, so it sees no users.
+func (app *application) repo(r *http.Request) repository.DatabaseRepo {
+	return app.DB.ForOrganization(app.Session.GetInt(r.Context(), organizationKey))
+}
+
+// selectOrganization starts a new login in the first organization the user is a
+// member of.
+func (app *application) selectOrganization(r *http.Request, user *data.User) {
+	organizations, err := app.DB.GetUserOrganizations(user.ID)
+	if err != nil {
+		log.Println(err)
+	}
+	if len(organizations) == 0 {
+		app.Session.Remove(r.Context(), organizationKey)
+		return
+	}
+	app.Session.Put(r.Context(), organizationKey, organizations[0].ID)
+}
+
+// currentOrganization returns the organization the user is working in, or nil when
+// they aren't in one.
+func (app *application) currentOrganization(r *http.Request, userID int) *data.Organization {
+	organizations, err := app.DB.GetUserOrganizations(userID)
+	if err != nil {
+		log.Println(err)
+	}
+	current := app.Session.GetInt(r.Context(), organizationKey)
+	for _, o := range organizations {
+		if o.ID == current {
+			return o
+		}
+	}
+	return nil
+}
+
+// PostSwitchOrganization moves the user to another organization they are a member of.
+func (app *application) PostSwitchOrganization(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	id, _ := strconv.Atoi(r.PostForm.Get("organization_id"))
+
+	organizations, err := app.DB.GetUserOrganizations(user.ID)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, o := range organizations {
+		if o.ID == id {
+			app.Session.Put(r.Context(), organizationKey, o.ID)
+			app.flash(r.Context(), FlashSuccess, app.T(r, "switched to %s", o.Name))
+			http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+			return
+		}
+	}
+
+	app.flash(r.Context(), FlashError, app.T(r, "you are not a member of that organization"))
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+)
+
+func Test_app_PostSwitchOrganization(t *testing.T) {
+	var tests = []struct {
+		name                 string
+		userID               int
+		organizationID       string
+		expectedOrganization int
+		expectedError        bool
+	}{
+		{name: "member", userID: 1, organizationID: "2", expectedOrganization: 2},
+		{name: "not a member", userID: 3, organizationID: "2", expectedOrganization: 1, expectedError: true},
+		{name: "bad id", userID: 1, organizationID: "acme", expectedOrganization: 1, expectedError: true},
+	}
+
+	for _, e := range tests {
+		req := newFormRequest("POST", "/user/organization", url.Values{"organization_id": {e.organizationID}})
+		app.Session.Put(req.Context(), "user", data.User{ID: e.userID})
+		app.Session.Put(req.Context(), organizationKey, 1)
+
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostSwitchOrganization)).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status 303, but got %d", e.name, rr.Code)
+		}
+		if got := app.Session.GetInt(req.Context(), organizationKey); got != e.expectedOrganization {
+			t.Errorf("%s: expected organization %d, but got %d", e.name, e.expectedOrganization, got)
+		}
+		if hasError := flashed(req, FlashError) != ""; hasError != e.expectedError {
+			t.Errorf("%s: expected error %v, but got %v", e.name, e.expectedError, hasError)
+		}
+	}
+}
+
+func Test_app_repo(t *testing.T) {
+	req := httptest.NewRequest("GET", "/admin/users", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: 1})
+	app.Session.Put(req.Context(), organizationKey, 2)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.AdminUsers).ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "admin@example.com") {
+		t.Error("expected the admin to be listed in their organization")
+	}
+	if strings.Contains(rr.Body.String(), "totp@example.com") {
+		t.Error("expected users of other organizations to be hidden")
+	}
+
+	// the admin is only a viewer in organization 2
+	rr = httptest.NewRecorder()
+	app.requirePermission(data.PermissionUsersWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected status 403, but got %d", rr.Code)
+	}
+
+	app.Session.Remove(req.Context(), organizationKey)
+	if _, err := app.repo(req).GetUser(1); err == nil {
+		t.Error("expected a session without an organization to see no users")
+	}
+}
+
+func Test_app_selectOrganization(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req = addContextAndSessionToRequest(req, app)
+
+	app.selectOrganization(req, &data.User{ID: 1})
+	if got := app.Session.GetInt(req.Context(), organizationKey); got != 1 {
+		t.Errorf("expected organization 1, but got %d", got)
+	}
+
+	app.selectOrganization(req, &data.User{ID: 99})
+	if app.Session.Exists(req.Context(), organizationKey) {
+		t.Error("expected no organization for a user without memberships")
+	}
+}
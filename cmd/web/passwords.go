@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"webapp/pkg/data"
+	"webapp/pkg/forms"
+	"webapp/pkg/passwords"
+)
+
+// passwordProblems returns why a new password breaks the password rules, in the
+// locale of the request. userID is the user changing their password, whose earlier
+// passwords can't be used again, or 0 for a new user. userInputs are their own
+// details, like their name and email.
+func (app *application) passwordProblems(r *http.Request, password string, userID int, userInputs ...string) []string {
+	printer := app.printer(r)
+	rules := app.PasswordRules
+	rules.Translate = printer.T
+
+	problems, err := rules.Check(password, userInputs...)
+	if err != nil {
+		// an unreadable breached password index mustn't keep everyone from signing up
+		log.Println("checking breached passwords:", err)
+		rules.Breached = nil
+		problems, _ = rules.Check(password, userInputs...)
+	}
+
+	if userID != 0 && rules.History > 0 {
+		hashes, err := app.DB.PasswordHistory(userID, rules.History)
+		if err != nil {
+			log.Println(err)
+		}
+		if passwords.Reused(password, hashes) {
+			problems = append(problems, printer.T("You used this password recently, choose another one"))
+		}
+	}
+
+	return problems
+}
+
+// checkPassword adds the problems of the new password in field to the form's errors.
+func (app *application) checkPassword(r *http.Request, form *forms.Form, field string, userID int, userInputs ...string) {
+	for _, msg := range app.passwordProblems(r, form.Data.Get(field), userID, userInputs...) {
+		form.Errors.Add(field, msg)
+	}
+}
+
+// PostChangePassword changes the logged in user's password, once they've confirmed
+// the current one.
+func (app *application) PostChangePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	stored, err := app.DB.GetUser(user.ID)
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	form := app.form(r, r.PostForm)
+	form.Required("current_password", "password", "confirm_password")
+	if form.Has("current_password") {
+		matches, _ := stored.PasswordMatches(form.Data.Get("current_password"))
+		form.Check(matches, "current_password", app.T(r, "This is not your current password"))
+	}
+	if form.Has("password") {
+		form.Equal("confirm_password", "password")
+		app.checkPassword(r, form, "password", user.ID, user.FirstName, user.LastName, user.Email)
+	}
+	if !form.Valid() {
+		_ = app.render(w, r, "profile.page.gohtml", &TemplateData{Form: form})
+		return
+	}
+
+	if err = app.DB.ResetPassword(user.ID, form.Data.Get("password")); err != nil {
+		log.Println(err)
+		app.flash(r.Context(), FlashError, app.T(r, "could not change your password"))
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	_ = app.Session.RenewToken(r.Context())
+	app.flash(r.Context(), FlashSuccess, app.T(r, "your password was changed"))
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
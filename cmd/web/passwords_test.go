@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/passwords"
+)
+
+func Test_app_passwordProblems(t *testing.T) {
+	defer func(rules passwords.Rules) { app.PasswordRules = rules }(app.PasswordRules)
+	app.PasswordRules = passwords.Rules{MinLength: 6, History: 5}
+	req := addContextAndSessionToRequest(httptest.NewRequest("POST", "/", nil), app)
+
+	if problems := app.passwordProblems(req, "secret", 1); !slices.Contains(problems, "You used this password recently, choose another one") {
+		t.Errorf("expected the current password to be refused, but got %v", problems)
+	}
+	if problems := app.passwordProblems(req, "secret", 0); len(problems) != 0 {
+		t.Errorf("expected a new user to have no history, but got %v", problems)
+	}
+
+	// an index that can't be read doesn't block anyone
+	app.PasswordRules.Breached = &passwords.BreachedList{Dir: "\x00"}
+	if problems := app.passwordProblems(req, "correct horse battery staple", 0); len(problems) != 0 {
+		t.Errorf("expected no problems, but got %v", problems)
+	}
+}
+
+func Test_app_PostChangePassword(t *testing.T) {
+	var tests = []struct {
+		name          string
+		form          url.Values
+		expectedError string
+	}{
+		{
+			name: "valid",
+			form: url.Values{"current_password": {"secret"}, "password": {"correct horse battery staple"}, "confirm_password": {"correct horse battery staple"}},
+		},
+		{
+			name:          "wrong current password",
+			form:          url.Values{"current_password": {"wrong"}, "password": {"correct horse battery staple"}, "confirm_password": {"correct horse battery staple"}},
+			expectedError: "This is not your current password",
+		},
+		{
+			name:          "mismatch",
+			form:          url.Values{"current_password": {"secret"}, "password": {"correct horse battery staple"}, "confirm_password": {"correct horse"}},
+			expectedError: "This field must match the password field",
+		},
+		{
+			name:          "guessable",
+			form:          url.Values{"current_password": {"secret"}, "password": {"admin1234"}, "confirm_password": {"admin1234"}},
+			expectedError: "This password is too easy to guess",
+		},
+	}
+
+	defer func(rules passwords.Rules) { app.PasswordRules = rules }(app.PasswordRules)
+	app.PasswordRules = passwords.Rules{MinScore: 2}
+
+	for _, e := range tests {
+		req := newFormRequest("POST", "/user/password", e.form)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"})
+
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostChangePassword)).ServeHTTP(rr, req)
+
+		if e.expectedError != "" {
+			if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), e.expectedError) {
+				t.Errorf("%s: expected the profile again with %q, but got %d", e.name, e.expectedError, rr.Code)
+			}
+			continue
+		}
+		if loc, _ := rr.Result().Location(); loc == nil || loc.String() != "/user/profile" {
+			t.Errorf("%s: expected a redirect to the profile, but got %v", e.name, loc)
+		}
+		if flashed(req, FlashSuccess) == "" {
+			t.Errorf("%s: expected a flash message", e.name)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+)
+
+const (
+	authorizationCodeLifetime = time.Minute
+	accessTokenLifetime       = time.Hour
+	redirectAfterLoginKey     = "redirect_after_login"
+)
+
+// identityProvider lets other applications sign their users in with our accounts,
+// through the OpenID Connect authorization code flow with PKCE.
+type identityProvider struct {
+	Issuer string
+	key    *rsa.PrivateKey
+	keyID  string
+}
+
+func newIdentityProvider(issuer string, key *rsa.PrivateKey) *identityProvider {
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	sum := sha256.Sum256(der)
+
+	return &identityProvider{
+		Issuer: issuer,
+		key:    key,
+		keyID:  base64.RawURLEncoding.EncodeToString(sum[:12]),
+	}
+}
+
+// loadSigningKey reads a PEM encoded RSA key. Without a path a throwaway key is
+// generated, which means tokens don't survive a restart.
+func loadSigningKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		log.Println("no -oidc-signing-key given, generating a temporary one")
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA key", path)
+	}
+	return rsaKey, nil
+}
+
+func (p *identityProvider) sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.keyID
+	return token.SignedString(p.key)
+}
+
+// oauthError is an RFC 6749 error response.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// OIDCDiscovery serves the provider metadata.
+func (app *application) OIDCDiscovery(w http.ResponseWriter, r *http.Request) {
+	issuer := app.IDP.Issuer
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/oauth/userinfo",
+		"jwks_uri":                              issuer + "/oauth/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "name", "admin", "roles", "org_id", "email"},
+	})
+}
+
+// JWKS publishes the key ID tokens are signed with.
+func (app *application) JWKS(w http.ResponseWriter, r *http.Request) {
+	pub := app.IDP.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": app.IDP.keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// authorizeRequest is a validated authorization request.
+type authorizeRequest struct {
+	Client        *data.OAuthClient
+	RedirectURI   string
+	State         string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+}
+
+// parseAuthorizeRequest validates an authorization request. A nil request means the
+// client or redirect URI can't be trusted, so the error must not be sent back there.
+func (app *application) parseAuthorizeRequest(v url.Values) (*authorizeRequest, error) {
+	client, err := app.DB.GetOAuthClient(v.Get("client_id"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown client")
+	}
+	if !client.AllowsRedirect(v.Get("redirect_uri")) {
+		return nil, fmt.Errorf("redirect_uri is not registered for this client")
+	}
+
+	req := &authorizeRequest{
+		Client:        client,
+		RedirectURI:   v.Get("redirect_uri"),
+		State:         v.Get("state"),
+		Scope:         v.Get("scope"),
+		Nonce:         v.Get("nonce"),
+		CodeChallenge: v.Get("code_challenge"),
+	}
+
+	switch {
+	case v.Get("response_type") != "code":
+		return req, &oauthError{Code: "unsupported_response_type", Description: "only the code flow is supported"}
+	case !hasScope(req.Scope, "openid"):
+		return req, &oauthError{Code: "invalid_scope", Description: "the openid scope is required"}
+	case req.CodeChallenge == "" || v.Get("code_challenge_method") != "S256":
+		return req, &oauthError{Code: "invalid_request", Description: "PKCE with S256 is required"}
+	}
+
+	return req, nil
+}
+
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectToClient sends the user agent back to the client with the given parameters.
+func redirectToClient(w http.ResponseWriter, r *http.Request, req *authorizeRequest, params url.Values) {
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	target, _ := url.Parse(req.RedirectURI)
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// redirectOrigin returns the CSP source that matches a redirect URI: its origin, or
+// just its scheme for the private-use schemes of native apps.
+func redirectOrigin(redirectURI string) string {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		return "'self'"
+	}
+	if target.Host == "" {
+		return target.Scheme + ":"
+	}
+	return target.Scheme + "://" + target.Host
+}
+
+// Authorize asks the logged in user whether the client may sign them in.
+func (app *application) Authorize(w http.ResponseWriter, r *http.Request) {
+	req, err := app.parseAuthorizeRequest(r.URL.Query())
+	if req == nil {
+		app.serveError(w, r, &appError{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	if oerr, ok := err.(*oauthError); ok {
+		redirectToClient(w, r, req, url.Values{"error": {oerr.Code}, "error_description": {oerr.Description}})
+		return
+	}
+
+	if !app.Session.Exists(r.Context(), "user") {
+		app.Session.Put(r.Context(), redirectAfterLoginKey, r.URL.RequestURI())
+		app.flash(r.Context(), FlashError, app.T(r, "log in first!"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// the consent form is answered with a redirect to the client
+	allowFormAction(w, redirectOrigin(req.RedirectURI))
+
+	td := map[string]any{
+		"Client": req.Client.Name,
+		"Scopes": strings.Fields(req.Scope),
+		"Params": r.URL.Query(),
+	}
+	_ = app.render(w, r, "consent.page.gohtml", &TemplateData{Data: td})
+}
+
+// PostAuthorize records the user's consent decision and issues an authorization code.
+func (app *application) PostAuthorize(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	req, err := app.parseAuthorizeRequest(r.PostForm)
+	if req == nil {
+		app.serveError(w, r, &appError{Status: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	if oerr, ok := err.(*oauthError); ok {
+		redirectToClient(w, r, req, url.Values{"error": {oerr.Code}, "error_description": {oerr.Description}})
+		return
+	}
+
+	if !app.Session.Exists(r.Context(), "user") {
+		app.flash(r.Context(), FlashError, app.T(r, "log in first!"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	if r.PostForm.Get("approve") != "yes" {
+		redirectToClient(w, r, req, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	code, hash, err := generateToken()
+	if err != nil {
+		app.serveError(w, r, err)
+		return
+	}
+
+	err = app.DB.InsertAuthorizationCode(data.AuthorizationCode{
+		CodeHash:       hash,
+		ClientID:       req.Client.ClientID,
+		UserID:         user.ID,
+		RedirectURI:    req.RedirectURI,
+		Scope:          req.Scope,
+		Nonce:          req.Nonce,
+		CodeChallenge:  req.CodeChallenge,
+		ExpiresAt:      time.Now().Add(authorizationCodeLifetime),
+		OrganizationID: app.Session.GetInt(r.Context(), organizationKey),
+	})
+	if err != nil {
+		app.serveError(w, r, err)
+		return
+	}
+
+	redirectToClient(w, r, req, url.Values{"code": {code}})
+}
+
+// Token exchanges an authorization code for an ID token and an access token.
+func (app *application) Token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &oauthError{Code: "invalid_request"})
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, &oauthError{Code: "unsupported_grant_type"})
+		return
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := app.DB.GetOAuthClient(clientID)
+	if err != nil || (!client.IsPublic() && !client.SecretMatches(secret)) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		writeJSON(w, http.StatusUnauthorized, &oauthError{Code: "invalid_client"})
+		return
+	}
+
+	code, err := app.DB.ConsumeAuthorizationCode(hashToken(r.PostForm.Get("code")))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &oauthError{Code: "invalid_grant", Description: "unknown, used or expired code"})
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case code.ClientID != client.ClientID:
+		writeJSON(w, http.StatusBadRequest, &oauthError{Code: "invalid_grant", Description: "code was issued to another client"})
+		return
+	case code.RedirectURI != r.PostForm.Get("redirect_uri"):
+		writeJSON(w, http.StatusBadRequest, &oauthError{Code: "invalid_grant", Description: "redirect_uri mismatch"})
+		return
+	case subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(challenge[:])), []byte(code.CodeChallenge)) != 1:
+		writeJSON(w, http.StatusBadRequest, &oauthError{Code: "invalid_grant", Description: "PKCE verification failed"})
+		return
+	}
+
+	user, err := app.DB.GetUser(code.UserID)
+	if err != nil || !user.IsActive() {
+		writeJSON(w, http.StatusBadRequest, &oauthError{Code: "invalid_grant", Description: "user no longer exists"})
+		return
+	}
+
+	now := time.Now()
+	idClaims := jwt.MapClaims{
+		"iss": app.IDP.Issuer,
+		"sub": strconv.Itoa(user.ID),
+		"aud": client.ClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(accessTokenLifetime).Unix(),
+	}
+	if code.Nonce != "" {
+		idClaims["nonce"] = code.Nonce
+	}
+	for key, value := range app.userClaims(user, code.Scope, code.OrganizationID) {
+		idClaims[key] = value
+	}
+
+	idToken, err := app.IDP.sign(idClaims)
+	if err != nil {
+		log.Println(err)
+		writeJSON(w, http.StatusInternalServerError, &oauthError{Code: "server_error"})
+		return
+	}
+	accessToken, err := app.IDP.sign(jwt.MapClaims{
+		"iss":       app.IDP.Issuer,
+		"sub":       strconv.Itoa(user.ID),
+		"aud":       app.IDP.Issuer,
+		"client_id": client.ClientID,
+		"scope":     code.Scope,
+		"org_id":    code.OrganizationID,
+		"token_use": "access",
+		"iat":       now.Unix(),
+		"exp":       now.Add(accessTokenLifetime).Unix(),
+	})
+	if err != nil {
+		log.Println(err)
+		writeJSON(w, http.StatusInternalServerError, &oauthError{Code: "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(accessTokenLifetime.Seconds()),
+		"id_token":     idToken,
+		"scope":        code.Scope,
+	})
+}
+
+// userClaims returns the claims about user that scope allows a client to see. They
+// match the ones cmd/cli puts into its tokens. Roles are the user's roles in the
+// organization they consented in, never in others; without one they have none.
+func (app *application) userClaims(user *data.User, scope string, organizationID int) map[string]any {
+	claims := map[string]any{"sub": strconv.Itoa(user.ID)}
+	if hasScope(scope, "profile") {
+		var roles []string
+		if organizationID != 0 {
+			var err error
+			roles, err = app.DB.ForOrganization(organizationID).GetUserRoles(user.ID)
+			if err != nil {
+				log.Println(err)
+			}
+			claims["org_id"] = organizationID
+		}
+		claims["name"] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		claims["admin"] = slices.Contains(roles, data.RoleAdmin)
+		claims["roles"] = roles
+	}
+	if hasScope(scope, "email") {
+		claims["email"] = user.Email
+	}
+	return claims
+}
+
+// UserInfo returns the claims of the user an access token was issued for.
+func (app *application) UserInfo(w http.ResponseWriter, r *http.Request) {
+	raw, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		writeJSON(w, http.StatusUnauthorized, &oauthError{Code: "invalid_token"})
+		return
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		return &app.IDP.key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}))
+
+	if err != nil || !claims.VerifyIssuer(app.IDP.Issuer, true) || claims["token_use"] != "access" {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeJSON(w, http.StatusUnauthorized, &oauthError{Code: "invalid_token"})
+		return
+	}
+
+	sub, _ := claims["sub"].(string)
+	id, _ := strconv.Atoi(sub)
+	user, err := app.DB.GetUser(id)
+	if err != nil || !user.IsActive() {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeJSON(w, http.StatusUnauthorized, &oauthError{Code: "invalid_token"})
+		return
+	}
+
+	scope, _ := claims["scope"].(string)
+	organizationID, _ := claims["org_id"].(float64)
+	writeJSON(w, http.StatusOK, app.userClaims(user, scope, int(organizationID)))
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_app_OIDCDiscovery(t *testing.T) {
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/.well-known/openid-configuration", nil))
+
+	var metadata map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata["issuer"] != app.IDP.Issuer {
+		t.Errorf("expected issuer %s, but got %v", app.IDP.Issuer, metadata["issuer"])
+	}
+	if metadata["jwks_uri"] != app.IDP.Issuer+"/oauth/jwks" {
+		t.Errorf("unexpected jwks_uri %v", metadata["jwks_uri"])
+	}
+
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/oauth/jwks", nil))
+	if !strings.Contains(rr.Body.String(), app.IDP.keyID) {
+		t.Error("expected the signing key id in the JWKS")
+	}
+}
+
+func authorizeParams() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"test-client"},
+		"redirect_uri":          {dbrepo.TestOAuthRedirectURI},
+		"scope":                 {"openid profile"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+func Test_app_Authorize(t *testing.T) {
+	var tests = []struct {
+		name               string
+		change             func(url.Values)
+		loggedIn           bool
+		expectedStatusCode int
+		expectedLoc        string
+	}{
+		{name: "consent", loggedIn: true, expectedStatusCode: http.StatusOK},
+		{name: "not logged in", expectedStatusCode: http.StatusSeeOther, expectedLoc: "/"},
+		{name: "unknown client", change: func(v url.Values) { v.Set("client_id", "nope") }, loggedIn: true, expectedStatusCode: http.StatusBadRequest},
+		{name: "unregistered redirect", change: func(v url.Values) { v.Set("redirect_uri", "https://evil.example.com/") }, loggedIn: true, expectedStatusCode: http.StatusBadRequest},
+		{name: "no pkce", change: func(v url.Values) { v.Del("code_challenge") }, loggedIn: true, expectedStatusCode: http.StatusFound, expectedLoc: "error=invalid_request"},
+		{name: "no openid scope", change: func(v url.Values) { v.Set("scope", "profile") }, loggedIn: true, expectedStatusCode: http.StatusFound, expectedLoc: "error=invalid_scope"},
+	}
+
+	for _, e := range tests {
+		params := authorizeParams()
+		if e.change != nil {
+			e.change(params)
+		}
+		req := httptest.NewRequest("GET", "/oauth/authorize?"+params.Encode(), nil)
+		req = addContextAndSessionToRequest(req, app)
+		if e.loggedIn {
+			app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		}
+
+		rr := httptest.NewRecorder()
+		app.secureHeaders(http.HandlerFunc(app.Authorize)).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLoc != "" && !strings.Contains(rr.Header().Get("Location"), e.expectedLoc) {
+			t.Errorf("%s: expected location to contain %s, but got %s", e.name, e.expectedLoc, rr.Header().Get("Location"))
+		}
+		if e.name == "consent" && !strings.Contains(rr.Body.String(), "Sign in to Test Client") {
+			t.Errorf("%s: expected the consent page", e.name)
+		}
+		if e.name == "consent" && !strings.Contains(rr.Header().Get("Content-Security-Policy"), "form-action 'self' "+redirectOrigin(dbrepo.TestOAuthRedirectURI)+";") {
+			t.Errorf("%s: expected the consent form to be allowed to end up at the client, but got %q", e.name, rr.Header().Get("Content-Security-Policy"))
+		}
+		if e.name == "not logged in" && app.Session.GetString(req.Context(), redirectAfterLoginKey) == "" {
+			t.Errorf("%s: expected to be sent back after login", e.name)
+		}
+	}
+}
+
+func Test_app_PostAuthorize(t *testing.T) {
+	for _, approve := range []string{"yes", "no"} {
+		params := authorizeParams()
+		params.Set("approve", approve)
+
+		req := newFormRequest("POST", "/oauth/authorize", params)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostAuthorize)).ServeHTTP(rr, req)
+
+		loc, err := url.Parse(rr.Header().Get("Location"))
+		if err != nil || rr.Code != http.StatusFound {
+			t.Fatalf("approve=%s: expected a redirect to the client, but got %d", approve, rr.Code)
+		}
+		if loc.Query().Get("state") != "xyz" {
+			t.Errorf("approve=%s: expected the state to be passed back", approve)
+		}
+		if approve == "yes" && loc.Query().Get("code") == "" {
+			t.Error("expected a code after consent")
+		}
+		if approve == "no" && loc.Query().Get("error") != "access_denied" {
+			t.Error("expected access_denied after denying")
+		}
+	}
+}
+
+func Test_app_Token(t *testing.T) {
+	var tests = []struct {
+		name               string
+		clientID           string
+		secret             string
+		code               string
+		verifier           string
+		expectedStatusCode int
+	}{
+		{name: "valid", clientID: "test-client", secret: dbrepo.TestOAuthClientSecret, code: dbrepo.TestAuthorizationCode, verifier: dbrepo.TestCodeVerifier, expectedStatusCode: http.StatusOK},
+		{name: "wrong secret", clientID: "test-client", secret: "guess", code: dbrepo.TestAuthorizationCode, verifier: dbrepo.TestCodeVerifier, expectedStatusCode: http.StatusUnauthorized},
+		{name: "unknown code", clientID: "test-client", secret: dbrepo.TestOAuthClientSecret, code: "other", verifier: dbrepo.TestCodeVerifier, expectedStatusCode: http.StatusBadRequest},
+		{name: "wrong verifier", clientID: "test-client", secret: dbrepo.TestOAuthClientSecret, code: dbrepo.TestAuthorizationCode, verifier: "wrong", expectedStatusCode: http.StatusBadRequest},
+		{name: "other client", clientID: "public-client", code: dbrepo.TestAuthorizationCode, verifier: dbrepo.TestCodeVerifier, expectedStatusCode: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {e.code},
+			"redirect_uri":  {dbrepo.TestOAuthRedirectURI},
+			"code_verifier": {e.verifier},
+			"client_id":     {e.clientID},
+		}
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if e.secret != "" {
+			req.SetBasicAuth(e.clientID, e.secret)
+		}
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var resp struct {
+			AccessToken string `json:"access_token"`
+			IDToken     string `json:"id_token"`
+		}
+		_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(resp.IDToken, claims, func(token *jwt.Token) (interface{}, error) {
+			return &app.IDP.key.PublicKey, nil
+		})
+		if err != nil {
+			t.Fatalf("%s: id token doesn't verify: %v", e.name, err)
+		}
+		if claims["sub"] != "1" || claims["aud"] != "test-client" || claims["nonce"] != "test-nonce" || claims["admin"] != true || claims["name"] != "Admin User" {
+			t.Errorf("%s: unexpected id token claims %v", e.name, claims)
+		}
+
+		userinfo := httptest.NewRequest("GET", "/oauth/userinfo", nil)
+		userinfo.Header.Set("Authorization", "Bearer "+resp.AccessToken)
+		rr = httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, userinfo)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"email":"admin@example.com"`) {
+			t.Errorf("%s: expected userinfo for the token, but got %d %s", e.name, rr.Code, rr.Body.String())
+		}
+
+		// an ID token is not an access token
+		userinfo.Header.Set("Authorization", "Bearer "+resp.IDToken)
+		rr = httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, userinfo)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected the id token to be refused by userinfo, but got %d", e.name, rr.Code)
+		}
+	}
+}
+
+func Test_app_userClaims(t *testing.T) {
+	user := &data.User{ID: 1, FirstName: "Admin", LastName: "User"}
+
+	var tests = []struct {
+		name          string
+		organization  int
+		expectedAdmin bool
+		expectedRoles string
+	}{
+		{name: "admin there", organization: 1, expectedAdmin: true, expectedRoles: "admin"},
+		{name: "viewer there", organization: 2, expectedRoles: "viewer"},
+		{name: "no organization", organization: 0},
+	}
+
+	for _, e := range tests {
+		claims := app.userClaims(user, "openid profile", e.organization)
+
+		if claims["admin"] != e.expectedAdmin {
+			t.Errorf("%s: expected admin %v, but got %v", e.name, e.expectedAdmin, claims["admin"])
+		}
+		roles, _ := claims["roles"].([]string)
+		if strings.Join(roles, " ") != e.expectedRoles {
+			t.Errorf("%s: expected roles %q, but got %v", e.name, e.expectedRoles, roles)
+		}
+	}
+}
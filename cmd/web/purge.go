@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// purgeInterval is how often deleted users are checked for the end of their retention.
+const purgeInterval = time.Hour
+
+// purgeDeletedUsers permanently removes the users deleted longer than the retention
+// period ago, with their files, and returns how many there were. Without a retention
+// period they are kept.
+func (app *application) purgeDeletedUsers() (int, error) {
+	if app.UserRetention <= 0 {
+		return 0, nil
+	}
+
+	users, err := app.DB.PurgeDeletedUsers(time.Now().Add(-app.UserRetention))
+	if err != nil {
+		return 0, err
+	}
+
+	// the users are gone already, so carry on with the others' files
+	for _, user := range users {
+		if fileErr := app.removeUserFiles(user.ID, user.ProfilePic.FileName); fileErr != nil {
+			log.Println("removing files of purged user", user.ID, fileErr)
+			err = fileErr
+		}
+	}
+
+	return len(users), err
+}
+
+// schedulePurge runs purgeDeletedUsers and removes expired exports every interval
+// until ctx is done.
+func (app *application) schedulePurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := app.purgeDeletedUsers()
+		if err != nil {
+			log.Println("purging deleted users:", err)
+		} else if n > 0 {
+			log.Printf("purged %d deleted users", n)
+		}
+		if err = removeExpiredExports(); err != nil {
+			log.Println("removing expired exports:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_app_purgeDeletedUsers(t *testing.T) {
+	defer func(retention time.Duration) { app.UserRetention = retention }(app.UserRetention)
+
+	var tests = []struct {
+		name           string
+		retention      time.Duration
+		expectedPurged int
+	}{
+		{name: "past retention", retention: time.Hour, expectedPurged: 1},
+		{name: "within retention", retention: 48 * time.Hour, expectedPurged: 0},
+		{name: "no retention", retention: 0, expectedPurged: 0},
+	}
+
+	for _, e := range tests {
+		app.UserRetention = e.retention
+		n, err := app.purgeDeletedUsers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != e.expectedPurged {
+			t.Errorf("%s: expected %d users purged, but got %d", e.name, e.expectedPurged, n)
+		}
+	}
+}
+
+func Test_app_purgeDeletedUsersRemovesFiles(t *testing.T) {
+	defer func(retention time.Duration) { app.UserRetention = retention }(app.UserRetention)
+	app.UserRetention = time.Hour
+	useTempDirs(t)
+
+	export := exportFile(5, "some-hash")
+	image := filepath.Join(uploadPath, "shared.png")
+	for _, name := range []string{export, image} {
+		if err := os.WriteFile(name, []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := app.purgeDeletedUsers(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(export); !os.IsNotExist(err) {
+		t.Error("expected the export of the purged user to be removed")
+	}
+	if _, err := os.Stat(image); err != nil {
+		t.Error("expected an image another user has too to be kept")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"webapp/pkg/data"
+)
+
+// requirePermission only lets through logged in users with the permission. It is
+// looked up on every request, so revoking a role takes effect straight away.
+func (app *application) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := app.Session.Get(r.Context(), "user").(data.User)
+			if !ok {
+				if wantsJSON(r) {
+					writeJSON(w, http.StatusUnauthorized, jsonMessage{Error: app.T(r, "log in first!")})
+					return
+				}
+				app.flash(r.Context(), FlashError, app.T(r, "log in first!"))
+				http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+				return
+			}
+
+			permissions, err := app.repo(r).GetUserPermissions(user.ID)
+			if err != nil {
+				app.serveError(w, r, err)
+				return
+			}
+			if !slices.Contains(permissions, permission) {
+				app.serveError(w, r, httpError(http.StatusForbidden, nil))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// hasRole reports whether a user has a role in any organization, treating lookup
+// errors as no.
+func (app *application) hasRole(userID int, role string) bool {
+	roles, err := app.DB.GetUserRoles(userID)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return slices.Contains(roles, role)
+}
+
+// adminUser is a row of the admin user list.
+type adminUser struct {
+	*data.User
+	Roles []string
+}
+
+// AdminUsers lists the users of the current organization with their roles there.
+func (app *application) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := app.repo(r).AllUsers()
+	if err != nil {
+		app.serveError(w, r, err)
+		return
+	}
+
+	rows := make([]adminUser, 0, len(users))
+	for _, u := range users {
+		roles, err := app.repo(r).GetUserRoles(u.ID)
+		if err != nil {
+			log.Println(err)
+		}
+		rows = append(rows, adminUser{User: u, Roles: roles})
+	}
+
+	roles, err := app.DB.AllRoles()
+	if err != nil {
+		log.Println(err)
+	}
+
+	td := map[string]any{"Users": rows, "Roles": roles}
+	_ = app.render(w, r, "admin-users.page.gohtml", &TemplateData{Data: td})
+}
+
+// adminTarget parses the {id} URL parameter, refusing actions an admin must not
+// take on their own account with the refusal message.
+func (app *application) adminTarget(w http.ResponseWriter, r *http.Request, refusal string) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return 0, false
+	}
+
+	user, _ := app.Session.Get(r.Context(), "user").(data.User)
+	if id == user.ID {
+		app.flash(r.Context(), FlashError, app.T(r, refusal))
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return 0, false
+	}
+
+	return id, true
+}
+
+// adminActionFailed answers an admin action on a user that failed: with a 404 if the
+// user isn't in the organization, or else by flashing msg.
+func (app *application) adminActionFailed(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		app.serveError(w, r, &appError{Status: http.StatusNotFound, Message: "there's no such user in this organization", Err: err})
+		return
+	}
+
+	log.Println(err)
+	app.flash(r.Context(), FlashError, app.T(r, msg))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// PostAdminUserRoles adds a role to a user or takes it away.
+func (app *application) PostAdminUserRoles(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	id, ok := app.adminTarget(w, r, "you can't change the roles of your own account")
+	if !ok {
+		return
+	}
+
+	form := app.form(r, r.PostForm)
+	form.Required("role", "action")
+	if !form.Valid() {
+		app.flash(r.Context(), FlashError, app.T(r, "choose a role"))
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	role := form.Data.Get("role")
+	switch form.Data.Get("action") {
+	case "add":
+		err = app.repo(r).AssignUserRole(id, role)
+	case "remove":
+		err = app.repo(r).RemoveUserRole(id, role)
+	default:
+		app.serveError(w, r, httpError(http.StatusBadRequest, nil))
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		app.flash(r.Context(), FlashError, app.T(r, "could not change the roles of that user"))
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	app.flash(r.Context(), FlashSuccess, app.T(r, "roles updated"))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// PostAdminDeleteUser deletes a user. They can be restored until the retention
+// period is over.
+func (app *application) PostAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTarget(w, r, "you can't delete your own account")
+	if !ok {
+		return
+	}
+
+	if err := app.repo(r).DeleteUser(id); err != nil {
+		app.adminActionFailed(w, r, err, "could not delete that user")
+		return
+	}
+
+	msg := app.T(r, "user deleted")
+	if app.UserRetention > 0 {
+		msg = app.T(r, "user deleted, they can be restored for %d days", int(app.UserRetention.Hours()/24))
+	}
+	app.flash(r.Context(), FlashSuccess, msg)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// PostAdminUserStatus suspends or activates a user. Activating a deleted user
+// restores them.
+func (app *application) PostAdminUserStatus(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	id, ok := app.adminTarget(w, r, "you can't change the status of your own account")
+	if !ok {
+		return
+	}
+
+	status := r.PostForm.Get("status")
+	if status != data.UserActive && status != data.UserSuspended {
+		app.serveError(w, r, httpError(http.StatusBadRequest, nil))
+		return
+	}
+
+	user, err := app.repo(r).GetUser(id)
+	if err == nil {
+		if user.Status == data.UserDeleted && status != data.UserActive {
+			err = fmt.Errorf("user %d is deleted, restore them first", id)
+		} else {
+			err = app.repo(r).SetUserStatus(id, status)
+		}
+	}
+	if err != nil {
+		app.adminActionFailed(w, r, err, "could not change the status of that user")
+		return
+	}
+
+	msg := app.T(r, "user suspended")
+	if status == data.UserActive {
+		msg = app.T(r, "user activated")
+		if user.Status == data.UserDeleted {
+			msg = app.T(r, "user restored")
+		}
+	}
+	app.flash(r.Context(), FlashSuccess, msg)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+)
+
+func Test_app_requirePermission(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var tests = []struct {
+		name               string
+		userID             int
+		expectedStatusCode int
+	}{
+		{name: "admin", userID: 1, expectedStatusCode: http.StatusOK},
+		{name: "no roles", userID: 3, expectedStatusCode: http.StatusForbidden},
+		{name: "not logged in", expectedStatusCode: http.StatusTemporaryRedirect},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/admin/users", nil)
+		req = addContextAndSessionToRequest(req, app)
+		if e.userID != 0 {
+			app.Session.Put(req.Context(), "user", data.User{ID: e.userID})
+			app.Session.Put(req.Context(), organizationKey, 1)
+		}
+
+		rr := httptest.NewRecorder()
+		app.requirePermission(data.PermissionUsersWrite)(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_hasPerm(t *testing.T) {
+	req := addContextAndSessionToRequest(httptest.NewRequest("GET", "/", nil), app)
+	td := &TemplateData{Permissions: []string{data.PermissionUsersRead}}
+	hasPerm := app.requestFuncs(req, td)["hasPerm"].(func(string) bool)
+
+	if !hasPerm(data.PermissionUsersRead) || hasPerm(data.PermissionUsersWrite) {
+		t.Error("hasPerm doesn't match the permissions")
+	}
+}
+
+func Test_app_AdminUsers(t *testing.T) {
+	var tests = []struct {
+		name          string
+		userID        int
+		expectedCodes []string
+	}{
+		{name: "admin", userID: 1, expectedCodes: []string{"totp@example.com", `action="/admin/users/3/roles"`, `action="/admin/users/3/delete"`}},
+		{name: "no roles", userID: 3, expectedCodes: []string{"totp@example.com"}},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/admin/users", nil)
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: e.userID})
+		app.Session.Put(req.Context(), organizationKey, 1)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.AdminUsers).ServeHTTP(rr, req)
+
+		for _, code := range e.expectedCodes {
+			if !strings.Contains(rr.Body.String(), code) {
+				t.Errorf("%s: expected to find %s", e.name, code)
+			}
+		}
+		if e.userID != 1 && strings.Contains(rr.Body.String(), "/delete") {
+			t.Errorf("%s: controls shown without permission", e.name)
+		}
+	}
+}
+
+func Test_app_PostAdminUserRoles(t *testing.T) {
+	var tests = []struct {
+		name               string
+		target             string
+		form               url.Values
+		expectedStatusCode int
+		expectedError      bool
+	}{
+		{name: "add", target: "3", form: url.Values{"role": {"viewer"}, "action": {"add"}}, expectedStatusCode: http.StatusSeeOther},
+		{name: "remove", target: "3", form: url.Values{"role": {"viewer"}, "action": {"remove"}}, expectedStatusCode: http.StatusSeeOther},
+		{name: "unknown role", target: "3", form: url.Values{"role": {"owner"}, "action": {"add"}}, expectedStatusCode: http.StatusSeeOther, expectedError: true},
+		{name: "own account", target: "1", form: url.Values{"role": {"admin"}, "action": {"remove"}}, expectedStatusCode: http.StatusSeeOther, expectedError: true},
+		{name: "bad action", target: "3", form: url.Values{"role": {"viewer"}, "action": {"toggle"}}, expectedStatusCode: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req := newFormRequest("POST", "/admin/users/"+e.target+"/roles", e.form)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		app.Session.Put(req.Context(), organizationKey, 1)
+		req = withURLParam(req, "id", e.target)
+
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostAdminUserRoles)).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if hasError := flashed(req, FlashError) != ""; hasError != e.expectedError {
+			t.Errorf("%s: expected error %v, but got %v", e.name, e.expectedError, hasError)
+		}
+	}
+}
+
+func Test_app_PostAdminDeleteUser(t *testing.T) {
+	var tests = []struct {
+		name               string
+		target             string
+		expectedStatusCode int
+		expectedFlash      string
+	}{
+		{name: "delete", target: "3", expectedStatusCode: http.StatusSeeOther, expectedFlash: "user deleted"},
+		{name: "own account", target: "1", expectedStatusCode: http.StatusSeeOther},
+		{name: "not a member", target: "99", expectedStatusCode: http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req := newFormRequest("POST", "/admin/users/"+e.target+"/delete", url.Values{})
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		app.Session.Put(req.Context(), organizationKey, 1)
+		req = withURLParam(req, "id", e.target)
+
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostAdminDeleteUser)).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if flash := flashed(req, FlashSuccess); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+		if e.name == "own account" && flashed(req, FlashError) == "" {
+			t.Error("expected deleting your own account to be refused")
+		}
+	}
+}
+
+func Test_app_PostAdminUserStatus(t *testing.T) {
+	var tests = []struct {
+		name               string
+		target             string
+		status             string
+		expectedStatusCode int
+		expectedFlash      string
+	}{
+		{name: "suspend", target: "3", status: "suspended", expectedStatusCode: http.StatusSeeOther, expectedFlash: "user suspended"},
+		{name: "activate", target: "4", status: "active", expectedStatusCode: http.StatusSeeOther, expectedFlash: "user activated"},
+		{name: "restore", target: "5", status: "active", expectedStatusCode: http.StatusSeeOther, expectedFlash: "user restored"},
+		{name: "suspend deleted", target: "5", status: "suspended", expectedStatusCode: http.StatusSeeOther},
+		{name: "own account", target: "1", status: "suspended", expectedStatusCode: http.StatusSeeOther},
+		{name: "bad status", target: "3", status: "deleted", expectedStatusCode: http.StatusBadRequest},
+		{name: "not a member", target: "99", status: "suspended", expectedStatusCode: http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req := newFormRequest("POST", "/admin/users/"+e.target+"/status", url.Values{"status": {e.status}})
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		app.Session.Put(req.Context(), organizationKey, 1)
+		req = withURLParam(req, "id", e.target)
+
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostAdminUserStatus)).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if flash := flashed(req, FlashSuccess); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+	}
+}
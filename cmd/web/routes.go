@@ -4,22 +4,89 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"net/http"
+	"webapp/pkg/data"
 )
 
 func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 
-	mux.Use(middleware.Recoverer)
+	mux.Use(middleware.RequestID)
 	mux.Use(app.addIPToContext)
+	mux.Use(app.secureHeaders)
 	mux.Use(app.Session.LoadAndSave)
+	// inside the session, which the error page needs
+	mux.Use(app.recoverPanic)
 
-	mux.Get("/", app.Home)
-	mux.Post("/login", app.Login)
+	mux.NotFound(app.NotFound)
+	mux.MethodNotAllowed(app.MethodNotAllowed)
 
-	mux.Route("/user", func(mux chi.Router) {
-		mux.Use(app.auth)
-		mux.Get("/profile", app.Profile)
-		mux.Post("/upload-profile-pic", app.UploadProfilePic)
+	// browsers post violation reports without a CSRF token
+	mux.Post("/csp-report", app.CSPReport)
+
+	// the OpenID Connect provider endpoints clients call directly
+	mux.Get("/.well-known/openid-configuration", app.OIDCDiscovery)
+	mux.Get("/oauth/jwks", app.JWKS)
+	mux.Post("/oauth/token", app.Token)
+	mux.Get("/oauth/userinfo", app.UserInfo)
+	mux.Post("/oauth/userinfo", app.UserInfo)
+
+	// SCIM provisioning from the corporate directory
+	mux.Route("/scim/v2", func(mux chi.Router) {
+		mux.Use(app.scimAuth)
+		mux.Get("/ServiceProviderConfig", app.SCIMServiceProviderConfig)
+		mux.Get("/Users", app.SCIMListUsers)
+		mux.Post("/Users", app.SCIMCreateUser)
+		mux.Get("/Users/{id}", app.SCIMGetUser)
+		mux.Put("/Users/{id}", app.SCIMReplaceUser)
+		mux.Patch("/Users/{id}", app.SCIMPatchUser)
+		mux.Delete("/Users/{id}", app.SCIMDeleteUser)
+	})
+
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.csrf)
+
+		mux.Get("/", app.Home)
+		mux.Post("/locale", app.PostLocale)
+		mux.Post("/login", app.Login)
+		mux.Get("/login/2fa", app.TwoFactor)
+		mux.Post("/login/2fa", app.PostTwoFactor)
+		mux.Post("/login/magic", app.PostMagicLink)
+		mux.Get("/login/magic/{token}", app.MagicLink)
+		mux.Post("/login/magic/redeem", app.PostMagicLinkRedeem)
+		mux.Get("/login/oidc", app.OIDCLogin)
+		mux.Get("/login/oidc/callback", app.OIDCCallback)
+		mux.Get("/oauth/authorize", app.Authorize)
+		mux.Post("/oauth/authorize", app.PostAuthorize)
+		mux.Get("/invitations/{token}", app.Invitation)
+		mux.Post("/invitations/accept", app.PostAcceptInvitation)
+
+		mux.Route("/user", func(mux chi.Router) {
+			mux.Use(app.auth)
+			mux.Get("/profile", app.Profile)
+			mux.Post("/upload-profile-pic", app.UploadProfilePic)
+			mux.Post("/password", app.PostChangePassword)
+			mux.Post("/timezone", app.PostTimezone)
+			mux.Get("/2fa/setup", app.TwoFactorSetup)
+			mux.Post("/2fa/setup", app.PostTwoFactorSetup)
+			mux.Post("/organization", app.PostSwitchOrganization)
+			mux.Post("/export", app.PostExport)
+			mux.Get("/export/{token}", app.Export)
+			mux.Post("/erase", app.PostErase)
+		})
+
+		mux.Route("/admin", func(mux chi.Router) {
+			mux.Use(app.auth)
+			mux.With(app.requirePermission(data.PermissionUsersRead)).Get("/users", app.AdminUsers)
+			mux.With(app.requirePermission(data.PermissionUsersRead)).Get("/users/export", app.AdminExportUsers)
+			mux.With(app.requirePermission(data.PermissionUsersWrite)).Get("/users/import", app.AdminImportUsers)
+			mux.With(app.requirePermission(data.PermissionUsersWrite)).Post("/users/import", app.PostAdminImportUsers)
+			mux.With(app.requirePermission(data.PermissionRolesWrite)).Post("/users/{id}/roles", app.PostAdminUserRoles)
+			mux.With(app.requirePermission(data.PermissionUsersWrite)).Post("/users/{id}/delete", app.PostAdminDeleteUser)
+			mux.With(app.requirePermission(data.PermissionUsersWrite)).Post("/users/{id}/status", app.PostAdminUserStatus)
+			mux.With(app.requirePermission(data.PermissionUsersWrite)).Get("/invitations", app.AdminInvitations)
+			mux.With(app.requirePermission(data.PermissionUsersWrite)).Post("/invitations", app.PostAdminInvitation)
+			mux.With(app.requirePermission(data.PermissionUsersWrite)).Post("/invitations/{id}/revoke", app.PostAdminRevokeInvitation)
+		})
 	})
 
 	fileServer := http.FileServer(http.Dir("./static"))
@@ -13,8 +13,29 @@ func Test_application_routes(t *testing.T) {
 		method string
 	}{
 		{route: "/", method: "GET"},
+		{route: "/locale", method: "POST"},
 		{route: "/login", method: "POST"},
 		{route: "/user/profile", method: "GET"},
+		{route: "/user/upload-profile-pic", method: "POST"},
+		{route: "/user/password", method: "POST"},
+		{route: "/user/timezone", method: "POST"},
+		{route: "/user/organization", method: "POST"},
+		{route: "/user/export", method: "POST"},
+		{route: "/user/export/{token}", method: "GET"},
+		{route: "/user/erase", method: "POST"},
+		{route: "/csp-report", method: "POST"},
+		{route: "/oauth/authorize", method: "GET"},
+		{route: "/oauth/token", method: "POST"},
+		{route: "/scim/v2/Users", method: "GET"},
+		{route: "/admin/users", method: "GET"},
+		{route: "/admin/users/export", method: "GET"},
+		{route: "/admin/users/import", method: "GET"},
+		{route: "/admin/users/import", method: "POST"},
+		{route: "/admin/users/{id}/roles", method: "POST"},
+		{route: "/admin/users/{id}/status", method: "POST"},
+		{route: "/admin/invitations", method: "POST"},
+		{route: "/invitations/accept", method: "POST"},
+		{route: "/scim/v2/Users/{id}", method: "PATCH"},
 		{route: "/static/*", method: "GET"},
 	}
 
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+const (
+	scimUserSchema    = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimConfigSchema  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimListSchema    = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchOpSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema   = "urn:ietf:params:scim:api:messages:2.0:Error"
+	// scimMaxResults caps the page size of a list request.
+	scimMaxResults = 200
+)
+
+// scimUser is the SCIM 2.0 core User resource. userName is the user's email address.
+type scimUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        scimName    `json:"name"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []scimEmail `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Password    string      `json:"password,omitempty"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// scimPatch is a PatchOp request body.
+type scimPatch struct {
+	Schemas    []string `json:"schemas"`
+	Operations []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	} `json:"Operations"`
+}
+
+// scimError is a failed request, sent back in the SCIM error format.
+type scimError struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *scimError) Error() string {
+	return e.Detail
+}
+
+// errUserNameTaken answers a request that would give a user another user's userName.
+var errUserNameTaken = &scimError{Status: http.StatusConflict, ScimType: "uniqueness", Detail: "userName is already taken"}
+
+func writeSCIM(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeSCIMError(w http.ResponseWriter, e *scimError) {
+	body := map[string]any{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(e.Status),
+		"detail":  e.Detail,
+	}
+	if e.ScimType != "" {
+		body["scimType"] = e.ScimType
+	}
+	writeSCIM(w, e.Status, body)
+}
+
+// scimAuth lets through requests carrying the configured SCIM bearer token. With no
+// token configured, provisioning is switched off.
+func (app *application) scimAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if app.SCIMToken == "" || !found || subtle.ConstantTimeCompare([]byte(token), []byte(app.SCIMToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			writeSCIMError(w, &scimError{Status: http.StatusUnauthorized, Detail: "a valid bearer token is required"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scimRepo returns the repository SCIM works on: the organization directories
+// provision into, or with none configured, all users.
+func (app *application) scimRepo() repository.DatabaseRepo {
+	if app.SCIMOrganization == 0 {
+		return app.DB
+	}
+	return app.DB.ForOrganization(app.SCIMOrganization)
+}
+
+func (app *application) toSCIMUser(u *data.User) scimUser {
+	id := strconv.Itoa(u.ID)
+	fullName := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	active := u.IsActive()
+
+	return scimUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          id,
+		UserName:    u.Email,
+		Name:        scimName{Formatted: fullName, GivenName: u.FirstName, FamilyName: u.LastName},
+		DisplayName: fullName,
+		Emails:      []scimEmail{{Value: u.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      u.CreatedAt,
+			LastModified: u.UpdatedAt,
+			Location:     app.BaseURL + "/scim/v2/Users/" + id,
+		},
+	}
+}
+
+// userName returns the user name, falling back to the primary email for clients
+// that only send emails.
+func (s *scimUser) userName() string {
+	if s.UserName != "" {
+		return s.UserName
+	}
+	for _, e := range s.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(s.Emails) > 0 {
+		return s.Emails[0].Value
+	}
+	return ""
+}
+
+// replace overwrites u with the resource, as a PUT does. An absent active
+// attribute leaves the status alone.
+func (s *scimUser) replace(u *data.User) {
+	u.Email = s.userName()
+	u.FirstName = s.Name.GivenName
+	u.LastName = s.Name.FamilyName
+	if s.Active != nil {
+		u.Status = scimStatus(*s.Active)
+	}
+}
+
+func scimStatus(active bool) string {
+	if active {
+		return data.UserActive
+	}
+	return data.UserSuspended
+}
+
+var userNameFilter = regexp.MustCompile(`(?i)^\s*userName\s+eq\s+("(?:[^"\\]|\\.)*")\s*$`)
+
+// parseUserNameFilter supports the one filter directories send to look a user up,
+// userName eq "value". SCIM compares userNames without regard to case, as
+// GetUserByEmail does.
+func parseUserNameFilter(filter string) (string, error) {
+	m := userNameFilter.FindStringSubmatch(filter)
+	if m == nil {
+		return "", fmt.Errorf("only userName eq filters are supported")
+	}
+	return strconv.Unquote(m[1])
+}
+
+// SCIMServiceProviderConfig tells directories which SCIM features we support.
+func (app *application) SCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, http.StatusOK, map[string]any{
+		"schemas":        []string{scimConfigSchema},
+		"patch":          map[string]any{"supported": true},
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": scimMaxResults},
+		"changePassword": map[string]any{"supported": true},
+		"sort":           map[string]any{"supported": false},
+		"etag":           map[string]any{"supported": false},
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "The token the app was started with in -scim-token",
+			"primary":     true,
+		}},
+		"meta": map[string]any{
+			"resourceType": "ServiceProviderConfig",
+			"location":     app.BaseURL + "/scim/v2/ServiceProviderConfig",
+		},
+	})
+}
+
+// SCIMListUsers lists users, one page at a time, optionally filtered by userName.
+func (app *application) SCIMListUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	startIndex, err := strconv.Atoi(q.Get("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(q.Get("count"))
+	if err != nil || count > scimMaxResults {
+		count = scimMaxResults
+	}
+	if count < 0 {
+		count = 0
+	}
+
+	var users []*data.User
+	if filter := q.Get("filter"); filter != "" {
+		userName, err := parseUserNameFilter(filter)
+		if err != nil {
+			writeSCIMError(w, &scimError{Status: http.StatusBadRequest, ScimType: "invalidFilter", Detail: err.Error()})
+			return
+		}
+		if user, err := app.scimRepo().GetUserByEmail(userName); err == nil {
+			users = append(users, user)
+		}
+	} else {
+		users, err = app.scimRepo().AllUsers()
+		if err != nil {
+			log.Println(err)
+			writeSCIMError(w, &scimError{Status: http.StatusInternalServerError, Detail: "could not list users"})
+			return
+		}
+	}
+
+	// deleted users are gone as far as the directory is concerned
+	users = slices.DeleteFunc(users, func(u *data.User) bool { return u.Status == data.UserDeleted })
+
+	from := min(startIndex-1, len(users))
+	to := min(from+count, len(users))
+	resources := make([]scimUser, 0, to-from)
+	for _, u := range users[from:to] {
+		resources = append(resources, app.toSCIMUser(u))
+	}
+
+	writeSCIM(w, http.StatusOK, map[string]any{
+		"schemas":      []string{scimListSchema},
+		"totalResults": len(users),
+		"startIndex":   startIndex,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	})
+}
+
+// scimUserFromPath loads the user named by the {id} URL parameter.
+func (app *application) scimUserFromPath(r *http.Request) (*data.User, *scimError) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err == nil {
+		var user *data.User
+		if user, err = app.scimRepo().GetUser(id); err == nil && user.Status != data.UserDeleted {
+			return user, nil
+		}
+	}
+	return nil, &scimError{Status: http.StatusNotFound, Detail: "user not found"}
+}
+
+// SCIMGetUser returns one user.
+func (app *application) SCIMGetUser(w http.ResponseWriter, r *http.Request) {
+	user, scimErr := app.scimUserFromPath(r)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+	writeSCIM(w, http.StatusOK, app.toSCIMUser(user))
+}
+
+// SCIMCreateUser provisions a new user. Without a password in the request the user
+// gets a random one, and signs in some other way until they reset it.
+func (app *application) SCIMCreateUser(w http.ResponseWriter, r *http.Request) {
+	var in scimUser
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeSCIMError(w, &scimError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "request body is not a SCIM user"})
+		return
+	}
+	if in.userName() == "" {
+		writeSCIMError(w, &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "userName is required"})
+		return
+	}
+	if _, err := app.scimRepo().GetUserByEmail(in.userName()); err == nil {
+		writeSCIMError(w, errUserNameTaken)
+		return
+	}
+
+	user := data.User{Status: data.UserActive, Password: in.Password}
+	in.replace(&user)
+	if user.Password != "" {
+		if scimErr := app.scimPasswordError(r, user.Password, 0, user); scimErr != nil {
+			writeSCIMError(w, scimErr)
+			return
+		}
+	}
+	if user.Password == "" {
+		var err error
+		if user.Password, _, err = generateToken(); err != nil {
+			log.Println(err)
+			writeSCIMError(w, &scimError{Status: http.StatusInternalServerError, Detail: "could not create user"})
+			return
+		}
+	}
+
+	err := app.scimRepo().WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		var err error
+		user.ID, err = repo.InsertUser(user)
+		if err == nil && app.SCIMOrganization != 0 {
+			err = repo.AddOrganizationMember(app.SCIMOrganization, user.ID)
+		}
+		if err == nil && user.Status != data.UserActive {
+			err = repo.SetUserStatus(user.ID, user.Status)
+		}
+		return err
+	})
+	if errors.Is(err, repository.ErrEmailTaken) {
+		// a user of another organization has the address
+		writeSCIMError(w, errUserNameTaken)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeSCIMError(w, &scimError{Status: http.StatusInternalServerError, Detail: "could not create user"})
+		return
+	}
+	user.Password = ""
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+
+	resource := app.toSCIMUser(&user)
+	w.Header().Set("Location", resource.Meta.Location)
+	writeSCIM(w, http.StatusCreated, resource)
+}
+
+// SCIMReplaceUser replaces a user with the resource in the request.
+func (app *application) SCIMReplaceUser(w http.ResponseWriter, r *http.Request) {
+	user, scimErr := app.scimUserFromPath(r)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+	before := *user
+
+	var in scimUser
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeSCIMError(w, &scimError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "request body is not a SCIM user"})
+		return
+	}
+	if in.userName() == "" {
+		writeSCIMError(w, &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "userName is required"})
+		return
+	}
+	in.replace(user)
+
+	if scimErr := app.saveSCIMUser(r, user, before, in.Password); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+	writeSCIM(w, http.StatusOK, app.toSCIMUser(user))
+}
+
+// SCIMPatchUser applies a PatchOp to a user.
+func (app *application) SCIMPatchUser(w http.ResponseWriter, r *http.Request) {
+	user, scimErr := app.scimUserFromPath(r)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+	before := *user
+
+	var patch scimPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || !slices.Contains(patch.Schemas, scimPatchOpSchema) {
+		writeSCIMError(w, &scimError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "request body is not a SCIM PatchOp"})
+		return
+	}
+
+	var password string
+	for _, op := range patch.Operations {
+		if scimErr := applySCIMOperation(user, &password, op.Op, op.Path, op.Value); scimErr != nil {
+			writeSCIMError(w, scimErr)
+			return
+		}
+	}
+
+	if scimErr := app.saveSCIMUser(r, user, before, password); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+	writeSCIM(w, http.StatusOK, app.toSCIMUser(user))
+}
+
+// applySCIMOperation applies one add, replace or remove operation to the user.
+func applySCIMOperation(user *data.User, password *string, op, path string, value json.RawMessage) *scimError {
+	switch strings.ToLower(op) {
+	case "add", "replace":
+		if path != "" {
+			return setSCIMAttribute(user, password, path, value)
+		}
+		// without a path the value is an object of attributes to set
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(value, &attrs); err != nil {
+			return &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "value must be an object when no path is given"}
+		}
+		for attr, v := range attrs {
+			if scimErr := setSCIMAttribute(user, password, attr, v); scimErr != nil {
+				return scimErr
+			}
+		}
+		return nil
+	case "remove":
+		switch strings.ToLower(path) {
+		case "":
+			return &scimError{Status: http.StatusBadRequest, ScimType: "noTarget", Detail: "remove needs a path"}
+		case "name.givenname":
+			user.FirstName = ""
+		case "name.familyname":
+			user.LastName = ""
+		default:
+			return &scimError{Status: http.StatusBadRequest, ScimType: "mutability", Detail: path + " can't be removed"}
+		}
+		return nil
+	default:
+		return &scimError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "unknown op " + op}
+	}
+}
+
+func setSCIMAttribute(user *data.User, password *string, path string, value json.RawMessage) *scimError {
+	var err error
+	attr := strings.ToLower(path)
+
+	switch {
+	case attr == "username":
+		err = json.Unmarshal(value, &user.Email)
+	case attr == "name":
+		var name scimName
+		if err = json.Unmarshal(value, &name); err == nil {
+			user.FirstName, user.LastName = name.GivenName, name.FamilyName
+		}
+	case attr == "name.givenname":
+		err = json.Unmarshal(value, &user.FirstName)
+	case attr == "name.familyname":
+		err = json.Unmarshal(value, &user.LastName)
+	case attr == "emails":
+		var emails []scimEmail
+		if err = json.Unmarshal(value, &emails); err == nil {
+			in := scimUser{Emails: emails}
+			if email := in.userName(); email != "" {
+				user.Email = email
+			}
+		}
+	case strings.HasPrefix(attr, "emails[") && strings.HasSuffix(attr, "].value"):
+		err = json.Unmarshal(value, &user.Email)
+	case attr == "active":
+		var active bool
+		active, err = scimBool(value)
+		if err == nil {
+			user.Status = scimStatus(active)
+		}
+	case attr == "password":
+		err = json.Unmarshal(value, password)
+	case attr == "displayname", attr == "externalid":
+		// not stored; the display name is derived from the name
+	default:
+		return &scimError{Status: http.StatusBadRequest, ScimType: "invalidPath", Detail: "unsupported attribute " + path}
+	}
+
+	if err != nil {
+		return &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "bad value for " + path}
+	}
+	return nil
+}
+
+// scimBool reads a boolean. Some directories send "True" and "False" as strings.
+func scimBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(strings.ToLower(s))
+}
+
+// saveSCIMUser writes the changes a PUT or PATCH made to the user.
+func (app *application) saveSCIMUser(r *http.Request, user *data.User, before data.User, password string) *scimError {
+	if user.Email == "" {
+		return &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "userName is required"}
+	}
+	if other, err := app.scimRepo().GetUserByEmail(user.Email); err == nil && other.ID != user.ID {
+		return errUserNameTaken
+	}
+
+	if password != "" {
+		if scimErr := app.scimPasswordError(r, password, user.ID, *user); scimErr != nil {
+			return scimErr
+		}
+	}
+
+	// a change is made in full or not at all
+	err := app.scimRepo().WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		err := repo.UpdateUser(*user)
+		if err == nil && user.Status != before.Status {
+			err = repo.SetUserStatus(user.ID, user.Status)
+		}
+		if err == nil && password != "" {
+			err = repo.ResetPassword(user.ID, password)
+		}
+		return err
+	})
+	if errors.Is(err, repository.ErrEmailTaken) {
+		return errUserNameTaken
+	}
+	if err != nil {
+		log.Println(err)
+		return &scimError{Status: http.StatusInternalServerError, Detail: "could not update user"}
+	}
+	user.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// scimPasswordError refuses a password that breaks the password rules.
+func (app *application) scimPasswordError(r *http.Request, password string, userID int, user data.User) *scimError {
+	problems := app.passwordProblems(r, password, userID, user.FirstName, user.LastName, user.Email)
+	if len(problems) == 0 {
+		return nil
+	}
+	return &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "password: " + strings.Join(problems, "; ")}
+}
+
+// SCIMDeleteUser deprovisions a user.
+func (app *application) SCIMDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, scimErr := app.scimUserFromPath(r)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	if err := app.scimRepo().DeleteUser(user.ID); err != nil {
+		log.Println(err)
+		writeSCIMError(w, &scimError{Status: http.StatusInternalServerError, Detail: "could not delete user"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
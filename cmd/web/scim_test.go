@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testSCIMToken = "scim-token"
+
+func scimRequest(method, target, body string) *httptest.ResponseRecorder {
+	testApp := app
+	testApp.SCIMToken = testSCIMToken
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/scim+json")
+	req.Header.Set("Authorization", "Bearer "+testSCIMToken)
+
+	rr := httptest.NewRecorder()
+	testApp.routes().ServeHTTP(rr, req)
+	return rr
+}
+
+func decodeSCIM(t *testing.T, rr *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	if ct := rr.Header().Get("Content-Type"); ct != "application/scim+json" {
+		t.Errorf("expected application/scim+json, but got %s", ct)
+	}
+	var body map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func hasSchema(body map[string]any, schema string) bool {
+	schemas, _ := body["schemas"].([]any)
+	for _, s := range schemas {
+		if s == schema {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_app_scimAuth(t *testing.T) {
+	for _, token := range []string{"", "Bearer wrong"} {
+		req := httptest.NewRequest("GET", "/scim/v2/Users", nil)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%q: expected status 401, but got %d", token, rr.Code)
+		}
+		if body := decodeSCIM(t, rr); !hasSchema(body, scimErrorSchema) || body["status"] != "401" {
+			t.Errorf("%q: expected a SCIM error, but got %v", token, body)
+		}
+	}
+}
+
+func Test_app_SCIMListUsers(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedTotal      float64
+		expectedItems      float64
+	}{
+		{name: "all", query: "", expectedStatusCode: http.StatusOK, expectedTotal: 2, expectedItems: 2},
+		{name: "paged", query: "?startIndex=2&count=1", expectedStatusCode: http.StatusOK, expectedTotal: 2, expectedItems: 1},
+		{name: "filter match", query: `?filter=userName+eq+"admin@example.com"`, expectedStatusCode: http.StatusOK, expectedTotal: 1, expectedItems: 1},
+		{name: "filter ignores case", query: `?filter=userName+eq+"Admin@Example.com"`, expectedStatusCode: http.StatusOK, expectedTotal: 1, expectedItems: 1},
+		{name: "filter no match", query: `?filter=userName+eq+"nobody@example.com"`, expectedStatusCode: http.StatusOK},
+		{name: "unsupported filter", query: `?filter=name.givenName+co+"A"`, expectedStatusCode: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		rr := scimRequest("GET", "/scim/v2/Users"+e.query, "")
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+
+		body := decodeSCIM(t, rr)
+		if rr.Code != http.StatusOK {
+			if !hasSchema(body, scimErrorSchema) || body["scimType"] != "invalidFilter" {
+				t.Errorf("%s: expected an invalidFilter error, but got %v", e.name, body)
+			}
+			continue
+		}
+
+		if !hasSchema(body, scimListSchema) {
+			t.Errorf("%s: expected a ListResponse, but got %v", e.name, body["schemas"])
+		}
+		if body["totalResults"] != e.expectedTotal || body["itemsPerPage"] != e.expectedItems {
+			t.Errorf("%s: expected %v of %v results, but got %v of %v", e.name, e.expectedItems, e.expectedTotal, body["itemsPerPage"], body["totalResults"])
+		}
+		resources, ok := body["Resources"].([]any)
+		if !ok || float64(len(resources)) != e.expectedItems {
+			t.Errorf("%s: expected %v Resources, but got %v", e.name, e.expectedItems, body["Resources"])
+		}
+		if e.name == "filter match" {
+			user := resources[0].(map[string]any)
+			if user["userName"] != "admin@example.com" || !hasSchema(user, scimUserSchema) {
+				t.Errorf("%s: unexpected resource %v", e.name, user)
+			}
+		}
+	}
+}
+
+func Test_app_SCIMGetUser(t *testing.T) {
+	rr := scimRequest("GET", "/scim/v2/Users/1", "")
+	body := decodeSCIM(t, rr)
+	if rr.Code != http.StatusOK || !hasSchema(body, scimUserSchema) || body["id"] != "1" || body["active"] != true {
+		t.Errorf("expected user 1, but got %d %v", rr.Code, body)
+	}
+	if meta, _ := body["meta"].(map[string]any); meta["resourceType"] != "User" || meta["location"] != app.BaseURL+"/scim/v2/Users/1" {
+		t.Errorf("unexpected meta %v", body["meta"])
+	}
+	if _, ok := body["password"]; ok {
+		t.Error("the password must never be returned")
+	}
+
+	for _, id := range []string{"99", "5"} {
+		rr = scimRequest("GET", "/scim/v2/Users/"+id, "")
+		body = decodeSCIM(t, rr)
+		if rr.Code != http.StatusNotFound || !hasSchema(body, scimErrorSchema) || body["status"] != "404" {
+			t.Errorf("%s: expected a 404 SCIM error, but got %d %v", id, rr.Code, body)
+		}
+	}
+}
+
+func Test_app_SCIMCreateUser(t *testing.T) {
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expectedScimType   string
+	}{
+		{name: "new user", body: `{"schemas":["` + scimUserSchema + `"],"userName":"new@example.com","name":{"givenName":"New","familyName":"User"},"active":true}`, expectedStatusCode: http.StatusCreated},
+		{name: "email only", body: `{"schemas":["` + scimUserSchema + `"],"emails":[{"value":"new@example.com","primary":true}]}`, expectedStatusCode: http.StatusCreated},
+		{name: "taken", body: `{"schemas":["` + scimUserSchema + `"],"userName":"admin@example.com"}`, expectedStatusCode: http.StatusConflict, expectedScimType: "uniqueness"},
+		{name: "taken in another case", body: `{"schemas":["` + scimUserSchema + `"],"userName":"ADMIN@example.com"}`, expectedStatusCode: http.StatusConflict, expectedScimType: "uniqueness"},
+		{name: "no userName", body: `{"schemas":["` + scimUserSchema + `"],"name":{"givenName":"New"}}`, expectedStatusCode: http.StatusBadRequest, expectedScimType: "invalidValue"},
+		{name: "not json", body: `userName=new`, expectedStatusCode: http.StatusBadRequest, expectedScimType: "invalidSyntax"},
+		{name: "short password", body: `{"schemas":["` + scimUserSchema + `"],"userName":"new@example.com","password":"secret"}`, expectedStatusCode: http.StatusBadRequest, expectedScimType: "invalidValue"},
+	}
+
+	for _, e := range tests {
+		rr := scimRequest("POST", "/scim/v2/Users", e.body)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+
+		body := decodeSCIM(t, rr)
+		if e.expectedScimType != "" {
+			if body["scimType"] != e.expectedScimType {
+				t.Errorf("%s: expected scimType %s, but got %v", e.name, e.expectedScimType, body["scimType"])
+			}
+			continue
+		}
+		if !hasSchema(body, scimUserSchema) || body["userName"] != "new@example.com" || body["id"] != "2" {
+			t.Errorf("%s: unexpected resource %v", e.name, body)
+		}
+		if rr.Header().Get("Location") != app.BaseURL+"/scim/v2/Users/2" {
+			t.Errorf("%s: unexpected location %s", e.name, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func Test_app_SCIMReplaceUser(t *testing.T) {
+	rr := scimRequest("PUT", "/scim/v2/Users/1", `{"schemas":["`+scimUserSchema+`"],"userName":"admin@example.com","name":{"givenName":"Ada","familyName":"Admin"},"active":false}`)
+	body := decodeSCIM(t, rr)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, but got %d %v", rr.Code, body)
+	}
+	if name, _ := body["name"].(map[string]any); name["givenName"] != "Ada" || body["active"] != false {
+		t.Errorf("replace not applied: %v", body)
+	}
+
+	rr = scimRequest("PUT", "/scim/v2/Users/1", `{"schemas":["`+scimUserSchema+`"],"userName":"totp@example.com"}`)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected taking another user's name to conflict, but got %d", rr.Code)
+	}
+}
+
+func Test_app_SCIMPatchUser(t *testing.T) {
+	var tests = []struct {
+		name               string
+		operations         string
+		expectedStatusCode int
+		check              func(map[string]any) bool
+	}{
+		{
+			name:               "deactivate",
+			operations:         `[{"op":"replace","path":"active","value":false}]`,
+			expectedStatusCode: http.StatusOK,
+			check:              func(u map[string]any) bool { return u["active"] == false },
+		},
+		{
+			name:               "deactivate with string and no path",
+			operations:         `[{"op":"Replace","value":{"active":"False"}}]`,
+			expectedStatusCode: http.StatusOK,
+			check:              func(u map[string]any) bool { return u["active"] == false },
+		},
+		{
+			name:               "rename",
+			operations:         `[{"op":"replace","path":"name.givenName","value":"Ada"},{"op":"remove","path":"name.familyName"}]`,
+			expectedStatusCode: http.StatusOK,
+			check: func(u map[string]any) bool {
+				name, _ := u["name"].(map[string]any)
+				return name["givenName"] == "Ada" && name["familyName"] == nil
+			},
+		},
+		{
+			name:               "change email",
+			operations:         `[{"op":"replace","path":"emails[type eq \"work\"].value","value":"ada@example.com"}]`,
+			expectedStatusCode: http.StatusOK,
+			check:              func(u map[string]any) bool { return u["userName"] == "ada@example.com" },
+		},
+		{name: "taken email", operations: `[{"op":"replace","path":"userName","value":"totp@example.com"}]`, expectedStatusCode: http.StatusConflict},
+		{name: "unknown path", operations: `[{"op":"replace","path":"title","value":"Boss"}]`, expectedStatusCode: http.StatusBadRequest},
+		{name: "remove userName", operations: `[{"op":"remove","path":"userName"}]`, expectedStatusCode: http.StatusBadRequest},
+		{name: "bad op", operations: `[{"op":"move","path":"active"}]`, expectedStatusCode: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		rr := scimRequest("PATCH", "/scim/v2/Users/1", `{"schemas":["`+scimPatchOpSchema+`"],"Operations":`+e.operations+`}`)
+		body := decodeSCIM(t, rr)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d %v", e.name, e.expectedStatusCode, rr.Code, body)
+			continue
+		}
+		if e.check != nil && (!hasSchema(body, scimUserSchema) || !e.check(body)) {
+			t.Errorf("%s: patch not applied: %v", e.name, body)
+		}
+		if e.check == nil && !hasSchema(body, scimErrorSchema) {
+			t.Errorf("%s: expected a SCIM error, but got %v", e.name, body)
+		}
+	}
+
+	rr := scimRequest("PATCH", "/scim/v2/Users/1", `{"Operations":[{"op":"replace","path":"active","value":false}]}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a patch without the PatchOp schema to be refused, but got %d", rr.Code)
+	}
+}
+
+func Test_app_SCIMDeleteUser(t *testing.T) {
+	rr := scimRequest("DELETE", "/scim/v2/Users/1", "")
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, but got %d", rr.Code)
+	}
+
+	rr = scimRequest("DELETE", "/scim/v2/Users/99", "")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, but got %d", rr.Code)
+	}
+}
+
+func Test_app_SCIMServiceProviderConfig(t *testing.T) {
+	rr := scimRequest("GET", "/scim/v2/ServiceProviderConfig", "")
+	body := decodeSCIM(t, rr)
+	if !hasSchema(body, scimConfigSchema) {
+		t.Errorf("expected a ServiceProviderConfig, but got %v", body)
+	}
+	if patch, _ := body["patch"].(map[string]any); patch["supported"] != true {
+		t.Error("expected patch to be supported")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const contextNonceKey contextKey = "csp nonce"
+
+// securityConfig is the set of security headers sent with every response.
+type securityConfig struct {
+	// CSP is the Content-Security-Policy; every {{nonce}} is replaced by the request's nonce.
+	CSP string
+	// CSPReportOnly sends the policy as Content-Security-Policy-Report-Only.
+	CSPReportOnly bool
+	// HSTSMaxAge is the Strict-Transport-Security max-age in seconds; 0 disables the header.
+	HSTSMaxAge     int
+	FrameOptions   string
+	ReferrerPolicy string
+}
+
+func defaultSecurityConfig() securityConfig {
+	return securityConfig{
+		CSP: "default-src 'self'; " +
+			"script-src 'self' 'nonce-{{nonce}}'; " +
+			"style-src 'self' 'nonce-{{nonce}}'; " +
+			"img-src 'self' data:; " +
+			"object-src 'none'; " +
+			"base-uri 'self'; " +
+			"form-action 'self'; " +
+			"frame-ancestors 'none'; " +
+			"report-uri /csp-report",
+		HSTSMaxAge:     31536000,
+		FrameOptions:   "DENY",
+		ReferrerPolicy: "strict-origin-when-cross-origin",
+	}
+}
+
+func (app *application) nonceFromContext(ctx context.Context) string {
+	if value, ok := ctx.Value(contextNonceKey).(string); ok {
+		return value
+	}
+	return ""
+}
+
+// secureHeaders sets the configured security headers and puts a fresh CSP nonce
+// into the request context, so templates can tag their script and style elements.
+func (app *application) secureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		nonce := base64.StdEncoding.EncodeToString(b)
+
+		h := w.Header()
+		if app.Security.CSP != "" {
+			header := "Content-Security-Policy"
+			if app.Security.CSPReportOnly {
+				header = "Content-Security-Policy-Report-Only"
+			}
+			h.Set(header, strings.ReplaceAll(app.Security.CSP, "{{nonce}}", nonce))
+		}
+		if app.Security.HSTSMaxAge > 0 {
+			h.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", app.Security.HSTSMaxAge))
+		}
+		if app.Security.FrameOptions != "" {
+			h.Set("X-Frame-Options", app.Security.FrameOptions)
+		}
+		if app.Security.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", app.Security.ReferrerPolicy)
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+
+		ctx := context.WithValue(r.Context(), contextNonceKey, nonce)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// allowFormAction adds source to the form-action directive of the CSP already set on
+// w. Browsers also check the redirects that follow a form submission against
+// form-action, so a page whose form ends up on another origin has to allow it.
+func allowFormAction(w http.ResponseWriter, source string) {
+	for _, header := range []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"} {
+		policy := w.Header().Get(header)
+		if policy == "" {
+			continue
+		}
+
+		directives := strings.Split(policy, ";")
+		for i, directive := range directives {
+			fields := strings.Fields(directive)
+			if len(fields) > 0 && strings.EqualFold(fields[0], "form-action") {
+				fields = slices.DeleteFunc(fields, func(s string) bool { return s == "'none'" })
+				fields = append(fields, source)
+			}
+			directives[i] = strings.Join(fields, " ")
+		}
+		w.Header().Set(header, strings.Join(directives, "; "))
+	}
+}
+
+// CSPReport collects violation reports sent by browsers and logs them.
+func (app *application) CSPReport(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var report struct {
+		Report map[string]any `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &report); err != nil || report.Report == nil {
+		// the Reporting API sends an array of reports instead
+		var reports []struct {
+			Type string         `json:"type"`
+			Body map[string]any `json:"body"`
+		}
+		if err := json.Unmarshal(body, &reports); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		for _, rep := range reports {
+			log.Printf("csp violation from %s: %v", app.ipFromContext(r.Context()), rep.Body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	log.Printf("csp violation from %s: %v", app.ipFromContext(r.Context()), report.Report)
+	w.WriteHeader(http.StatusNoContent)
+}
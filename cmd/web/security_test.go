@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_app_secureHeaders(t *testing.T) {
+	var nonces []string
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces = append(nonces, app.nonceFromContext(r.Context()))
+	})
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		app.secureHeaders(nextHandler).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		csp := rr.Header().Get("Content-Security-Policy")
+		if !strings.Contains(csp, "'nonce-"+nonces[i]+"'") {
+			t.Errorf("expected the CSP to contain the request nonce, but got %q", csp)
+		}
+		for _, header := range []string{"Strict-Transport-Security", "X-Frame-Options", "Referrer-Policy", "X-Content-Type-Options"} {
+			if rr.Header().Get(header) == "" {
+				t.Errorf("expected %s to be set", header)
+			}
+		}
+	}
+
+	if nonces[0] == "" || nonces[0] == nonces[1] {
+		t.Error("expected a fresh nonce per request")
+	}
+}
+
+func Test_app_secureHeadersReportOnly(t *testing.T) {
+	testApp := app
+	testApp.Security.CSPReportOnly = true
+	testApp.Security.HSTSMaxAge = 0
+
+	rr := httptest.NewRecorder()
+	testApp.secureHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Header().Get("Content-Security-Policy") != "" {
+		t.Error("expected no enforcing CSP in report-only mode")
+	}
+	if rr.Header().Get("Content-Security-Policy-Report-Only") == "" {
+		t.Error("expected a report-only CSP")
+	}
+	if rr.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("expected HSTS to be disabled")
+	}
+}
+
+func Test_allowFormAction(t *testing.T) {
+	var tests = []struct {
+		name     string
+		policy   string
+		source   string
+		expected string
+	}{
+		{name: "self", policy: "default-src 'self'; form-action 'self'; frame-ancestors 'none'", source: "https://client.example",
+			expected: "default-src 'self'; form-action 'self' https://client.example; frame-ancestors 'none'"},
+		{name: "none", policy: "form-action 'none'", source: "com.example.app:", expected: "form-action com.example.app:"},
+		{name: "no directive", policy: "default-src 'self'", source: "https://client.example", expected: "default-src 'self'"},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		rr.Header().Set("Content-Security-Policy", e.policy)
+		allowFormAction(rr, e.source)
+
+		if got := rr.Header().Get("Content-Security-Policy"); got != e.expected {
+			t.Errorf("%s: expected %q, but got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func Test_app_CSPReport(t *testing.T) {
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+	}{
+		{name: "csp report", body: `{"csp-report":{"document-uri":"https://example.com/","violated-directive":"script-src"}}`, expectedStatusCode: http.StatusNoContent},
+		{name: "reporting api", body: `[{"type":"csp-violation","body":{"blockedURL":"inline"}}]`, expectedStatusCode: http.StatusNoContent},
+		{name: "garbage", body: `not json`, expectedStatusCode: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/csp-report", strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/csp-report")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.CSPReport).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
@@ -1,16 +1,51 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/gob"
+	"log"
 	"os"
 	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
 	"webapp/pkg/repository/dbrepo"
 )
 
 var app application
 
+// testMailer keeps sent mail so tests can look at it.
+type testMailer struct {
+	sent []mailer.Message
+}
+
+func (m *testMailer) Send(msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
 func TestMain(m *testing.M) {
-	pathToTemplates = "./../../templates/"
+	gob.Register(data.User{})
+	gob.Register([]Flash{})
+	gob.Register(flashedForm{})
+	pathToTemplates = "./../../templates/html/"
+	templates, err := newTemplateCache(templateFS(pathToTemplates), false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Templates = templates
 	app.Session = getSession()
+	app.Security = defaultSecurityConfig()
+	app.Mailer = &testMailer{}
+	app.Background = newBackground(1, backgroundQueue)
+	app.Exports = newBackground(1, exportQueue)
+	app.BaseURL = "https://example.com"
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.IDP = newIdentityProvider(app.BaseURL, key)
 
 	app.DB = &dbrepo.TestDBRepo{}
 
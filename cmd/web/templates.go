@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	"time"
+	"webapp/templates"
+)
+
+// pathToTemplates is the directory pages and layouts are read from. When it's empty
+// the templates embedded in the binary are used.
+var pathToTemplates = ""
+
+// templateFS returns the templates of a directory, or the embedded ones for "".
+func templateFS(dir string) fs.FS {
+	if dir == "" {
+		html, _ := fs.Sub(templates.Html, "html")
+		return html
+	}
+	return os.DirFS(dir)
+}
+
+// templateCache holds every page parsed with the layouts and partials. In dev mode pages are
+// parsed again whenever a template file changed since they last were.
+type templateCache struct {
+	fsys fs.FS
+	dev  bool
+
+	mu       sync.RWMutex
+	pages    map[string]*template.Template
+	modified time.Time
+}
+
+// newTemplateCache parses all the pages of fsys, so a broken one is found at startup.
+func newTemplateCache(fsys fs.FS, dev bool) (*templateCache, error) {
+	c := &templateCache{fsys: fsys, dev: dev}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load parses the *.page.gohtml files, each with the *.layout.gohtml files and the
+// components in partials.
+func (c *templateCache) load() error {
+	modified, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+
+	names, err := fs.Glob(c.fsys, "*.page.gohtml")
+	if err != nil {
+		return err
+	}
+
+	layouts, err := fs.Glob(c.fsys, "*.layout.gohtml")
+	if err != nil {
+		return err
+	}
+
+	partials, err := fs.Glob(c.fsys, "partials/*.gohtml")
+	if err != nil {
+		return err
+	}
+	shared := append(layouts, partials...)
+
+	pages := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		t, err := template.New(path.Base(name)).Funcs(templateFuncs).ParseFS(c.fsys, append([]string{name}, shared...)...)
+		if err != nil {
+			return err
+		}
+		pages[name] = t
+	}
+
+	c.mu.Lock()
+	c.pages, c.modified = pages, modified
+	c.mu.Unlock()
+	return nil
+}
+
+// lastModified returns when a template file last changed.
+func (c *templateCache) lastModified() (time.Time, error) {
+	var latest time.Time
+	err := fs.WalkDir(c.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return latest, err
+}
+
+// page returns a copy of a page to bind the request's functions to.
+func (c *templateCache) page(name string) (*template.Template, error) {
+	if c.dev {
+		modified, err := c.lastModified()
+		if err != nil {
+			return nil, err
+		}
+		c.mu.RLock()
+		changed := modified.After(c.modified)
+		c.mu.RUnlock()
+		if changed {
+			if err = c.load(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	c.mu.RLock()
+	t, ok := c.pages[name]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("there is no page %s", name)
+	}
+	return t.Clone()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_newTemplateCache_embedded(t *testing.T) {
+	c, err := newTemplateCache(templateFS(""), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range []string{"home.page.gohtml", "profile.page.gohtml"} {
+		if _, err = c.page(page); err != nil {
+			t.Errorf("expected %s to be embedded: %v", page, err)
+		}
+	}
+}
+
+func Test_templateCache_dev(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string, modified time.Time) {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(file, modified, modified)
+	}
+	start := time.Now().Add(-time.Hour)
+	write("base.layout.gohtml", `{{define "base"}}<main>{{block "content" .}}{{end}}</main>{{end}}`, start)
+	write("a.page.gohtml", `{{template "base" .}}{{define "content"}}a{{end}}`, start)
+
+	for _, dev := range []bool{false, true} {
+		c, err := newTemplateCache(templateFS(dir), dev)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		write("b.page.gohtml", `{{template "base" .}}{{define "content"}}b{{end}}`, start.Add(time.Minute))
+		if _, err = c.page("b.page.gohtml"); (err == nil) != dev {
+			t.Errorf("dev %v: expected a new page to be found only in dev mode, but got %v", dev, err)
+		}
+
+		write("a.page.gohtml", `{{template "base" .}}{{define "content"}}{{$broken}}{{end}}`, start.Add(2*time.Minute))
+		if _, err = c.page("a.page.gohtml"); (err != nil) != dev {
+			t.Errorf("dev %v: expected a broken page to fail only in dev mode, but got %v", dev, err)
+		}
+
+		write("a.page.gohtml", `{{template "base" .}}{{define "content"}}a{{end}}`, start)
+		_ = os.Remove(filepath.Join(dir, "b.page.gohtml"))
+	}
+}
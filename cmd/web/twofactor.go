@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/base64"
+	"github.com/skip2/go-qrcode"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/totp"
+)
+
+const (
+	twoFactorUserKey          = "2fa_user_id"
+	twoFactorAttemptsKey      = "2fa_attempts"
+	twoFactorSetupRequiredKey = "2fa_setup_required"
+	totpPendingSecretKey      = "totp_pending_secret"
+
+	totpIssuer           = "WebApp"
+	maxTwoFactorAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+// TwoFactor shows the second step of the login for users with an authenticator.
+func (app *application) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !app.Session.Exists(r.Context(), twoFactorUserKey) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	_ = app.render(w, r, "two-factor.page.gohtml", &TemplateData{})
+}
+
+// PostTwoFactor checks the authenticator or recovery code and finishes the login.
+func (app *application) PostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id := app.Session.GetInt(r.Context(), twoFactorUserKey)
+	if id == 0 {
+		app.flash(r.Context(), FlashError, app.T(r, "log in first!"))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	form := app.form(r, r.PostForm)
+	form.Required("code")
+
+	user, err := app.DB.GetUser(id)
+	if err != nil || !form.Valid() || !app.checkSecondFactor(user, form.Data.Get("code")) {
+		attempts := app.Session.GetInt(r.Context(), twoFactorAttemptsKey) + 1
+		if attempts >= maxTwoFactorAttempts {
+			app.Session.Remove(r.Context(), twoFactorUserKey)
+			app.Session.Remove(r.Context(), twoFactorAttemptsKey)
+			app.flash(r.Context(), FlashError, app.T(r, "too many attempts, log in again"))
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		app.Session.Put(r.Context(), twoFactorAttemptsKey, attempts)
+		app.flash(r.Context(), FlashError, app.T(r, "invalid authentication code"))
+		http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	app.Session.Remove(r.Context(), twoFactorUserKey)
+	app.Session.Remove(r.Context(), twoFactorAttemptsKey)
+	app.logIn(r, user)
+
+	app.flash(r.Context(), FlashSuccess, app.T(r, "successfully logged in"))
+	http.Redirect(w, r, app.afterLoginURL(r), http.StatusSeeOther)
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery code.
+// A TOTP code only works once, and not after a later one was used.
+func (app *application) checkSecondFactor(user *data.User, code string) bool {
+	secret, err := app.DB.GetTOTPSecret(user.ID)
+	if err != nil {
+		log.Println(err)
+	} else if step, ok := totp.Match(secret, code, time.Now()); ok {
+		used, err := app.DB.UseTOTPStep(user.ID, step)
+		if err != nil {
+			log.Println(err)
+		}
+		return used
+	}
+	if len(code) <= totp.Digits {
+		return false
+	}
+	ok, err := app.DB.UseRecoveryCode(user.ID, totp.HashRecoveryCode(code))
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return ok
+}
+
+// TwoFactorSetup shows the QR code of a new authenticator secret. The secret only
+// lives in the session until the user confirms it with a code.
+func (app *application) TwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	if user.TOTPEnabled {
+		app.flash(r.Context(), FlashError, app.T(r, "two-factor authentication is already enabled"))
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	secret := app.Session.GetString(r.Context(), totpPendingSecretKey)
+	if secret == "" {
+		var err error
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			app.serveError(w, r, err)
+			return
+		}
+		app.Session.Put(r.Context(), totpPendingSecretKey, secret)
+	}
+
+	png, err := qrcode.Encode(totp.URI(totpIssuer, user.Email, secret), qrcode.Medium, 256)
+	if err != nil {
+		app.serveError(w, r, err)
+		return
+	}
+
+	td := map[string]any{
+		"Secret": secret,
+		"QRCode": template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+	}
+	_ = app.render(w, r, "two-factor-setup.page.gohtml", &TemplateData{Data: td})
+}
+
+// PostTwoFactorSetup confirms the pending secret and shows the recovery codes, once.
+func (app *application) PostTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.serveError(w, r, httpError(http.StatusBadRequest, err))
+		return
+	}
+
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	secret := app.Session.GetString(r.Context(), totpPendingSecretKey)
+
+	form := app.form(r, r.PostForm)
+	form.Required("code")
+	step, ok := totp.Match(secret, form.Data.Get("code"), time.Now())
+	if secret != "" {
+		form.Check(ok, "code", app.T(r, "invalid authentication code"))
+	}
+
+	if secret == "" || !form.Valid() {
+		app.flash(r.Context(), FlashError, app.T(r, "invalid authentication code"))
+		http.Redirect(w, r, "/user/2fa/setup", http.StatusSeeOther)
+		return
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		app.serveError(w, r, err)
+		return
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, totp.HashRecoveryCode(code))
+	}
+
+	err = app.DB.EnableUserTOTP(user.ID, secret, step, hashes)
+	if err != nil {
+		app.serveError(w, r, err)
+		return
+	}
+
+	user.TOTPEnabled = true
+	app.Session.Put(r.Context(), "user", user)
+	app.Session.Remove(r.Context(), totpPendingSecretKey)
+	app.Session.Remove(r.Context(), twoFactorSetupRequiredKey)
+
+	_ = app.render(w, r, "recovery-codes.page.gohtml", &TemplateData{Data: map[string]any{"RecoveryCodes": codes}})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/totp"
+)
+
+func Test_app_LoginWithTwoFactor(t *testing.T) {
+	req := newFormRequest("POST", "/login", url.Values{
+		"email":    {"totp@example.com"},
+		"password": {"secret"},
+	})
+	rr := httptest.NewRecorder()
+	app.csrf(http.HandlerFunc(app.Login)).ServeHTTP(rr, req)
+
+	if loc, _ := rr.Result().Location(); loc == nil || loc.String() != "/login/2fa" {
+		t.Errorf("expected a redirect to the second factor, but got %v", loc)
+	}
+	if app.Session.Exists(req.Context(), "user") {
+		t.Error("user was logged in before passing the second factor")
+	}
+	if app.Session.GetInt(req.Context(), twoFactorUserKey) != 3 {
+		t.Error("expected the pending user id in the session")
+	}
+}
+
+func Test_app_PostTwoFactor(t *testing.T) {
+	// the previous step's code, so the current one is still left to log in with after it
+	validCode, _ := totp.Code(dbrepo.TestTOTPSecret, time.Now().Add(-totp.Period))
+	laterCode, _ := totp.Code(dbrepo.TestTOTPSecret, time.Now())
+
+	var tests = []struct {
+		name        string
+		pendingUser int
+		code        string
+		attempts    int
+		expectedLoc string
+		loggedIn    bool
+	}{
+		{name: "no pending login", pendingUser: 0, code: validCode, expectedLoc: "/"},
+		{name: "valid code", pendingUser: 3, code: validCode, expectedLoc: "/user/profile", loggedIn: true},
+		{name: "replayed code", pendingUser: 3, code: validCode, expectedLoc: "/login/2fa"},
+		{name: "later code", pendingUser: 3, code: laterCode, expectedLoc: "/user/profile", loggedIn: true},
+		{name: "recovery code", pendingUser: 3, code: dbrepo.TestRecoveryCode, expectedLoc: "/user/profile", loggedIn: true},
+		{name: "invalid code", pendingUser: 3, code: "000000", expectedLoc: "/login/2fa"},
+		{name: "missing code", pendingUser: 3, code: "", expectedLoc: "/login/2fa"},
+		{name: "too many attempts", pendingUser: 3, code: "000000", attempts: maxTwoFactorAttempts - 1, expectedLoc: "/"},
+	}
+
+	for _, e := range tests {
+		req := newFormRequest("POST", "/login/2fa", url.Values{"code": {e.code}})
+		if e.pendingUser != 0 {
+			app.Session.Put(req.Context(), twoFactorUserKey, e.pendingUser)
+		}
+		if e.attempts != 0 {
+			app.Session.Put(req.Context(), twoFactorAttemptsKey, e.attempts)
+		}
+
+		rr := httptest.NewRecorder()
+		app.csrf(http.HandlerFunc(app.PostTwoFactor)).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status 303, but got %d", e.name, rr.Code)
+		}
+		if loc, _ := rr.Result().Location(); loc == nil || loc.String() != e.expectedLoc {
+			t.Errorf("%s: expected location %s, but got %v", e.name, e.expectedLoc, loc)
+		}
+		if app.Session.Exists(req.Context(), "user") != e.loggedIn {
+			t.Errorf("%s: expected logged in to be %v", e.name, e.loggedIn)
+		}
+		if e.loggedIn && flashed(req, FlashSuccess) != "successfully logged in" {
+			t.Errorf("%s: expected a flash for the login", e.name)
+		}
+	}
+}
+
+func Test_app_TwoFactorSetup(t *testing.T) {
+	req := httptest.NewRequest("GET", "/user/2fa/setup", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: 1, Email: "admin@example.com"})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.TwoFactorSetup).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, but got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `src="data:image/png;base64,`) {
+		t.Error("expected an inline QR code")
+	}
+
+	secret := app.Session.GetString(req.Context(), totpPendingSecretKey)
+	if secret == "" {
+		t.Fatal("expected a pending secret in the session")
+	}
+
+	// confirm with a wrong code first, then a right one
+	for _, code := range []string{"000000", ""} {
+		if code == "" {
+			code, _ = totp.Code(secret, time.Now())
+		}
+		post := newFormRequest("POST", "/user/2fa/setup", url.Values{"code": {code}})
+		post = post.WithContext(req.Context())
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(app.PostTwoFactorSetup).ServeHTTP(rr, post)
+	}
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 after confirming, but got %d", rr.Code)
+	}
+	if strings.Count(rr.Body.String(), "<li>") != recoveryCodeCount {
+		t.Errorf("expected %d recovery codes on the page", recoveryCodeCount)
+	}
+	if !app.Session.Get(req.Context(), "user").(data.User).TOTPEnabled {
+		t.Error("expected the session user to have 2FA enabled")
+	}
+}
+
+func Test_app_requireAdminTwoFactor(t *testing.T) {
+	testApp := app
+	testApp.RequireAdmin2FA = true
+
+	req := newFormRequest("POST", "/login", url.Values{
+		"email":    {"admin@example.com"},
+		"password": {"secret"},
+	})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(testApp.Login).ServeHTTP(rr, req)
+
+	if loc, _ := rr.Result().Location(); loc == nil || loc.String() != "/user/2fa/setup" {
+		t.Fatalf("expected admin to be sent to 2FA setup, but got %v", loc)
+	}
+
+	profile := httptest.NewRequest("GET", "/user/profile", nil).WithContext(req.Context())
+	rr = httptest.NewRecorder()
+	testApp.auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, profile)
+
+	if loc, _ := rr.Result().Location(); loc == nil || loc.String() != "/user/2fa/setup" {
+		t.Errorf("expected other pages to redirect to 2FA setup, but got %v", loc)
+	}
+}
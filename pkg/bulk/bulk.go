@@ -0,0 +1,370 @@
+// Package bulk imports users from CSV or NDJSON files and exports them again, for
+// the admin pages and the CLI.
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/forms"
+	"webapp/pkg/passwords"
+	"webapp/pkg/repository"
+)
+
+// The formats of import and export files. NDJSON has a JSON object per line.
+const (
+	CSV    = "csv"
+	NDJSON = "ndjson"
+)
+
+// columns are the fields of an imported user. Role is optional.
+var columns = []string{"first_name", "last_name", "email", "password", "role"}
+
+// FormatOf picks the format of a file by its extension, defaulting to CSV.
+func FormatOf(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".ndjson", ".jsonl", ".json":
+		return NDJSON
+	}
+	return CSV
+}
+
+// Row is one record of an import file.
+type Row struct {
+	Line   int
+	Values url.Values
+	// Err is set when the record couldn't be decoded.
+	Err error
+}
+
+// ReadRows reads the records of a CSV file with a header line, or of an NDJSON file
+// of objects with string values.
+func ReadRows(r io.Reader, format string) ([]Row, error) {
+	switch format {
+	case CSV:
+		return readCSV(r)
+	case NDJSON:
+		return readNDJSON(r)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func readCSV(r io.Reader) ([]Row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, h := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(h))
+	}
+
+	var rows []Row
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+		values := url.Values{}
+		for i, v := range record {
+			if i < len(header) {
+				values.Set(header[i], strings.TrimSpace(v))
+			}
+		}
+		rows = append(rows, Row{Line: line, Values: values})
+	}
+}
+
+func readNDJSON(r io.Reader) ([]Row, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []Row
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var record map[string]string
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			rows = append(rows, Row{Line: line, Err: err})
+			continue
+		}
+
+		values := url.Values{}
+		for k, v := range record {
+			values.Set(strings.ToLower(k), strings.TrimSpace(v))
+		}
+		rows = append(rows, Row{Line: line, Values: values})
+	}
+
+	return rows, scanner.Err()
+}
+
+// RowError is a problem with one record of an import file.
+type RowError struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e RowError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Message)
+}
+
+// Result reports what an import did.
+type Result struct {
+	Rows     int        `json:"rows"`
+	Imported int        `json:"imported"`
+	DryRun   bool       `json:"dry_run"`
+	Errors   []RowError `json:"errors"`
+}
+
+// importRow is a valid record of an import file.
+type importRow struct {
+	FirstName string `form:"first_name" validate:"required,max=255"`
+	LastName  string `form:"last_name" validate:"required,max=255"`
+	Email     string `form:"email" validate:"required,email,max=255"`
+	Password  string `form:"password" validate:"required"`
+	Role      string `form:"role"`
+}
+
+// Importer creates users from import files.
+type Importer struct {
+	// DB is the system-wide repository, so emails taken in any organization are found.
+	DB repository.DatabaseRepo
+	// Organization the users become members of; 0 leaves them in none.
+	Organization int
+	// DryRun only validates the file.
+	DryRun bool
+	// BatchSize is how many users are created per transaction. 0 creates all of them
+	// in one, so a failure leaves nothing behind.
+	BatchSize int
+	// Rules the imported passwords must follow.
+	Rules passwords.Rules
+	// NoRoles refuses records with a role, for admins who can't hand out roles.
+	NoRoles bool
+}
+
+// Import validates every record of a file and, when none has errors, creates the
+// users. Users imported into an organization without a role become viewers.
+func (im *Importer) Import(r io.Reader, format string) (*Result, error) {
+	rows, err := ReadRows(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := im.DB.AllRoles()
+	if err != nil {
+		return nil, err
+	}
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+
+	result := &Result{Rows: len(rows), DryRun: im.DryRun}
+	users := make([]data.ImportedUser, 0, len(rows))
+	lines := make([]int, 0, len(rows))
+	seen := map[string]bool{}
+
+	for _, row := range rows {
+		if row.Err != nil {
+			result.Errors = append(result.Errors, RowError{Line: row.Line, Message: row.Err.Error()})
+			continue
+		}
+
+		row.Values.Set("email", strings.ToLower(row.Values.Get("email")))
+		var u importRow
+		form := forms.Bind(row.Values, &u)
+		form.Check(!seen[u.Email], "email", "This email address appears more than once")
+		if u.Email != "" {
+			_, err := im.DB.GetUserByEmail(u.Email)
+			form.Check(err != nil, "email", "A user with this email address already exists")
+		}
+		form.Check(u.Role == "" || !im.NoRoles, "role", "You can't hand out roles")
+		form.Check(u.Role == "" || im.NoRoles || slices.Contains(roleNames, u.Role), "role", "There is no role called "+u.Role)
+		if u.Password != "" {
+			problems, err := im.Rules.Check(u.Password, u.FirstName, u.LastName, u.Email)
+			if err != nil {
+				return nil, err
+			}
+			for _, msg := range problems {
+				form.Errors.Add("password", msg)
+			}
+		}
+
+		if !form.Valid() {
+			for _, field := range columns {
+				for _, msg := range form.Errors[field] {
+					result.Errors = append(result.Errors, RowError{Line: row.Line, Field: field, Message: msg})
+				}
+			}
+			continue
+		}
+
+		seen[u.Email] = true
+		if u.Role == "" && im.Organization != 0 {
+			u.Role = data.RoleViewer
+		}
+		users = append(users, data.ImportedUser{
+			User: data.User{
+				FirstName: u.FirstName,
+				LastName:  u.LastName,
+				Email:     u.Email,
+				Password:  u.Password,
+			},
+			Role: u.Role,
+		})
+		lines = append(lines, row.Line)
+	}
+
+	if len(result.Errors) > 0 || im.DryRun {
+		return result, nil
+	}
+
+	repo := im.DB
+	if im.Organization != 0 {
+		repo = im.DB.ForOrganization(im.Organization)
+	}
+
+	size := im.BatchSize
+	if size <= 0 {
+		size = len(users)
+	}
+	for start := 0; start < len(users); start += size {
+		end := min(start+size, len(users))
+		if _, err = repo.ImportUsers(users[start:end]); err != nil {
+			return result, fmt.Errorf("importing the users from line %d on: %w", lines[start], err)
+		}
+		result.Imported = end
+	}
+
+	return result, nil
+}
+
+// exportColumns are the fields written for each user; never the password hash.
+var exportColumns = []string{"id", "first_name", "last_name", "email", "status", "created_at"}
+
+type exportedUser struct {
+	ID        int       `json:"id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserWriter writes users as CSV with a header line or as NDJSON, one user at a
+// time, so an export needn't hold all of them.
+type UserWriter struct {
+	format string
+	csv    *csv.Writer
+	json   *json.Encoder
+	// written counts the users written so far.
+	written int
+}
+
+// NewUserWriter returns a writer of users in a format. Nothing is written before the
+// first user or Flush.
+func NewUserWriter(w io.Writer, format string) (*UserWriter, error) {
+	switch format {
+	case CSV:
+		return &UserWriter{format: format, csv: csv.NewWriter(w)}, nil
+	case NDJSON:
+		return &UserWriter{format: format, json: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// Started reports whether a user was written. Before that nothing reaches the
+// underlying writer.
+func (uw *UserWriter) Started() bool {
+	return uw.written > 0
+}
+
+// Write writes one user.
+func (uw *UserWriter) Write(u *data.User) error {
+	if uw.format == NDJSON {
+		uw.written++
+		return uw.json.Encode(exportedUser{
+			ID:        u.ID,
+			FirstName: u.FirstName,
+			LastName:  u.LastName,
+			Email:     u.Email,
+			Status:    u.Status,
+			CreatedAt: u.CreatedAt,
+		})
+	}
+
+	if err := uw.header(); err != nil {
+		return err
+	}
+	uw.written++
+	err := uw.csv.Write([]string{strconv.Itoa(u.ID), u.FirstName, u.LastName, u.Email, u.Status, u.CreatedAt.Format(time.RFC3339)})
+	if err != nil {
+		return err
+	}
+	if uw.written%100 == 0 {
+		uw.csv.Flush()
+	}
+	return uw.csv.Error()
+}
+
+// header writes the CSV header line before the first user.
+func (uw *UserWriter) header() error {
+	if uw.written > 0 {
+		return nil
+	}
+	return uw.csv.Write(exportColumns)
+}
+
+// Flush writes what is buffered, and the CSV header line of an export without users.
+func (uw *UserWriter) Flush() error {
+	if uw.format == NDJSON {
+		return nil
+	}
+	if err := uw.header(); err != nil {
+		return err
+	}
+	uw.csv.Flush()
+	return uw.csv.Error()
+}
+
+// WriteUsers writes users as CSV with a header line or as NDJSON.
+func WriteUsers(w io.Writer, format string, users []*data.User) error {
+	uw, err := NewUserWriter(w, format)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if err = uw.Write(u); err != nil {
+			return err
+		}
+	}
+	return uw.Flush()
+}
@@ -0,0 +1,145 @@
+package bulk
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/passwords"
+	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
+)
+
+// batchRepo records the batches users are imported in.
+type batchRepo struct {
+	dbrepo.TestDBRepo
+	batches [][]data.ImportedUser
+}
+
+func (m *batchRepo) ForOrganization(id int) repository.DatabaseRepo {
+	return m
+}
+
+func (m *batchRepo) ImportUsers(users []data.ImportedUser) ([]int, error) {
+	m.batches = append(m.batches, users)
+	return m.TestDBRepo.ImportUsers(users)
+}
+
+func TestReadRows(t *testing.T) {
+	var tests = []struct {
+		name          string
+		format        string
+		content       string
+		expectedLines []int
+		expectedEmail string
+	}{
+		{name: "csv", format: CSV, content: "Email, First_Name\n\"a@example.com\",A\nb@example.com,B\n", expectedLines: []int{2, 3}, expectedEmail: "a@example.com"},
+		{name: "ndjson", format: NDJSON, content: "{\"email\":\"a@example.com\"}\n\n{\"email\":\"b@example.com\"}\n", expectedLines: []int{1, 3}, expectedEmail: "a@example.com"},
+	}
+
+	for _, e := range tests {
+		rows, err := ReadRows(strings.NewReader(e.content), e.format)
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		if len(rows) != len(e.expectedLines) {
+			t.Fatalf("%s: expected %d rows, but got %d", e.name, len(e.expectedLines), len(rows))
+		}
+		for i, row := range rows {
+			if row.Line != e.expectedLines[i] {
+				t.Errorf("%s: expected row %d on line %d, but got %d", e.name, i, e.expectedLines[i], row.Line)
+			}
+		}
+		if rows[0].Values.Get("email") != e.expectedEmail {
+			t.Errorf("%s: unexpected values %v", e.name, rows[0].Values)
+		}
+	}
+
+	rows, _ := ReadRows(strings.NewReader("not json\n"), NDJSON)
+	if len(rows) != 1 || rows[0].Err == nil {
+		t.Error("expected a malformed line to be reported on its row")
+	}
+}
+
+func TestImporter_Import(t *testing.T) {
+	content := "first_name,last_name,email,password,role\n"
+	for i := 0; i < 5; i++ {
+		content += fmt.Sprintf("User,%d,user%d@example.com,correct horse battery staple,\n", i, i)
+	}
+
+	var tests = []struct {
+		name            string
+		dryRun          bool
+		batchSize       int
+		expectedBatches int
+	}{
+		{name: "one transaction", expectedBatches: 1},
+		{name: "batches", batchSize: 2, expectedBatches: 3},
+		{name: "dry run", dryRun: true},
+	}
+
+	for _, e := range tests {
+		repo := &batchRepo{}
+		importer := Importer{DB: repo, Organization: 1, DryRun: e.dryRun, BatchSize: e.batchSize}
+
+		result, err := importer.Import(strings.NewReader(content), CSV)
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		if len(repo.batches) != e.expectedBatches {
+			t.Errorf("%s: expected %d batches, but got %d", e.name, e.expectedBatches, len(repo.batches))
+		}
+		if result.Rows != 5 || len(result.Errors) != 0 {
+			t.Errorf("%s: unexpected result %+v", e.name, result)
+		}
+		if !e.dryRun && (result.Imported != 5 || repo.batches[0][0].Role != data.RoleViewer) {
+			t.Errorf("%s: expected 5 viewers imported, but got %+v", e.name, result)
+		}
+	}
+
+	repo := &batchRepo{}
+	importer := Importer{DB: repo, Organization: 1, NoRoles: true}
+	result, _ := importer.Import(strings.NewReader("first_name,last_name,email,password,role\nUser,0,user0@example.com,correct horse battery staple,admin\n"), CSV)
+	if len(result.Errors) != 1 || result.Errors[0].Field != "role" || len(repo.batches) != 0 {
+		t.Errorf("expected a role to be refused, but got %+v", result.Errors)
+	}
+
+	importer = Importer{DB: &batchRepo{}, Rules: passwords.Rules{MinScore: 2}}
+	result, _ = importer.Import(strings.NewReader("first_name,last_name,email,password\nUser,0,user0@example.com,password1\n"), CSV)
+	if len(result.Errors) != 1 || result.Errors[0].Field != "password" {
+		t.Errorf("expected a guessable password to be refused, but got %+v", result.Errors)
+	}
+}
+
+func TestWriteUsers(t *testing.T) {
+	users := []*data.User{{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com", Password: "$2a$12$hash", Status: data.UserActive}}
+
+	for _, format := range []string{CSV, NDJSON} {
+		var buf bytes.Buffer
+		if err := WriteUsers(&buf, format, users); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "admin@example.com") || strings.Contains(buf.String(), "$2a$") {
+			t.Errorf("%s: unexpected export %s", format, buf.String())
+		}
+	}
+}
+
+func TestUserWriter(t *testing.T) {
+	var buf bytes.Buffer
+	uw, err := NewUserWriter(&buf, CSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = uw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if uw.Started() || buf.String() != "id,first_name,last_name,email,status,created_at\n" {
+		t.Errorf("expected only the header line of an empty export, but got %q", buf.String())
+	}
+
+	if _, err = NewUserWriter(&buf, "xml"); err == nil {
+		t.Error("expected an unknown format to be refused")
+	}
+}
@@ -0,0 +1,21 @@
+package data
+
+import "time"
+
+// InvitationLifetime is how long an invite link can be accepted for.
+const InvitationLifetime = 7 * 24 * time.Hour
+
+// Invitation asks someone to create an account in an organization, with a role
+// there if Role is set. Only the SHA-256 hash of the token in the invite link is
+// stored.
+type Invitation struct {
+	ID               int       `json:"id"`
+	OrganizationID   int       `json:"organization_id"`
+	OrganizationName string    `json:"organization_name"`
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	InvitedBy        int       `json:"invited_by"`
+	TokenHash        string    `json:"-"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	CreatedAt        time.Time `json:"-"`
+}
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
+)
+
+// OAuthClient is an application allowed to sign its users in through us.
+type OAuthClient struct {
+	ID           int       `json:"id"`
+	ClientID     string    `json:"client_id"`
+	SecretHash   string    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"-"`
+}
+
+// HashClientSecret returns the form a client secret is stored in. Secrets are
+// random, so a plain SHA-256 is enough.
+func HashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsPublic reports whether the client has no secret, like a mobile or browser app.
+// Public clients have to prove themselves with PKCE alone.
+func (c *OAuthClient) IsPublic() bool {
+	return c.SecretHash == ""
+}
+
+// SecretMatches compares a client supplied secret with the stored hash.
+func (c *OAuthClient) SecretMatches(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(c.SecretHash), []byte(HashClientSecret(secret))) == 1
+}
+
+// AllowsRedirect reports whether uri is one of the client's registered redirect URIs.
+// Only exact matches count.
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if allowed == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationCode is an issued, not yet redeemed, OAuth2 authorization code.
+type AuthorizationCode struct {
+	CodeHash      string    `json:"-"`
+	ClientID      string    `json:"client_id"`
+	UserID        int       `json:"user_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scope         string    `json:"scope"`
+	Nonce         string    `json:"nonce"`
+	CodeChallenge string    `json:"-"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	// OrganizationID is the organization the user was working in when they agreed,
+	// which the roles in the tokens are for. 0 means none.
+	OrganizationID int `json:"organization_id"`
+}
@@ -0,0 +1,12 @@
+package data
+
+import "time"
+
+// Organization is a tenant. Users only see the users of organizations they are a
+// member of, and their roles are granted per organization.
+type Organization struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"-"`
+}
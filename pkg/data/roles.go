@@ -0,0 +1,22 @@
+package data
+
+import "time"
+
+// The roles and permissions the schema is seeded with.
+const (
+	RoleAdmin  = "admin"
+	RoleViewer = "viewer"
+
+	PermissionUsersRead  = "users:read"
+	PermissionUsersWrite = "users:write"
+	PermissionRolesWrite = "roles:write"
+)
+
+// Role is a named set of permissions that can be assigned to users.
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"-"`
+}
@@ -0,0 +1,20 @@
+package data
+
+import "time"
+
+// Token purposes.
+const (
+	TokenMagicLink  = "magic_link"
+	TokenDataExport = "data_export"
+)
+
+// UserToken is a single-use token mailed to a user. Only the SHA-256 hash of the
+// token is stored.
+type UserToken struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	TokenHash string    `json:"-"`
+	Purpose   string    `json:"purpose"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"-"`
+}
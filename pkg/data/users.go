@@ -1,9 +1,16 @@
 package data
 
 import (
-	"errors"
-	"golang.org/x/crypto/bcrypt"
 	"time"
+	"webapp/pkg/passwords"
+)
+
+// The states of a user account. Suspended and deleted users can't log in. Deleted
+// users can be restored until they are purged.
+const (
+	UserActive    = "active"
+	UserSuspended = "suspended"
+	UserDeleted   = "deleted"
 )
 
 // User describes the data for the User type.
@@ -13,26 +20,31 @@ type User struct {
 	LastName   string    `json:"last_name"`
 	Email      string    `json:"email"`
 	Password   string    `json:"-"`
-	IsAdmin    int       `json:"is_admin"`
 	CreatedAt  time.Time `json:"-"`
 	UpdatedAt  time.Time `json:"-"`
 	ProfilePic UserImage `json:"-"`
+	// TOTPEnabled is set once the user confirmed an authenticator. Its secret isn't
+	// part of User, which is kept in the session; see GetTOTPSecret.
+	TOTPEnabled bool   `json:"totp_enabled"`
+	Status      string `json:"status"`
+	// DeletedAt is when the user was deleted, nil unless Status is UserDeleted.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
-// PasswordMatches uses Go's bcrypt package to compare a user supplied password
-// with the hash we have stored for a given user in the database. If the password
-// and hash match, we return true; otherwise, we return false.
-func (u *User) PasswordMatches(plainText string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plainText))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			// invalid password
-			return false, nil
-		default:
-			return false, err
-		}
-	}
+// ImportedUser is a user to create in a bulk import, with the role they get in the
+// organization the import runs in.
+type ImportedUser struct {
+	User
+	Role string
+}
 
-	return true, nil
+// IsActive reports whether the user may log in.
+func (u *User) IsActive() bool {
+	return u.Status != UserSuspended && u.Status != UserDeleted
+}
+
+// PasswordMatches compares a user supplied password with the hash we have stored
+// for the user, whichever supported algorithm made it.
+func (u *User) PasswordMatches(plainText string) (bool, error) {
+	return passwords.Verify(plainText, u.Password)
 }
@@ -0,0 +1,197 @@
+package forms
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Bind validates form values by the tags of the struct dst points to, and copies
+// them into its fields:
+//
+//	type signup struct {
+//		Email    string `form:"email" validate:"required,email,max=255"`
+//		Password string `form:"password" validate:"required,min=8"`
+//		Confirm  string `form:"confirm_password" validate:"required,eq=password"`
+//		Plan     string `form:"plan" validate:"in=free|team"`
+//		Seats    int    `form:"seats" validate:"range=1:500"`
+//	}
+//
+// The rules are required, email, min and max length, eq another field, in a list of
+// values separated by |, and range of numbers separated by :. Fields without a form
+// tag are left alone; the others can be strings, bools, numbers or string slices,
+// and a value that isn't of the field's type is an error too. The returned form
+// holds the errors by field name. Bind panics when dst isn't a pointer to a struct or
+// a tag is malformed, as those are bugs.
+func Bind(data url.Values, dst any) *Form {
+	f := New(data)
+	f.Bind(dst)
+	return f
+}
+
+// Bind is the Bind function for a form that was already made, to use its Translate.
+func (f *Form) Bind(dst any) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("forms: Bind needs a pointer to a struct, not %T", dst))
+	}
+	v = v.Elem()
+
+	if f.Data == nil {
+		f.Data = url.Values{}
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := field.Tag.Get("form")
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+
+		if rules := field.Tag.Get("validate"); rules != "" {
+			f.validate(name, rules)
+		}
+		if len(f.Errors[name]) == 0 && f.Has(name) {
+			if msg := setField(v.Field(i), f.Data[name]); msg != "" {
+				f.Errors.Add(name, f.message(msg))
+			}
+		}
+	}
+}
+
+// BindJSON is Bind for a JSON object, keyed by the form tags. The error is only
+// about malformed JSON; invalid values are in the form.
+func BindJSON(r io.Reader, dst any) (*Form, error) {
+	data, err := DecodeJSON(r)
+	if err != nil {
+		return nil, err
+	}
+	return Bind(data, dst), nil
+}
+
+// DecodeJSON reads a JSON object as form values. Numbers and booleans are taken as
+// their text, arrays as repeated values.
+func DecodeJSON(r io.Reader) (url.Values, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	for key, value := range object {
+		values := []any{value}
+		if list, ok := value.([]any); ok {
+			values = list
+		}
+		for _, value := range values {
+			switch value := value.(type) {
+			case nil:
+			case string:
+				data.Add(key, value)
+			case json.Number:
+				data.Add(key, value.String())
+			case bool:
+				data.Add(key, strconv.FormatBool(value))
+			default:
+				return nil, fmt.Errorf("%s: expected a string, number or boolean", key)
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// validate applies the rules of a validate tag to a field.
+func (f *Form) validate(field, rules string) {
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "required":
+			f.Required(field)
+		case "email":
+			f.Email(field)
+		case "min":
+			f.MinLength(field, atoi(field, arg))
+		case "max":
+			f.MaxLength(field, atoi(field, arg))
+		case "eq":
+			f.Equal(field, arg)
+		case "in":
+			f.In(field, strings.Split(arg, "|")...)
+		case "range":
+			lo, hi, _ := strings.Cut(arg, ":")
+			f.Range(field, atof(field, lo), atof(field, hi))
+		default:
+			panic(fmt.Sprintf("forms: unknown rule %q on %s", rule, field))
+		}
+	}
+}
+
+func atoi(field, s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		panic(fmt.Sprintf("forms: bad number %q in the rules of %s", s, field))
+	}
+	return n
+}
+
+func atof(field, s string) float64 {
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		panic(fmt.Sprintf("forms: bad number %q in the rules of %s", s, field))
+	}
+	return n
+}
+
+// setField parses values into a struct field, and returns what's wrong with them
+// when they don't fit.
+func setField(v reflect.Value, values []string) string {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String {
+		v.Set(reflect.ValueOf(values).Convert(v.Type()))
+		return ""
+	}
+
+	value := strings.TrimSpace(values[0])
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(values[0])
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if value == "on" {
+			// what a checked checkbox without a value sends
+			b, err = true, nil
+		}
+		if err != nil {
+			return "This field must be true or false"
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return "This field must be a whole number"
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return "This field must be a positive whole number"
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return "This field must be a number"
+		}
+		v.SetFloat(n)
+	default:
+		panic(fmt.Sprintf("forms: can't bind to a field of type %s", v.Type()))
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package forms
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type signup struct {
+	Email    string   `form:"email" validate:"required,email,max=255"`
+	Password string   `form:"password" validate:"required,min=8"`
+	Confirm  string   `form:"confirm_password" validate:"required,eq=password"`
+	Plan     string   `form:"plan" validate:"in=free|team"`
+	Seats    int      `form:"seats" validate:"range=1:500"`
+	Terms    bool     `form:"terms"`
+	Tags     []string `form:"tag"`
+	Ignored  string
+}
+
+func TestBind(t *testing.T) {
+	var s signup
+	form := Bind(url.Values{
+		"email":            {"jane@example.com"},
+		"password":         {"correct horse"},
+		"confirm_password": {"correct horse"},
+		"plan":             {"team"},
+		"seats":            {"12"},
+		"terms":            {"on"},
+		"tag":              {"a", "b"},
+		"Ignored":          {"x"},
+	}, &s)
+
+	if !form.Valid() {
+		t.Fatalf("expected the form to be valid, but got %v", form.Errors)
+	}
+	expected := signup{Email: "jane@example.com", Password: "correct horse", Confirm: "correct horse", Plan: "team", Seats: 12, Terms: true, Tags: []string{"a", "b"}}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("expected %+v, but got %+v", expected, s)
+	}
+
+	s = signup{}
+	form = Bind(url.Values{"email": {"jane"}, "password": {"short"}, "confirm_password": {"other"}, "plan": {"gold"}, "seats": {"many"}, "terms": {"maybe"}}, &s)
+	for _, field := range []string{"email", "password", "confirm_password", "plan", "seats", "terms"} {
+		if form.Errors.Get(field) == "" {
+			t.Errorf("expected an error on %s, but got %v", field, form.Errors)
+		}
+	}
+	if s.Email != "" || s.Seats != 0 {
+		t.Errorf("expected invalid values not to be bound, but got %+v", s)
+	}
+}
+
+func TestBindJSON(t *testing.T) {
+	var s signup
+	form, err := BindJSON(strings.NewReader(`{"email":"jane@example.com","password":"correct horse","confirm_password":"correct horse","seats":3,"terms":true,"tag":["a"]}`), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !form.Valid() || s.Seats != 3 || !s.Terms || len(s.Tags) != 1 {
+		t.Errorf("unexpected result %+v, errors %v", s, form.Errors)
+	}
+
+	form, _ = BindJSON(strings.NewReader(`{"email":"jane@example.com","seats":1.5}`), &s)
+	if form.Errors.Get("password") == "" || form.Errors.Get("seats") == "" {
+		t.Errorf("expected errors on password and seats, but got %v", form.Errors)
+	}
+
+	if _, err = BindJSON(strings.NewReader(`{"email":{"a":1}}`), &s); err == nil {
+		t.Error("expected an object value to be refused")
+	}
+	if _, err = BindJSON(strings.NewReader(`not json`), &s); err == nil {
+		t.Error("expected malformed JSON to be refused")
+	}
+}
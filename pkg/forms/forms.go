@@ -1,6 +1,7 @@
-package main
+package forms
 
 import (
+	"fmt"
 	"net/url"
 	"strings"
 )
@@ -10,6 +11,9 @@ type errors map[string][]string
 type Form struct {
 	Data   url.Values
 	Errors errors
+	// Translate turns the messages of errors into the user's language, formatting
+	// them with their arguments; they stay English when it's nil.
+	Translate func(message string, args ...any) string
 }
 
 func (e errors) Get(field string) string {
@@ -24,7 +28,7 @@ func (e errors) Add(field string, msg string) {
 	e[field] = append(e[field], msg)
 }
 
-func NewForm(data url.Values) *Form {
+func New(data url.Values) *Form {
 	return &Form{
 		Data:   data,
 		Errors: map[string][]string{},
@@ -43,7 +47,7 @@ func (f *Form) Required(fields ...string) {
 	for _, field := range fields {
 		value := f.Data.Get(field)
 		if strings.TrimSpace(value) == "" {
-			f.Errors.Add(field, "This field cannot be blank")
+			f.Errors.Add(field, f.message("This field cannot be blank"))
 		}
 	}
 }
@@ -58,3 +62,14 @@ func (f *Form) Valid() bool {
 	return len(f.Errors) == 0
 
 }
+
+// message translates a message of a built-in check.
+func (f *Form) message(msg string, args ...any) string {
+	if f.Translate != nil {
+		return f.Translate(msg, args...)
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
@@ -1,4 +1,4 @@
-package main
+package forms
 
 import (
 	"net/http"
@@ -8,7 +8,7 @@ import (
 )
 
 func TestForm_Has(t *testing.T) {
-	form := NewForm(nil)
+	form := New(nil)
 	has := form.Has("whatever")
 	if has {
 		t.Error("the form contains the data it should not have")
@@ -16,7 +16,7 @@ func TestForm_Has(t *testing.T) {
 
 	postedData := url.Values{}
 	postedData.Add("a", "b")
-	form = NewForm(postedData)
+	form = New(postedData)
 
 	has = form.Has("a")
 	if !has {
@@ -26,7 +26,7 @@ func TestForm_Has(t *testing.T) {
 
 func TestForm_Required(t *testing.T) {
 	r := httptest.NewRequest("POST", "/whatever", nil)
-	form := NewForm(r.PostForm)
+	form := New(r.PostForm)
 
 	form.Required("a", "b", "c")
 
@@ -42,7 +42,7 @@ func TestForm_Required(t *testing.T) {
 	r, _ = http.NewRequest("POST", "/whatever", nil)
 	r.PostForm = postedData
 
-	form = NewForm(r.PostForm)
+	form = New(r.PostForm)
 	form.Required("a", "b", "c")
 	if !form.Valid() {
 		t.Error("form expected to be valid but its not")
@@ -50,7 +50,7 @@ func TestForm_Required(t *testing.T) {
 }
 
 func TestForm_Check(t *testing.T) {
-	form := NewForm(nil)
+	form := New(nil)
 	form.Check(false, "password", "password is required")
 	if form.Valid() {
 		t.Error("Valid() returns true and it should be true when calling Check()")
@@ -58,7 +58,7 @@ func TestForm_Check(t *testing.T) {
 }
 
 func TestForm_ErrorGet(t *testing.T) {
-	form := NewForm(nil)
+	form := New(nil)
 	form.Check(false, "password", "password is required")
 	s := form.Errors.Get("password")
 	if len(s) == 0 {
@@ -73,7 +73,7 @@ func TestForm_ErrorGet(t *testing.T) {
 
 func TestForm_Valid(t *testing.T) {
 	formData := url.Values{}
-	form := NewForm(formData)
+	form := New(formData)
 	if !form.Valid() {
 		t.Error("expected form to be valid")
 	}
@@ -0,0 +1,96 @@
+package forms
+
+import (
+	"net/mail"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The validators below leave empty fields alone, so optional fields can be checked
+// too; use Required for the fields that must be filled in.
+
+// Email checks that fields hold a single email address, without a display name.
+func (f *Form) Email(fields ...string) {
+	for _, field := range fields {
+		value := strings.TrimSpace(f.Data.Get(field))
+		if value == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(value)
+		f.Check(err == nil && addr.Address == value, field, f.message("This is not a valid email address"))
+	}
+}
+
+// MinLength checks that a field has at least n characters.
+func (f *Form) MinLength(field string, n int) {
+	value := f.Data.Get(field)
+	if value != "" {
+		f.Check(utf8.RuneCountInString(value) >= n, field, f.message("This field must be at least %d characters long", n))
+	}
+}
+
+// MaxLength checks that a field has at most n characters.
+func (f *Form) MaxLength(field string, n int) {
+	value := f.Data.Get(field)
+	if value != "" {
+		f.Check(utf8.RuneCountInString(value) <= n, field, f.message("This field must be at most %d characters long", n))
+	}
+}
+
+// Equal checks that a field has the same value as another one, like a password and
+// its confirmation.
+func (f *Form) Equal(field, other string) {
+	value := f.Data.Get(field)
+	if value != "" {
+		f.Check(value == f.Data.Get(other), field, f.message("This field must match the %s field", f.message(label(other))))
+	}
+}
+
+// Matches checks a field against a regular expression. message tells the user what
+// the value should look like, in their language.
+func (f *Form) Matches(field string, re *regexp.Regexp, message string) {
+	value := f.Data.Get(field)
+	if value != "" {
+		f.Check(re.MatchString(value), field, message)
+	}
+}
+
+// Range checks that a field is a number from min to max.
+func (f *Form) Range(field string, min, max float64) {
+	value := strings.TrimSpace(f.Data.Get(field))
+	if value == "" {
+		return
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	f.Check(err == nil && n >= min && n <= max, field, f.message("This field must be a number from %g to %g", min, max))
+}
+
+// In checks that a field has one of the allowed values.
+func (f *Form) In(field string, allowed ...string) {
+	value := f.Data.Get(field)
+	if value != "" {
+		f.Check(slices.Contains(allowed, value), field, f.message("This field must be one of %s", strings.Join(allowed, ", ")))
+	}
+}
+
+// Unique checks that no record has a field's value yet. exists looks the value up,
+// usually in the repository:
+//
+//	form.Unique("email", func(email string) bool {
+//		_, err := app.DB.GetUserByEmail(email)
+//		return err == nil
+//	})
+func (f *Form) Unique(field string, exists func(value string) bool) {
+	value := f.Data.Get(field)
+	if value != "" {
+		f.Check(!exists(value), field, f.message("This value is already taken"))
+	}
+}
+
+// label turns a field name like confirm_password into "confirm password".
+func label(field string) string {
+	return strings.ReplaceAll(field, "_", " ")
+}
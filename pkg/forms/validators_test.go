@@ -0,0 +1,59 @@
+package forms
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func TestForm_validators(t *testing.T) {
+	data := url.Values{
+		"email":            {"jane@example.com"},
+		"named_email":      {"Jane <jane@example.com>"},
+		"password":         {"correct horse"},
+		"confirm_password": {"correct horse"},
+		"other_password":   {"correct"},
+		"code":             {"12ab"},
+		"age":              {"42"},
+		"plan":             {"team"},
+		"empty":            {""},
+	}
+
+	var tests = []struct {
+		name     string
+		validate func(f *Form)
+		field    string
+		valid    bool
+	}{
+		{name: "email", validate: func(f *Form) { f.Email("email") }, field: "email", valid: true},
+		{name: "email with a name", validate: func(f *Form) { f.Email("named_email") }, field: "named_email"},
+		{name: "email of a password", validate: func(f *Form) { f.Email("password") }, field: "password"},
+		{name: "min length", validate: func(f *Form) { f.MinLength("password", 13) }, field: "password", valid: true},
+		{name: "too short", validate: func(f *Form) { f.MinLength("password", 14) }, field: "password"},
+		{name: "max length", validate: func(f *Form) { f.MaxLength("password", 13) }, field: "password", valid: true},
+		{name: "too long", validate: func(f *Form) { f.MaxLength("password", 12) }, field: "password"},
+		{name: "equal", validate: func(f *Form) { f.Equal("confirm_password", "password") }, field: "confirm_password", valid: true},
+		{name: "not equal", validate: func(f *Form) { f.Equal("other_password", "password") }, field: "other_password"},
+		{name: "matches", validate: func(f *Form) { f.Matches("code", regexp.MustCompile(`^[0-9a-z]+$`), "letters and digits") }, field: "code", valid: true},
+		{name: "doesn't match", validate: func(f *Form) { f.Matches("code", regexp.MustCompile(`^[0-9]+$`), "digits") }, field: "code"},
+		{name: "range", validate: func(f *Form) { f.Range("age", 18, 130) }, field: "age", valid: true},
+		{name: "out of range", validate: func(f *Form) { f.Range("age", 0, 10) }, field: "age"},
+		{name: "not a number", validate: func(f *Form) { f.Range("code", 0, 100) }, field: "code"},
+		{name: "in", validate: func(f *Form) { f.In("plan", "free", "team") }, field: "plan", valid: true},
+		{name: "not in", validate: func(f *Form) { f.In("plan", "free") }, field: "plan"},
+		{name: "unique", validate: func(f *Form) { f.Unique("email", func(string) bool { return false }) }, field: "email", valid: true},
+		{name: "taken", validate: func(f *Form) { f.Unique("email", func(v string) bool { return v == "jane@example.com" }) }, field: "email"},
+		{name: "empty is left to Required", validate: func(f *Form) { f.Email("empty"); f.MinLength("empty", 1); f.Range("empty", 1, 2) }, field: "empty", valid: true},
+	}
+
+	for _, e := range tests {
+		form := New(data)
+		e.validate(form)
+		if form.Valid() != e.valid {
+			t.Errorf("%s: expected valid %v, but got errors %v", e.name, e.valid, form.Errors)
+		}
+		if !e.valid && form.Errors.Get(e.field) == "" {
+			t.Errorf("%s: expected an error on %s, but got %v", e.name, e.field, form.Errors)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Package i18n translates the messages and page text of the app. Catalogs are JSON
+// files in locales, one per locale, embedded in the binary. They map English
+// messages, which may have fmt verbs, to their translations; en.json lists every
+// message, so it is the reference the others are checked against. A message missing
+// from a catalog shows in English.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is the locale of the message keys, used when nothing else fits.
+const DefaultLocale = "en"
+
+//go:embed locales/*.json
+var files embed.FS
+
+// Messages are the embedded catalogs.
+var Messages = mustLoad(files)
+
+// Catalog holds the messages of every locale.
+type Catalog struct {
+	messages map[string]map[string]string
+}
+
+// Load reads the catalogs in the locales directory of fsys, named after their locale.
+func Load(fsys fs.FS) (*Catalog, error) {
+	names, err := fs.Glob(fsys, "locales/*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{messages: map[string]map[string]string{}}
+	for _, name := range names {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]string
+		if err = json.Unmarshal(b, &messages); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		c.messages[strings.TrimSuffix(path.Base(name), ".json")] = messages
+	}
+
+	if _, ok := c.messages[DefaultLocale]; !ok {
+		return nil, fmt.Errorf("there is no %s catalog", DefaultLocale)
+	}
+	return c, nil
+}
+
+func mustLoad(fsys fs.FS) *Catalog {
+	c, err := Load(fsys)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Locales returns the locales there are catalogs for, sorted.
+func (c *Catalog) Locales() []string {
+	locales := make([]string, 0, len(c.messages))
+	for locale := range c.messages {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Supports reports whether there is a catalog for a locale.
+func (c *Catalog) Supports(locale string) bool {
+	_, ok := c.messages[locale]
+	return ok
+}
+
+// Printer translates into one locale.
+type Printer struct {
+	Locale   string
+	messages map[string]string
+}
+
+// Printer returns the printer of a locale, or of the default locale when there is
+// no catalog for it.
+func (c *Catalog) Printer(locale string) *Printer {
+	if !c.Supports(locale) {
+		locale = DefaultLocale
+	}
+	return &Printer{Locale: locale, messages: c.messages[locale]}
+}
+
+// T translates a message and formats it with args, like fmt.Sprintf.
+func (p *Printer) T(key string, args ...any) string {
+	msg, ok := p.messages[key]
+	if !ok || msg == "" {
+		msg = key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// Negotiate picks the supported locale an Accept-Language header prefers. A
+// language matches its regional variants both ways, so en-GB gets en.
+func (c *Catalog) Negotiate(acceptLanguage string) string {
+	type tag struct {
+		name string
+		q    float64
+	}
+
+	var tags []tag
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if name != "" && q > 0 {
+			tags = append(tags, tag{strings.ToLower(name), q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		if c.Supports(t.name) {
+			return t.name
+		}
+		base, _, _ := strings.Cut(t.name, "-")
+		for _, locale := range c.Locales() {
+			if l, _, _ := strings.Cut(locale, "-"); l == base {
+				return locale
+			}
+		}
+	}
+	return DefaultLocale
+}
+
+// Missing returns, by locale, the keys of the default catalog other catalogs lack,
+// and the keys given that the default catalog lacks.
+func (c *Catalog) Missing(keys ...string) map[string][]string {
+	missing := map[string][]string{}
+	reference := c.messages[DefaultLocale]
+
+	for _, key := range keys {
+		if _, ok := reference[key]; !ok && !slices.Contains(missing[DefaultLocale], key) {
+			missing[DefaultLocale] = append(missing[DefaultLocale], key)
+		}
+	}
+
+	for locale, messages := range c.messages {
+		if locale == DefaultLocale {
+			continue
+		}
+		for key := range reference {
+			if msg, ok := messages[key]; !ok || msg == "" {
+				missing[locale] = append(missing[locale], key)
+			}
+		}
+	}
+
+	for locale := range missing {
+		sort.Strings(missing[locale])
+	}
+	return missing
+}
+
+// templateKey matches the literal messages of T calls in templates, and the labels
+// handed to the field partial, which translates them.
+var templateKey = regexp.MustCompile(`(?:\bT|"Label")\s+("(?:[^"\\]|\\.)*"|` + "`[^`]*`)")
+
+// TemplateKeys returns the messages the templates matching patterns translate with
+// T, so they can be checked with Missing.
+func TemplateKeys(fsys fs.FS, patterns ...string) ([]string, error) {
+	var names []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, matches...)
+	}
+
+	var keys []string
+	for _, name := range names {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range templateKey.FindAllStringSubmatch(string(b), -1) {
+			key, err := strconv.Unquote(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
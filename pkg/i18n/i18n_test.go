@@ -0,0 +1,77 @@
+package i18n
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestCatalog_Negotiate(t *testing.T) {
+	var tests = []struct {
+		header   string
+		expected string
+	}{
+		{header: "", expected: "en"},
+		{header: "de", expected: "de"},
+		{header: "de-AT,de;q=0.9,en;q=0.8", expected: "de"},
+		{header: "fr-FR,fr;q=0.9,de;q=0.5", expected: "de"},
+		{header: "en-GB,de;q=0.9", expected: "en"},
+		{header: "de;q=0.2,en;q=0.8", expected: "en"},
+		{header: "de;q=0", expected: "en"},
+		{header: "*", expected: "en"},
+	}
+
+	for _, e := range tests {
+		if got := Messages.Negotiate(e.header); got != e.expected {
+			t.Errorf("%q: expected %s, but got %s", e.header, e.expected, got)
+		}
+	}
+}
+
+func TestPrinter_T(t *testing.T) {
+	de := Messages.Printer("de")
+	if got := de.T("Use at least %d characters", 8); got != "Verwenden Sie mindestens 8 Zeichen" {
+		t.Errorf("unexpected translation %q", got)
+	}
+	if got := de.T("not in any catalog"); got != "not in any catalog" {
+		t.Errorf("expected an unknown message as is, but got %q", got)
+	}
+	if p := Messages.Printer("fr"); p.Locale != DefaultLocale {
+		t.Errorf("expected an unsupported locale to fall back to %s, but got %s", DefaultLocale, p.Locale)
+	}
+}
+
+func TestCatalog_Missing(t *testing.T) {
+	if missing := Messages.Missing(); len(missing) != 0 {
+		t.Errorf("expected the embedded catalogs to be complete, but got %v", missing)
+	}
+
+	c, err := Load(fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{"Hello": "Hello", "Bye": "Bye"}`)},
+		"locales/de.json": {Data: []byte(`{"Hello": "Hallo", "Bye": ""}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := c.Missing("Hello", "Welcome")
+	if len(missing["en"]) != 1 || missing["en"][0] != "Welcome" || len(missing["de"]) != 1 || missing["de"][0] != "Bye" {
+		t.Errorf("unexpected missing messages %v", missing)
+	}
+
+	if _, err = Load(fstest.MapFS{"locales/de.json": {Data: []byte(`{}`)}}); err == nil {
+		t.Error("expected catalogs without English to be refused")
+	}
+}
+
+func TestTemplateKeys(t *testing.T) {
+	fsys := fstest.MapFS{
+		"html/a.gohtml":          {Data: []byte(`<h1>{{T "Join %s" .Name}}</h1><p>{{T "Say \"hi\""}}</p>{{Title}}`)},
+		"html/partials/b.gohtml": {Data: []byte(`{{define "b"}}{{T "Save"}}{{template "field" dict "Name" "email" "Label" "Email"}}{{end}}`)},
+	}
+	keys, err := TemplateKeys(fsys, "html/*.gohtml", "html/partials/*.gohtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 4 || keys[0] != "Join %s" || keys[1] != `Say "hi"` || keys[2] != "Save" || keys[3] != "Email" {
+		t.Errorf("unexpected keys %q", keys)
+	}
+}
@@ -0,0 +1,127 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// prefixLength is how many hex characters of a hash select its bucket file, as in the
+// Pwned Passwords range API.
+const prefixLength = 5
+
+// BreachedList is an on-disk index of breached passwords. It is laid out like the
+// Pwned Passwords range API, for k-anonymity: the uppercase hex SHA-1 of a password
+// is split after five characters, and the file named after the prefix lists the
+// rest of the hashes with that prefix as "SUFFIX:COUNT" lines. A lookup only reads
+// one small file and works offline.
+type BreachedList struct {
+	Dir string
+}
+
+// file returns the bucket file of a prefix. Buckets are spread over directories named
+// after their first two characters, so no directory gets too big.
+func (b *BreachedList) file(prefix string) string {
+	return filepath.Join(b.Dir, prefix[:2], prefix)
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// Contains reports whether a password is in the list.
+func (b *BreachedList) Contains(password string) (bool, error) {
+	hash := sha1Hex(password)
+
+	f, err := os.Open(b.file(hash[:prefixLength]))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	suffix := hash[prefixLength:]
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// BuildBreachedList writes the index of a list of breached passwords to dir, and
+// returns how many hashes it holds. The list has a hash or password per line:
+// "HASH:COUNT" or "HASH" lines with hex SHA-1 hashes, as Pwned Passwords publishes
+// them, or plain passwords. The list is held in memory while the index is written.
+func BuildBreachedList(dir string, r io.Reader) (*BreachedList, int, error) {
+	buckets := map[string]map[string]string{}
+	count := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		hash, n, _ := strings.Cut(line, ":")
+		if !isSHA1Hex(hash) {
+			hash, n = sha1Hex(line), ""
+		}
+		if n == "" {
+			n = "1"
+		}
+
+		hash = strings.ToUpper(hash)
+		prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+		if buckets[prefix] == nil {
+			buckets[prefix] = map[string]string{}
+		}
+		if _, ok := buckets[prefix][suffix]; !ok {
+			count++
+		}
+		buckets[prefix][suffix] = n
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	b := &BreachedList{Dir: dir}
+	for prefix, suffixes := range buckets {
+		if err := os.MkdirAll(filepath.Dir(b.file(prefix)), 0755); err != nil {
+			return nil, 0, err
+		}
+
+		lines := make([]string, 0, len(suffixes))
+		for suffix, n := range suffixes {
+			lines = append(lines, suffix+":"+n)
+		}
+		sort.Strings(lines)
+
+		if err := os.WriteFile(b.file(prefix), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			return nil, 0, fmt.Errorf("writing bucket %s: %w", prefix, err)
+		}
+	}
+
+	return b, count, nil
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
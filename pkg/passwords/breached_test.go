@@ -0,0 +1,36 @@
+package passwords
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildBreachedList(t *testing.T) {
+	// "password" as a Pwned Passwords line, "123456" in lowercase hex, and a plain password
+	list := "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:10434004\n" +
+		"7c4a8d09ca3762af61e59520943dc26494f8941b\n" +
+		"letmein\n\n" +
+		"letmein\n"
+
+	dir := t.TempDir()
+	b, count, err := BuildBreachedList(dir, strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 hashes, but got %d", count)
+	}
+
+	bucket, err := os.ReadFile(filepath.Join(dir, "5B", "5BAA6"))
+	if err != nil || string(bucket) != "1E4C9B93F3F0682250B6CF8331B7EE68FD8:10434004\n" {
+		t.Errorf("unexpected bucket %q (%v)", bucket, err)
+	}
+
+	for password, expected := range map[string]bool{"password": true, "123456": true, "letmein": true, "kT9#mQ2!x": false} {
+		if got, err := b.Contains(password); err != nil || got != expected {
+			t.Errorf("%s: expected %v, but got %v (%v)", password, expected, got, err)
+		}
+	}
+}
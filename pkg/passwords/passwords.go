@@ -0,0 +1,174 @@
+// Package passwords hashes passwords with argon2id or bcrypt and checks them against
+// stored hashes, which are argon2id PHC strings ($argon2id$v=19$m=...,t=...,p=...$salt$key)
+// or bcrypt hashes ($2a$12$...).
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// The algorithms new hashes can be made with.
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+const (
+	saltLength = 16
+	keyLength  = 32
+)
+
+// ErrUnknownHash is returned for a stored hash of a format we can't check.
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// ErrEmptyPassword is returned when hashing an empty password.
+var ErrEmptyPassword = errors.New("password is empty")
+
+var encoding = base64.RawStdEncoding
+
+// Policy decides how new hashes are made, and so which stored hashes are outdated.
+// The zero value is the default policy: argon2id with the parameters OWASP
+// recommends, or bcrypt cost 12 when bcrypt is chosen.
+type Policy struct {
+	Algorithm  string
+	BcryptCost int
+	// Argon2Memory is in KiB.
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.Algorithm == "" {
+		p.Algorithm = Argon2id
+	}
+	if p.BcryptCost == 0 {
+		p.BcryptCost = 12
+	}
+	if p.Argon2Memory == 0 {
+		p.Argon2Memory = 19 * 1024
+	}
+	if p.Argon2Iterations == 0 {
+		p.Argon2Iterations = 2
+	}
+	if p.Argon2Parallelism == 0 {
+		p.Argon2Parallelism = 1
+	}
+	return p
+}
+
+// Validate reports a policy that can't make hashes.
+func (p Policy) Validate() error {
+	p = p.withDefaults()
+	switch {
+	case p.Algorithm != Argon2id && p.Algorithm != Bcrypt:
+		return fmt.Errorf("unknown password hash algorithm %q", p.Algorithm)
+	case p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost:
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return nil
+}
+
+// Hash hashes a password by the policy.
+func (p Policy) Hash(password string) (string, error) {
+	if password == "" {
+		return "", ErrEmptyPassword
+	}
+	p = p.withDefaults()
+
+	if p.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Argon2Iterations, p.Argon2Memory, p.Argon2Parallelism, keyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		p.Argon2Memory, p.Argon2Iterations, p.Argon2Parallelism,
+		encoding.EncodeToString(salt), encoding.EncodeToString(key)), nil
+}
+
+// NeedsRehash reports whether a stored hash was made with another algorithm or other
+// parameters than the policy's, so it should be replaced the next time we see the
+// password.
+func (p Policy) NeedsRehash(hash string) bool {
+	p = p.withDefaults()
+
+	if isBcrypt(hash) {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err == nil && (p.Algorithm != Bcrypt || cost != p.BcryptCost)
+	}
+
+	params, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return false
+	}
+	return p.Algorithm != Argon2id ||
+		params.Argon2Memory != p.Argon2Memory ||
+		params.Argon2Iterations != p.Argon2Iterations ||
+		params.Argon2Parallelism != p.Argon2Parallelism
+}
+
+// Verify reports whether a password matches a stored hash of any supported format.
+func Verify(password, hash string) (bool, error) {
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Argon2Iterations, params.Argon2Memory, params.Argon2Parallelism, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// decodeArgon2id parses an argon2id PHC string into its parameters, salt and key.
+func decodeArgon2id(hash string) (Policy, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != Argon2id {
+		return Policy{}, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Policy{}, nil, nil, ErrUnknownHash
+	}
+
+	p := Policy{Algorithm: Argon2id}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Argon2Memory, &p.Argon2Iterations, &p.Argon2Parallelism)
+	if err != nil || p.Argon2Iterations == 0 || p.Argon2Parallelism == 0 {
+		return Policy{}, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := encoding.DecodeString(parts[4])
+	if err != nil {
+		return Policy{}, nil, nil, ErrUnknownHash
+	}
+	key, err := encoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Policy{}, nil, nil, ErrUnknownHash
+	}
+
+	return p, salt, key, nil
+}
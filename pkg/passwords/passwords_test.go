@@ -0,0 +1,86 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+)
+
+// cheap keeps the tests fast; the parameters don't change what is tested.
+var cheap = Policy{BcryptCost: 4, Argon2Memory: 64, Argon2Iterations: 1}
+
+func TestPolicy_Hash(t *testing.T) {
+	for _, algorithm := range []string{Argon2id, Bcrypt} {
+		p := cheap
+		p.Algorithm = algorithm
+
+		hash, err := p.Hash("secret")
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+
+		if ok, err := Verify("secret", hash); err != nil || !ok {
+			t.Errorf("%s: expected the password to match %s (%v)", algorithm, hash, err)
+		}
+		if ok, _ := Verify("wrong", hash); ok {
+			t.Errorf("%s: expected a wrong password not to match", algorithm)
+		}
+		if p.NeedsRehash(hash) {
+			t.Errorf("%s: expected a fresh hash to be up to date", algorithm)
+		}
+		if _, err = p.Hash(""); err != ErrEmptyPassword {
+			t.Errorf("%s: expected an empty password to be refused, but got %v", algorithm, err)
+		}
+	}
+}
+
+func TestPolicy_NeedsRehash(t *testing.T) {
+	argon, _ := cheap.Hash("secret")
+	bcryptPolicy := cheap
+	bcryptPolicy.Algorithm = Bcrypt
+	bcryptHash, _ := bcryptPolicy.Hash("secret")
+
+	stronger := cheap
+	stronger.Argon2Iterations = 2
+	higherCost := bcryptPolicy
+	higherCost.BcryptCost = 5
+
+	var tests = []struct {
+		name     string
+		policy   Policy
+		hash     string
+		expected bool
+	}{
+		{name: "same argon2id parameters", policy: cheap, hash: argon},
+		{name: "more argon2id iterations", policy: stronger, hash: argon, expected: true},
+		{name: "bcrypt to argon2id", policy: cheap, hash: bcryptHash, expected: true},
+		{name: "argon2id to bcrypt", policy: bcryptPolicy, hash: argon, expected: true},
+		{name: "higher bcrypt cost", policy: higherCost, hash: bcryptHash, expected: true},
+		{name: "unknown format", policy: cheap, hash: "plain"},
+	}
+
+	for _, e := range tests {
+		if got := e.policy.NeedsRehash(e.hash); got != e.expected {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	// the seeded admin password, bcrypt cost 14
+	ok, err := Verify("secret", "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK")
+	if err != nil || !ok {
+		t.Errorf("expected the seeded bcrypt hash to match (%v)", err)
+	}
+
+	// from the reference implementation's PHC test vectors
+	ok, err = Verify("password", "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc")
+	if err != nil || !ok {
+		t.Errorf("expected the argon2id test vector to match (%v)", err)
+	}
+
+	for _, hash := range []string{"", "plain", "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5"} {
+		if _, err = Verify("secret", hash); err == nil || !strings.Contains(err.Error(), "unknown") {
+			t.Errorf("expected %q to be refused, but got %v", hash, err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package passwords
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// common are passwords and words people pick most often, lowercased.
+//
+//go:embed common.txt
+var commonList string
+
+var common = strings.Fields(commonList)
+
+// keyboardRows are the runs of neighbouring keys people type as passwords.
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+// Rules decide which new passwords are acceptable. The zero value only asks for the
+// lengths NIST recommends.
+type Rules struct {
+	// MinLength and MaxLength count characters; 8 and 128 when 0.
+	MinLength int
+	MaxLength int
+	// MinScore is the lowest Score accepted, from 0 to 4.
+	MinScore int
+	// History is how many of a user's latest passwords they can't use again.
+	History int
+	// Breached refuses the passwords in a list of breached passwords, when set.
+	Breached *BreachedList
+	// Translate turns the messages of Check into the user's language, formatting them
+	// with their arguments; they stay English when it's nil.
+	Translate func(message string, args ...any) string
+}
+
+func (r Rules) message(msg string, args ...any) string {
+	if r.Translate != nil {
+		return r.Translate(msg, args...)
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// Check returns why a new password is unacceptable, or nothing when it's fine.
+// userInputs are the user's own details, like their name and email address, which
+// make a password easy to guess.
+func (r Rules) Check(password string, userInputs ...string) ([]string, error) {
+	minLength, maxLength := r.MinLength, r.MaxLength
+	if minLength == 0 {
+		minLength = 8
+	}
+	if maxLength == 0 {
+		maxLength = 128
+	}
+
+	var problems []string
+	switch n := utf8.RuneCountInString(password); {
+	case n < minLength:
+		return append(problems, r.message("Use at least %d characters", minLength)), nil
+	case n > maxLength:
+		return append(problems, r.message("Use at most %d characters", maxLength)), nil
+	}
+
+	if Score(password, userInputs...) < r.MinScore {
+		problems = append(problems, r.message("This password is too easy to guess, add more words or characters"))
+	}
+
+	if r.Breached != nil {
+		breached, err := r.Breached.Contains(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			problems = append(problems, r.message("This password has appeared in a data breach, choose another one"))
+		}
+	}
+
+	return problems, nil
+}
+
+// Reused reports whether a password matches one of the hashes of earlier passwords.
+func Reused(password string, hashes []string) bool {
+	for _, hash := range hashes {
+		if ok, _ := Verify(password, hash); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Score estimates how hard a password is to guess, like zxcvbn does, from 0 (too
+// guessable) to 4 (very unguessable). Common passwords, the user's own details,
+// repeated characters, sequences and keyboard runs count for little.
+func Score(password string, userInputs ...string) int {
+	bits := guessBits(password, userInputs)
+	switch {
+	case bits < 10:
+		return 0
+	case bits < 20:
+		return 1
+	case bits < 27:
+		return 2
+	case bits < 33:
+		return 3
+	}
+	return 4
+}
+
+// guessBits is the log2 of the guesses an attacker trying dictionary words and
+// patterns before brute force needs for a password.
+func guessBits(password string, userInputs []string) float64 {
+	words := append([]string{}, common...)
+	for _, input := range userInputs {
+		for _, w := range strings.FieldsFunc(strings.ToLower(input), func(c rune) bool {
+			return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+		}) {
+			if len(w) >= 3 {
+				words = append(words, w)
+			}
+		}
+	}
+	wordBits := math.Log2(float64(len(words)))
+
+	runes := []rune(password)
+	lower := make([]rune, len(runes))
+	for i, c := range runes {
+		lower[i] = unicode.ToLower(c)
+	}
+
+	var bits float64
+	for i := 0; i < len(runes); {
+		if n := longestWord(lower[i:], words); n > 0 {
+			bits += wordBits + 1
+			i += n
+			continue
+		}
+		if isYear(lower[i:]) {
+			// birth years and the like: one of about two hundred
+			bits += math.Log2(200)
+			i += 4
+			continue
+		}
+		if n := patternLength(lower[i:]); n >= 3 {
+			bits += charBits(runes[i]) + math.Log2(float64(n))
+			i += n
+			continue
+		}
+		bits += charBits(runes[i])
+		i++
+	}
+
+	return bits
+}
+
+// longestWord returns the length of the longest word s starts with, or 0.
+func longestWord(s []rune, words []string) int {
+	longest := 0
+	for _, w := range words {
+		n := utf8.RuneCountInString(w)
+		if n > longest && n <= len(s) && string(s[:n]) == w {
+			longest = n
+		}
+	}
+	return longest
+}
+
+// isYear reports whether s starts with a year from 1900 to 2099.
+func isYear(s []rune) bool {
+	if len(s) < 4 || !(string(s[:2]) == "19" || string(s[:2]) == "20") {
+		return false
+	}
+	return unicode.IsDigit(s[2]) && unicode.IsDigit(s[3])
+}
+
+// patternLength returns how many characters s starts with that repeat one character,
+// count up or down by one, or follow a keyboard row.
+func patternLength(s []rune) int {
+	if len(s) < 2 {
+		return len(s)
+	}
+
+	n := 2
+	if d := s[1] - s[0]; d >= -1 && d <= 1 {
+		for n < len(s) && s[n]-s[n-1] == d {
+			n++
+		}
+		return n
+	}
+
+	if !adjacentKeys(s[0], s[1]) {
+		return 1
+	}
+	for n < len(s) && adjacentKeys(s[n-1], s[n]) {
+		n++
+	}
+	return n
+}
+
+func adjacentKeys(a, b rune) bool {
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, a)
+		if i >= 0 && i+1 < len(row) && rune(row[i+1]) == b {
+			return true
+		}
+	}
+	return false
+}
+
+// charBits is the log2 of the size of the character class c comes from.
+func charBits(c rune) float64 {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return math.Log2(26)
+	case c >= '0' && c <= '9':
+		return math.Log2(10)
+	case c < utf8.RuneSelf:
+		return math.Log2(33)
+	}
+	return math.Log2(100)
+}
@@ -0,0 +1,74 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	var tests = []struct {
+		password string
+		expected int
+	}{
+		{password: "password", expected: 0},
+		{password: "abcdefgh", expected: 0},
+		{password: "qwertyuiop", expected: 1},
+		{password: "Password1!", expected: 1},
+		{password: "jane1990", expected: 1},
+		{password: "verysecret", expected: 2},
+		{password: "kT9#mQ2!", expected: 4},
+		{password: "correcthorsebatterystaple", expected: 4},
+	}
+
+	for _, e := range tests {
+		if got := Score(e.password, "jane.doe@example.com", "Jane"); got != e.expected {
+			t.Errorf("%s: expected score %d, but got %d", e.password, e.expected, got)
+		}
+	}
+}
+
+func TestRules_Check(t *testing.T) {
+	dir := t.TempDir()
+	breached, _, err := BuildBreachedList(dir, strings.NewReader("correcthorsebatterystaple\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := Rules{MinScore: 2, Breached: breached}
+
+	var tests = []struct {
+		name     string
+		password string
+		expected string
+	}{
+		{name: "fine", password: "kT9#mQ2!x"},
+		{name: "too short", password: "kT9#", expected: "at least 8"},
+		{name: "too long", password: strings.Repeat("kT9#", 40), expected: "at most 128"},
+		{name: "guessable", password: "janedoe1", expected: "too easy"},
+		{name: "breached", password: "correcthorsebatterystaple", expected: "data breach"},
+	}
+
+	for _, e := range tests {
+		problems, err := rules.Check(e.password, "jane.doe@example.com")
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		if e.expected == "" && len(problems) != 0 {
+			t.Errorf("%s: expected no problems, but got %v", e.name, problems)
+		}
+		if e.expected != "" && (len(problems) != 1 || !strings.Contains(problems[0], e.expected)) {
+			t.Errorf("%s: expected %q, but got %v", e.name, e.expected, problems)
+		}
+	}
+}
+
+func TestReused(t *testing.T) {
+	old, _ := cheap.Hash("an old password")
+
+	if !Reused("an old password", []string{"not a hash", old}) {
+		t.Error("expected an earlier password to be found")
+	}
+	if Reused("a new password", []string{old}) {
+		t.Error("expected a new password not to be found")
+	}
+}
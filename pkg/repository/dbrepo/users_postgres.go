@@ -3,36 +3,293 @@ package dbrepo
 import (
 	"context"
 	"database/sql"
-	"golang.org/x/crypto/bcrypt"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/passwords"
+	"webapp/pkg/repository"
 )
 
 const dbTimeout = time.Second * 3
 
+// maxPasswordHistory is how many earlier password hashes are kept per user.
+const maxPasswordHistory = 24
+
 type PostgresDBRepo struct {
 	DB *sql.DB
+	// Passwords is the policy new password hashes are made by.
+	Passwords passwords.Policy
+	// organization limits the user queries to the members of one organization. Nil
+	// means system-wide access, as needed before anyone is logged in.
+	organization *int
+	// tx is the transaction of the unit of work the repository belongs to, see WithTx.
+	tx *sql.Tx
+	// savepoints numbers the savepoints of nested transactions in tx.
+	savepoints *int
+	// scope is the app.organization_id tx is known to be set to, so reads in a unit
+	// of work only set it when it changes. It is empty when it isn't known.
+	scope *string
 }
 
 func (m *PostgresDBRepo) Connection() *sql.DB {
 	return m.DB
 }
 
+// ForOrganization returns a repository whose user queries only see the members of
+// one organization, through their where clauses and through row-level security.
+// Within a unit of work it stays part of it.
+func (m *PostgresDBRepo) ForOrganization(id int) repository.DatabaseRepo {
+	return &PostgresDBRepo{DB: m.DB, Passwords: m.Passwords, organization: &id, tx: m.tx, savepoints: m.savepoints, scope: m.scope}
+}
+
+// querier runs statements, on the connection pool or in a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// conn returns the transaction of the current unit of work, or the pool outside one.
+func (m *PostgresDBRepo) conn() querier {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.DB
+}
+
+// txn is a transaction, or a savepoint when it's nested in a unit of work. Like
+// sql.Tx, rolling back after committing is a no-op, so it can be deferred.
+type txn struct {
+	querier
+	commit   func() error
+	rollback func() error
+}
+
+func (t *txn) Commit() error   { return t.commit() }
+func (t *txn) Rollback() error { return t.rollback() }
+
+// begin starts a transaction, or a savepoint within the current unit of work, so a
+// failing statement only undoes its own part.
+func (m *PostgresDBRepo) begin(ctx context.Context, opts *sql.TxOptions) (*txn, error) {
+	if m.tx == nil {
+		tx, err := m.DB.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &txn{querier: tx, commit: tx.Commit, rollback: tx.Rollback}, nil
+	}
+
+	*m.savepoints++
+	name := fmt.Sprintf("sp_%d", *m.savepoints)
+	if _, err := m.tx.ExecContext(ctx, "savepoint "+name); err != nil {
+		return nil, err
+	}
+
+	done := false
+	end := func(stmt string) func() error {
+		return func() error {
+			if done {
+				return nil
+			}
+			done = true
+			_, err := m.tx.ExecContext(ctx, stmt+name)
+			return err
+		}
+	}
+	rollback := end("rollback to savepoint ")
+	return &txn{querier: m.tx, commit: end("release savepoint "), rollback: func() error {
+		// undoing the savepoint undoes a scope set in it too
+		*m.scope = ""
+		return rollback()
+	}}, nil
+}
+
+// maxTxAttempts is how often a unit of work is tried when Postgres aborts it to keep
+// transactions serializable.
+const maxTxAttempts = 3
+
+// WithTx runs fn as a unit of work: whatever it does through the repository it is
+// passed is committed together, or not at all when fn returns an error. A nested
+// call runs in a savepoint of the outer unit. Units run serializable and are retried
+// on serialization failures and deadlocks, so fn must be safe to run again.
+func (m *PostgresDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return m.inTx(ctx, func(repo *PostgresDBRepo) error {
+		return fn(repo)
+	})
+}
+
+// inTx is WithTx for the repository's own multi-statement methods.
+func (m *PostgresDBRepo) inTx(ctx context.Context, fn func(repo *PostgresDBRepo) error) error {
+	if m.tx != nil {
+		t, err := m.begin(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer t.Rollback()
+
+		*m.scope = ""
+		if err = m.setScope(ctx, t); err != nil {
+			return err
+		}
+		if err = fn(m); err != nil {
+			return err
+		}
+		return t.Commit()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := m.runTx(ctx, fn)
+		if attempt == maxTxAttempts || !isRetryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt*attempt) * 10 * time.Millisecond):
+		}
+	}
+}
+
+func (m *PostgresDBRepo) runTx(ctx context.Context, fn func(repo *PostgresDBRepo) error) error {
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = m.setScope(ctx, tx); err != nil {
+		return err
+	}
+	scope := m.scopeName()
+	if err = fn(&PostgresDBRepo{DB: m.DB, Passwords: m.Passwords, organization: m.organization, tx: tx, savepoints: new(int), scope: &scope}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isRetryable reports whether Postgres aborted a transaction that may succeed when
+// run again: a serialization failure or a deadlock.
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+// systemScope is the app.organization_id of unscoped repositories, which the
+// row-level security policies let see every organization. A transaction that
+// doesn't set app.organization_id sees no users at all.
+const systemScope = "system"
+
+// scopeName is the app.organization_id of the repository: its organization, or
+// systemScope.
+func (m *PostgresDBRepo) scopeName() string {
+	if m.organization != nil {
+		return strconv.Itoa(*m.organization)
+	}
+	return systemScope
+}
+
+// setScope sets app.organization_id to the repository's scope for the rest of a
+// transaction.
+func (m *PostgresDBRepo) setScope(ctx context.Context, q querier) error {
+	_, err := q.ExecContext(ctx, `select set_config('app.organization_id', $1, true)`, m.scopeName())
+	return err
+}
+
+// unscope sets app.organization_id to systemScope for the rest of a transaction.
+// Inserting users needs it: the row-level security policies would refuse them while
+// they aren't members of the organization yet.
+func (m *PostgresDBRepo) unscope(ctx context.Context, q querier) error {
+	if m.tx != nil {
+		*m.scope = ""
+	}
+	_, err := q.ExecContext(ctx, `select set_config('app.organization_id', $1, true)`, systemScope)
+	return err
+}
+
+// tenantTx begins a transaction for the user queries and sets app.organization_id,
+// which the row-level security policies check. Within a unit of work it is set
+// again, so a scope set earlier in it doesn't leak into another repository.
+func (m *PostgresDBRepo) tenantTx(ctx context.Context) (*txn, error) {
+	tx, err := m.begin(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if m.tx != nil {
+		// the savepoint may be rolled back, and the scope with it
+		*m.scope = ""
+	}
+
+	if err = m.setScope(ctx, tx); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// readTx is tenantTx for statements that only read. Within a unit of work they run
+// in its transaction rather than a savepoint of their own, and app.organization_id
+// is only set when another repository of the unit changed it, so the reads of a
+// request cost one transaction instead of one each.
+func (m *PostgresDBRepo) readTx(ctx context.Context) (*txn, error) {
+	if m.tx == nil {
+		return m.tenantTx(ctx)
+	}
+
+	if scope := m.scopeName(); *m.scope != scope {
+		if err := m.setScope(ctx, m.tx); err != nil {
+			return nil, err
+		}
+		*m.scope = scope
+	}
+
+	done := func() error { return nil }
+	return &txn{querier: m.tx, commit: done, rollback: done}, nil
+}
+
 func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at
-	from users order by last_name`
-
-	rows, err := m.DB.QueryContext(ctx, query)
+	var users []*data.User
+	err := m.EachUser(ctx, func(u *data.User) error {
+		users = append(users, u)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var users []*data.User
+	return users, nil
+}
+
+// EachUser calls fn with the users, ordered by last name, as they are read, so they
+// needn't all be held in memory. It stops at the first error fn returns. The query
+// runs until ctx is done, without the usual timeout, since fn may be slow.
+func (m *PostgresDBRepo) EachUser(ctx context.Context, fn func(u *data.User) error) error {
+	tx, err := m.readTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `select id, email, first_name, last_name, password, created_at, updated_at, status, deleted_at
+	from users u
+	where ($1::integer is null or exists (
+		select 1 from organization_members om where om.user_id = u.id and om.organization_id = $1))
+	order by last_name`
+
+	rows, err := tx.QueryContext(ctx, query, m.organization)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var user data.User
@@ -42,138 +299,206 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 			&user.FirstName,
 			&user.LastName,
 			&user.Password,
-			&user.IsAdmin,
 			&user.CreatedAt,
 			&user.UpdatedAt,
+			&user.Status,
+			&user.DeletedAt,
 		)
 		if err != nil {
 			log.Println("Error scanning", err)
-			return nil, err
+			return err
 		}
 
-		users = append(users, &user)
+		if err = fn(&user); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
 	}
 
-	return users, nil
+	return tx.Commit()
 }
 
 // GetUser returns one user by id
 func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	query := `
-		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
-			coalesce(ui.file_name, '')
-		from 
-			users u
-		left join user_images ui on(ui.user_id = u.id)
-		where 
-		    u.id = $1`
+	return m.getUserWhere("u.id = $1", id)
+}
 
-	var user data.User
-	row := m.DB.QueryRowContext(ctx, query, id)
+// GetUserByEmail returns one user by email address, ignoring case
+func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
+	return m.getUserWhere("lower(u.email) = lower($1)", email)
+}
 
-	err := row.Scan(
-		&user.ID,
-		&user.Email,
-		&user.FirstName,
-		&user.LastName,
-		&user.Password,
-		&user.IsAdmin,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-		&user.ProfilePic.FileName,
-	)
+func (m *PostgresDBRepo) getUserWhere(condition string, arg any) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
 
+	tx, err := m.readTx(ctx)
 	if err != nil {
 		return nil, err
 	}
-
-	return &user, nil
-}
-
-// GetUserByEmail returns one user by email address
-func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+	defer tx.Rollback()
 
 	query := `
 		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
-			coalesce(ui.file_name, '')
+			u.id, u.email, u.first_name, u.last_name, u.password, u.created_at, u.updated_at,
+			coalesce(ui.file_name, ''), u.totp_enabled, u.status, u.deleted_at
 		from 
 			users u
 		left join user_images ui on(ui.user_id = u.id)
 		where 
-		    u.email = $1`
+		    ` + condition + `
+		    and ($2::integer is null or exists (
+		        select 1 from organization_members om where om.user_id = u.id and om.organization_id = $2))`
 
 	var user data.User
-	row := m.DB.QueryRowContext(ctx, query, email)
+	row := tx.QueryRowContext(ctx, query, arg, m.organization)
 
-	err := row.Scan(
+	err = row.Scan(
 		&user.ID,
 		&user.Email,
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
-		&user.IsAdmin,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.ProfilePic.FileName,
+		&user.TOTPEnabled,
+		&user.Status,
+		&user.DeletedAt,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &user, nil
+	return &user, tx.Commit()
 }
 
 // UpdateUser updates one user in the database
 func (m *PostgresDBRepo) UpdateUser(u data.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
 	stmt := `update users set
 		email = $1,
 		first_name = $2,
 		last_name = $3,
-		is_admin = $4,
-		updated_at = $5
-		where id = $6
+		updated_at = $4
+		where id = $5
+		and ($6::integer is null or exists (
+			select 1 from organization_members om where om.user_id = users.id and om.organization_id = $6))
 	`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	err := m.execUser(stmt,
 		u.Email,
 		u.FirstName,
 		u.LastName,
-		u.IsAdmin,
 		time.Now(),
 		u.ID,
+		m.organization,
 	)
+	return emailError(err)
+}
 
-	if err != nil {
-		return err
+// emailError returns repository.ErrEmailTaken for a violation of the unique index of
+// users' email addresses, and any other error as it is.
+func emailError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "users_email_key" {
+		return repository.ErrEmailTaken
 	}
+	return err
+}
 
-	return nil
+// SetUserStatus activates, suspends, or deletes a user. Deleting records when, for
+// PurgeDeletedUsers; any other status clears it again.
+func (m *PostgresDBRepo) SetUserStatus(id int, status string) error {
+	stmt := `update users set
+		status = $1,
+		deleted_at = case when $1 = 'deleted' then coalesce(deleted_at, $2) end,
+		updated_at = $2
+		where id = $3
+		and ($4::integer is null or exists (
+			select 1 from organization_members om where om.user_id = users.id and om.organization_id = $4))`
+
+	return m.execUser(stmt, status, time.Now(), id, m.organization)
 }
 
-// DeleteUser deletes one user from the database, by id
+// DeleteUser marks one user as deleted, by id. The row and everything hanging off it
+// stay until PurgeDeletedUsers, so the user can be restored with SetUserStatus.
 func (m *PostgresDBRepo) DeleteUser(id int) error {
+	return m.SetUserStatus(id, data.UserDeleted)
+}
+
+// PurgeDeletedUsers removes the users deleted before a point in time, with their images
+// and other data. It returns the users it removed, with the file name of their profile
+// image, so the caller can remove their files.
+func (m *PostgresDBRepo) PurgeDeletedUsers(before time.Time) ([]*data.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.tenantTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// returning sees the user_images rows as they were before the cascade
+	stmt := `delete from users where status = 'deleted' and deleted_at < $1
+		returning id, email, coalesce((select ui.file_name from user_images ui where ui.user_id = users.id), '')`
+
+	rows, err := tx.QueryContext(ctx, stmt, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*data.User
+	for rows.Next() {
+		var user data.User
+		if err := rows.Scan(&user.ID, &user.Email, &user.ProfilePic.FileName); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, tx.Commit()
+}
+
+// EraseUser removes a user and everything hanging off them straight away, whatever
+// their status, for erasure requests.
+func (m *PostgresDBRepo) EraseUser(id int) error {
+	stmt := `delete from users where id = $1
+		and ($2::integer is null or exists (
+			select 1 from organization_members om where om.user_id = users.id and om.organization_id = $2))`
+
+	return m.execUser(stmt, id, m.organization)
+}
+
+// execUser runs a statement changing users in a tenant transaction. It returns
+// sql.ErrNoRows when the statement changed nothing, which is also what changing a
+// user of another organization does.
+func (m *PostgresDBRepo) execUser(stmt string, args ...any) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `delete from users where id = $1`
+	tx, err := m.tenantTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	result, err := tx.ExecContext(ctx, stmt, args...)
 	if err != nil {
 		return err
 	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
 
-	return nil
+	return tx.Commit()
 }
 
 // InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
@@ -181,30 +506,97 @@ func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	hashedPassword, err := m.Passwords.Hash(user.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.begin(ctx, nil)
 	if err != nil {
 		return 0, err
 	}
+	defer tx.Rollback()
+
+	if err = m.unscope(ctx, tx); err != nil {
+		return 0, err
+	}
 
 	var newID int
-	stmt := `insert into users (email, first_name, last_name, password, is_admin, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	stmt := `insert into users (email, first_name, last_name, password, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
 
-	err = m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		user.Email,
 		user.FirstName,
 		user.LastName,
 		hashedPassword,
-		user.IsAdmin,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
 
 	if err != nil {
-		return 0, err
+		return 0, emailError(err)
 	}
 
-	return newID, nil
+	return newID, tx.Commit()
+}
+
+// ImportUsers inserts users in one transaction, so either all of them are created
+// or none, and returns their ids. In a repository scoped to an organization they
+// become members with their role there.
+func (m *PostgresDBRepo) ImportUsers(users []data.ImportedUser) ([]int, error) {
+	// hash up front, hashing is slow by design and mustn't hold the transaction open
+	hashes := make([]string, len(users))
+	for i, u := range users {
+		hash, err := m.Passwords.Hash(u.Password)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout+time.Duration(len(users))*10*time.Millisecond)
+	defer cancel()
+
+	var ids []int
+	err := m.inTx(ctx, func(repo *PostgresDBRepo) error {
+		if err := repo.unscope(ctx, repo.tx); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		ids = make([]int, 0, len(users))
+		for i, u := range users {
+			var id int
+			stmt := `insert into users (email, first_name, last_name, password, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $5) returning id`
+			err := repo.tx.QueryRowContext(ctx, stmt, u.Email, u.FirstName, u.LastName, hashes[i], now).Scan(&id)
+			if err != nil {
+				return fmt.Errorf("importing %s: %w", u.Email, emailError(err))
+			}
+
+			if repo.organization != nil {
+				_, err = repo.tx.ExecContext(ctx, `insert into organization_members (organization_id, user_id, created_at) values ($1, $2, $3)`,
+					*repo.organization, id, now)
+				if err == nil && u.Role != "" {
+					_, err = repo.tx.ExecContext(ctx, `insert into user_roles (organization_id, user_id, role_id, created_at)
+						select $1, $2, id, $3 from roles where name = $4`, *repo.organization, id, now, u.Role)
+				}
+				if err != nil {
+					return fmt.Errorf("importing %s: %w", u.Email, err)
+				}
+			}
+
+			ids = append(ids, id)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
 }
 
 // ResetPassword is the method we will use to change a user's password.
@@ -212,18 +604,90 @@ func (m *PostgresDBRepo) ResetPassword(id int, password string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := m.Passwords.Hash(password)
 	if err != nil {
 		return err
 	}
 
-	stmt := `update users set password = $1 where id = $2`
-	_, err = m.DB.ExecContext(ctx, stmt, hashedPassword, id)
+	// links mailed before the reset must not work with the new password
+	return m.inTx(ctx, func(repo *PostgresDBRepo) error {
+		stmt := `insert into password_history (user_id, password_hash, created_at)
+			select id, password, $1 from users where id = $2`
+		if _, err := repo.tx.ExecContext(ctx, stmt, time.Now(), id); err != nil {
+			return err
+		}
+
+		stmt = `delete from password_history where user_id = $1 and id not in (
+			select id from password_history where user_id = $1 order by id desc limit $2)`
+		if _, err := repo.tx.ExecContext(ctx, stmt, id, maxPasswordHistory); err != nil {
+			return err
+		}
+
+		stmt = `update users set password = $1, updated_at = $2 where id = $3`
+		if _, err := repo.tx.ExecContext(ctx, stmt, hashedPassword, time.Now(), id); err != nil {
+			return err
+		}
+		return repo.InvalidateUserTokens(id)
+	})
+}
+
+// PasswordHistory returns the hashes of a user's n latest passwords, the current one
+// first.
+func (m *PostgresDBRepo) PasswordHistory(userID, n int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		select password from (
+			select password, 0 as rank from users where id = $1
+			union all
+			select password_hash, row_number() over (order by id desc) from password_history where user_id = $1
+		) h
+		order by rank
+		limit $2`
+
+	tx, err := m.readTx(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	return nil
+	rows, err := tx.QueryContext(ctx, query, userID, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hashes, tx.Commit()
+}
+
+// SetPasswordHash replaces a user's password hash, keeping the password, to upgrade
+// it to the current policy.
+func (m *PostgresDBRepo) SetPasswordHash(id int, hash string) error {
+	stmt := `update users set password = $1 where id = $2`
+	return m.execUser(stmt, hash, id)
+}
+
+// InvalidateUserTokens uses up all of a user's unused tokens.
+func (m *PostgresDBRepo) InvalidateUserTokens(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update user_tokens set used_at = $1 where user_id = $2 and used_at is null`
+	_, err := m.conn().ExecContext(ctx, stmt, time.Now(), userID)
+	return err
 }
 
 // InsertUserImage inserts a user profile image into the database.
@@ -231,22 +695,24 @@ func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `delete from user_images where user_id = $1`
-	_, err := m.DB.ExecContext(ctx, stmt, i.UserID)
-	if err != nil {
-		return 0, err
-	}
-
 	var newID int
-	stmt = `insert into user_images (user_id, file_name, created_at, updated_at)
-		values ($1, $2, $3, $4) returning id`
+	err := m.inTx(ctx, func(repo *PostgresDBRepo) error {
+		stmt := `delete from user_images where user_id = $1`
+		_, err := repo.tx.ExecContext(ctx, stmt, i.UserID)
+		if err != nil {
+			return err
+		}
 
-	err = m.DB.QueryRowContext(ctx, stmt,
-		i.UserID,
-		i.FileName,
-		time.Now(),
-		time.Now(),
-	).Scan(&newID)
+		stmt = `insert into user_images (user_id, file_name, created_at, updated_at)
+			values ($1, $2, $3, $4) returning id`
+
+		return repo.tx.QueryRowContext(ctx, stmt,
+			i.UserID,
+			i.FileName,
+			time.Now(),
+			time.Now(),
+		).Scan(&newID)
+	})
 
 	if err != nil {
 		return 0, err
@@ -254,3 +720,915 @@ func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
 
 	return newID, nil
 }
+
+// GetUserImage returns the metadata of a user's profile image.
+func (m *PostgresDBRepo) GetUserImage(userID int) (*data.UserImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, file_name, created_at, updated_at from user_images where user_id = $1`
+
+	var i data.UserImage
+	err := m.conn().QueryRowContext(ctx, query, userID).Scan(
+		&i.ID,
+		&i.UserID,
+		&i.FileName,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+// ImageInUse reports whether a user other than exceptUserID has a profile image by
+// that file name.
+func (m *PostgresDBRepo) ImageInUse(fileName string, exceptUserID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select exists(select 1 from user_images where file_name = $1 and user_id <> $2)`
+
+	var inUse bool
+	err := m.conn().QueryRowContext(ctx, query, fileName, exceptUserID).Scan(&inUse)
+	return inUse, err
+}
+
+// EnableUserTOTP stores a confirmed authenticator secret for a user, with the time
+// step of the code that confirmed it, and replaces any recovery codes they had with
+// the given hashes.
+func (m *PostgresDBRepo) EnableUserTOTP(id int, secret string, step int64, recoveryCodeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(repo *PostgresDBRepo) error {
+		stmt := `update users set totp_secret = $1, totp_enabled = true, totp_last_step = $2, updated_at = $3 where id = $4`
+		if _, err := repo.tx.ExecContext(ctx, stmt, secret, step, time.Now(), id); err != nil {
+			return err
+		}
+
+		stmt = `delete from user_recovery_codes where user_id = $1`
+		if _, err := repo.tx.ExecContext(ctx, stmt, id); err != nil {
+			return err
+		}
+
+		stmt = `insert into user_recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`
+		for _, hash := range recoveryCodeHashes {
+			if _, err := repo.tx.ExecContext(ctx, stmt, id, hash, time.Now()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetTOTPSecret returns the secret of a user's authenticator, or sql.ErrNoRows if they
+// have none enabled.
+func (m *PostgresDBRepo) GetTOTPSecret(userID int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.readTx(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var secret string
+	query := `select totp_secret from users where id = $1 and totp_enabled and totp_secret is not null`
+	if err = tx.QueryRowContext(ctx, query, userID).Scan(&secret); err != nil {
+		return "", err
+	}
+
+	return secret, tx.Commit()
+}
+
+// UseTOTPStep records that a user logged in with the code of a time step. It reports
+// false if they already used that step or a later one, so a code can't be replayed
+// while it is still valid.
+func (m *PostgresDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.tenantTx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	stmt := `update users set totp_last_step = $1
+		where id = $2 and totp_enabled and (totp_last_step is null or totp_last_step < $1)`
+
+	result, err := tx.ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, tx.Commit()
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It reports false if the
+// user has no such unused code.
+func (m *PostgresDBRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update user_recovery_codes set used_at = $1
+		where user_id = $2 and code_hash = $3 and used_at is null`
+
+	result, err := m.conn().ExecContext(ctx, stmt, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// InsertUserToken stores the hash of a single-use token, and returns its id.
+func (m *PostgresDBRepo) InsertUserToken(t data.UserToken) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into user_tokens (user_id, token_hash, purpose, expires_at, created_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.conn().QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.TokenHash,
+		t.Purpose,
+		t.ExpiresAt,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetUserToken returns an unused, unexpired token without using it up.
+func (m *PostgresDBRepo) GetUserToken(tokenHash, purpose string) (*data.UserToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, token_hash, purpose, expires_at, created_at from user_tokens
+		where token_hash = $1 and purpose = $2 and used_at is null and expires_at > $3`
+
+	var t data.UserToken
+	err := m.conn().QueryRowContext(ctx, query, tokenHash, purpose, time.Now()).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.TokenHash,
+		&t.Purpose,
+		&t.ExpiresAt,
+		&t.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// ConsumeUserToken marks an unused, unexpired token as used and returns it. Marking
+// and checking happen in one statement, so a token can only ever be redeemed once.
+func (m *PostgresDBRepo) ConsumeUserToken(tokenHash, purpose string) (*data.UserToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update user_tokens set used_at = $1
+		where token_hash = $2 and purpose = $3 and used_at is null and expires_at > $1
+		returning id, user_id, token_hash, purpose, expires_at, created_at`
+
+	var t data.UserToken
+	err := m.conn().QueryRowContext(ctx, stmt, time.Now(), tokenHash, purpose).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.TokenHash,
+		&t.Purpose,
+		&t.ExpiresAt,
+		&t.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// InsertUserTokenLimited stores a token like InsertUserToken, unless the user was
+// issued limit tokens of its purpose since a point in time already. Counting and
+// inserting run in one serializable unit of work, so concurrent requests can't both
+// get under the limit. It reports whether the token was stored.
+func (m *PostgresDBRepo) InsertUserTokenLimited(t data.UserToken, limit int, since time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var stored bool
+	err := m.inTx(ctx, func(repo *PostgresDBRepo) error {
+		stored = false
+
+		var count int
+		query := `select count(*) from user_tokens where user_id = $1 and purpose = $2 and created_at > $3`
+		if err := repo.tx.QueryRowContext(ctx, query, t.UserID, t.Purpose, since).Scan(&count); err != nil {
+			return err
+		}
+		if count >= limit {
+			return nil
+		}
+
+		if _, err := repo.InsertUserToken(t); err != nil {
+			return err
+		}
+		stored = true
+		return nil
+	})
+
+	return stored, err
+}
+
+// GetUserByIdentity returns the user linked to an external provider's subject.
+func (m *PostgresDBRepo) GetUserByIdentity(issuer, subject string) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select user_id from user_identities where issuer = $1 and subject = $2`
+
+	var userID int
+	err := m.conn().QueryRowContext(ctx, query, issuer, subject).Scan(&userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetUser(userID)
+}
+
+// InsertUserIdentity links a user to an external provider's subject, and returns the ID of the newly inserted row
+func (m *PostgresDBRepo) InsertUserIdentity(i data.UserIdentity) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into user_identities (user_id, issuer, subject, email, created_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.conn().QueryRowContext(ctx, stmt,
+		i.UserID,
+		i.Issuer,
+		i.Subject,
+		i.Email,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetUserIdentities returns the external provider accounts linked to a user
+func (m *PostgresDBRepo) GetUserIdentities(userID int) ([]*data.UserIdentity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, issuer, subject, coalesce(email, ''), created_at
+		from user_identities where user_id = $1 order by id`
+
+	rows, err := m.conn().QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*data.UserIdentity
+
+	for rows.Next() {
+		var i data.UserIdentity
+		err := rows.Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.Email, &i.CreatedAt)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, err
+		}
+		identities = append(identities, &i)
+	}
+
+	return identities, rows.Err()
+}
+
+// AllOAuthClients returns every registered OAuth client
+func (m *PostgresDBRepo) AllOAuthClients() ([]*data.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, client_id, secret_hash, name, redirect_uris, created_at
+	from oauth_clients order by name`
+
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []*data.OAuthClient
+
+	for rows.Next() {
+		var client data.OAuthClient
+		var redirectURIs string
+		err := rows.Scan(
+			&client.ID,
+			&client.ClientID,
+			&client.SecretHash,
+			&client.Name,
+			&redirectURIs,
+			&client.CreatedAt,
+		)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, err
+		}
+		client.RedirectURIs = strings.Fields(redirectURIs)
+
+		clients = append(clients, &client)
+	}
+
+	return clients, nil
+}
+
+// GetOAuthClient returns one OAuth client by its public client id
+func (m *PostgresDBRepo) GetOAuthClient(clientID string) (*data.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, client_id, secret_hash, name, redirect_uris, created_at
+	from oauth_clients where client_id = $1`
+
+	var client data.OAuthClient
+	var redirectURIs string
+	err := m.conn().QueryRowContext(ctx, query, clientID).Scan(
+		&client.ID,
+		&client.ClientID,
+		&client.SecretHash,
+		&client.Name,
+		&redirectURIs,
+		&client.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	client.RedirectURIs = strings.Fields(redirectURIs)
+
+	return &client, nil
+}
+
+// InsertOAuthClient registers an OAuth client, and returns the ID of the newly inserted row
+func (m *PostgresDBRepo) InsertOAuthClient(c data.OAuthClient) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into oauth_clients (client_id, secret_hash, name, redirect_uris, created_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.conn().QueryRowContext(ctx, stmt,
+		c.ClientID,
+		c.SecretHash,
+		c.Name,
+		strings.Join(c.RedirectURIs, "\n"),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteOAuthClient removes an OAuth client, and its outstanding codes
+func (m *PostgresDBRepo) DeleteOAuthClient(clientID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from oauth_clients where client_id = $1`
+
+	_, err := m.conn().ExecContext(ctx, stmt, clientID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertAuthorizationCode stores the hash of an issued authorization code.
+func (m *PostgresDBRepo) InsertAuthorizationCode(c data.AuthorizationCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into oauth_authorization_codes
+		(code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, expires_at, organization_id, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, nullif($9, 0), $10)`
+
+	_, err := m.conn().ExecContext(ctx, stmt,
+		c.CodeHash,
+		c.ClientID,
+		c.UserID,
+		c.RedirectURI,
+		c.Scope,
+		c.Nonce,
+		c.CodeChallenge,
+		c.ExpiresAt,
+		c.OrganizationID,
+		time.Now(),
+	)
+
+	return err
+}
+
+// ConsumeAuthorizationCode marks an unused, unexpired code as used and returns it,
+// so a code can only be exchanged once.
+func (m *PostgresDBRepo) ConsumeAuthorizationCode(codeHash string) (*data.AuthorizationCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update oauth_authorization_codes set used_at = $1
+		where code_hash = $2 and used_at is null and expires_at > $1
+		returning code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, expires_at,
+			coalesce(organization_id, 0)`
+
+	var c data.AuthorizationCode
+	err := m.conn().QueryRowContext(ctx, stmt, time.Now(), codeHash).Scan(
+		&c.CodeHash,
+		&c.ClientID,
+		&c.UserID,
+		&c.RedirectURI,
+		&c.Scope,
+		&c.Nonce,
+		&c.CodeChallenge,
+		&c.ExpiresAt,
+		&c.OrganizationID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// AllRoles returns all roles with the names of their permissions
+func (m *PostgresDBRepo) AllRoles() ([]*data.Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		select
+			r.id, r.name, r.description, r.created_at,
+			coalesce(string_agg(p.name, ' ' order by p.name), '')
+		from
+			roles r
+		left join role_permissions rp on(rp.role_id = r.id)
+		left join permissions p on(p.id = rp.permission_id)
+		group by r.id
+		order by r.name`
+
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*data.Role
+
+	for rows.Next() {
+		var role data.Role
+		var permissions string
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Description,
+			&role.CreatedAt,
+			&permissions,
+		)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, err
+		}
+		role.Permissions = strings.Fields(permissions)
+
+		roles = append(roles, &role)
+	}
+
+	return roles, nil
+}
+
+// InsertRole inserts a role with its permissions, and returns the new role's id
+func (m *PostgresDBRepo) InsertRole(r data.Role) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	err := m.inTx(ctx, func(repo *PostgresDBRepo) error {
+		stmt := `insert into roles (name, description, created_at) values ($1, $2, $3) returning id`
+		err := repo.tx.QueryRowContext(ctx, stmt, r.Name, r.Description, time.Now()).Scan(&newID)
+		if err != nil {
+			return err
+		}
+
+		stmt = `insert into role_permissions (role_id, permission_id)
+			select $1, id from permissions where name = $2`
+		for _, permission := range r.Permissions {
+			result, err := repo.tx.ExecContext(ctx, stmt, newID, permission)
+			if err != nil {
+				return err
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				return fmt.Errorf("unknown permission %q", permission)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GrantPermission adds a permission to a role. Granting it twice is a no-op.
+func (m *PostgresDBRepo) GrantPermission(role, permission string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into role_permissions (role_id, permission_id)
+		select r.id, p.id from roles r, permissions p where r.name = $1 and p.name = $2
+		on conflict do nothing`
+
+	_, err := m.conn().ExecContext(ctx, stmt, role, permission)
+	return err
+}
+
+// RevokePermission takes a permission away from a role.
+func (m *PostgresDBRepo) RevokePermission(role, permission string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from role_permissions
+		where role_id = (select id from roles where name = $1)
+		and permission_id = (select id from permissions where name = $2)`
+
+	_, err := m.conn().ExecContext(ctx, stmt, role, permission)
+	return err
+}
+
+// GetUserRoles returns the names of a user's roles in the repository's organization,
+// or in any organization for an unscoped repository
+func (m *PostgresDBRepo) GetUserRoles(userID int) ([]string, error) {
+	query := `select distinct r.name from roles r
+		join user_roles ur on(ur.role_id = r.id)
+		where ur.user_id = $1 and ($2::integer is null or ur.organization_id = $2)
+		order by r.name`
+
+	return m.names(query, userID, m.organization)
+}
+
+// GetUserPermissions returns the names of all permissions a user has through their
+// roles, scoped like GetUserRoles
+func (m *PostgresDBRepo) GetUserPermissions(userID int) ([]string, error) {
+	query := `select distinct p.name from permissions p
+		join role_permissions rp on(rp.permission_id = p.id)
+		join user_roles ur on(ur.role_id = rp.role_id)
+		where ur.user_id = $1 and ($2::integer is null or ur.organization_id = $2)
+		order by p.name`
+
+	return m.names(query, userID, m.organization)
+}
+
+func (m *PostgresDBRepo) names(query string, args ...any) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.readTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, tx.Commit()
+}
+
+// AssignUserRole gives a member of the repository's organization a role there.
+// Assigning it twice is a no-op.
+func (m *PostgresDBRepo) AssignUserRole(userID int, role string) error {
+	if m.organization == nil {
+		return errNoOrganization
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var roleID int
+	err := m.conn().QueryRowContext(ctx, `select id from roles where name = $1`, role).Scan(&roleID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into user_roles (organization_id, user_id, role_id, created_at) values ($1, $2, $3, $4)
+		on conflict do nothing`
+
+	err = m.execUser(stmt, *m.organization, userID, roleID, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		// they already have it
+		return nil
+	}
+	return err
+}
+
+// RemoveUserRole takes a role away from a user, in the repository's organization. It
+// returns sql.ErrNoRows if they don't have it there.
+func (m *PostgresDBRepo) RemoveUserRole(userID int, role string) error {
+	if m.organization == nil {
+		return errNoOrganization
+	}
+
+	stmt := `delete from user_roles
+		where organization_id = $1 and user_id = $2 and role_id = (select id from roles where name = $3)`
+
+	return m.execUser(stmt, *m.organization, userID, role)
+}
+
+// errNoOrganization is returned by methods that only make sense within an organization.
+var errNoOrganization = errors.New("repository is not scoped to an organization")
+
+// InsertOrganization inserts an organization, and returns its id
+func (m *PostgresDBRepo) InsertOrganization(o data.Organization) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into organizations (name, slug, created_at) values ($1, $2, $3) returning id`
+
+	err := m.conn().QueryRowContext(ctx, stmt, o.Name, o.Slug, time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetOrganization returns one organization by id
+func (m *PostgresDBRepo) GetOrganization(id int) (*data.Organization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, name, slug, created_at from organizations where id = $1`
+
+	var o data.Organization
+	err := m.conn().QueryRowContext(ctx, query, id).Scan(&o.ID, &o.Name, &o.Slug, &o.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &o, nil
+}
+
+// GetUserOrganizations returns the organizations a user is a member of
+func (m *PostgresDBRepo) GetUserOrganizations(userID int) ([]*data.Organization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select o.id, o.name, o.slug, o.created_at
+		from organizations o
+		join organization_members om on(om.organization_id = o.id)
+		where om.user_id = $1
+		order by o.name`
+
+	rows, err := m.conn().QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var organizations []*data.Organization
+
+	for rows.Next() {
+		var o data.Organization
+		if err := rows.Scan(&o.ID, &o.Name, &o.Slug, &o.CreatedAt); err != nil {
+			log.Println("Error scanning", err)
+			return nil, err
+		}
+		organizations = append(organizations, &o)
+	}
+
+	return organizations, rows.Err()
+}
+
+// AddOrganizationMember makes a user a member of an organization. Adding them twice is
+// a no-op.
+func (m *PostgresDBRepo) AddOrganizationMember(organizationID, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into organization_members (organization_id, user_id, created_at) values ($1, $2, $3)
+		on conflict do nothing`
+
+	_, err := m.conn().ExecContext(ctx, stmt, organizationID, userID, time.Now())
+	return err
+}
+
+// RemoveOrganizationMember takes a user out of an organization, with their roles there.
+func (m *PostgresDBRepo) RemoveOrganizationMember(organizationID, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from organization_members where organization_id = $1 and user_id = $2`
+
+	_, err := m.conn().ExecContext(ctx, stmt, organizationID, userID)
+	return err
+}
+
+// InsertInvitation stores an invitation, and returns its id. Role is optional, but
+// has to name an existing role when set.
+func (m *PostgresDBRepo) InsertInvitation(i data.Invitation) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var roleID *int
+	if i.Role != "" {
+		roleID = new(int)
+		err := m.conn().QueryRowContext(ctx, `select id from roles where name = $1`, i.Role).Scan(roleID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var newID int
+	stmt := `insert into invitations (organization_id, email, role_id, invited_by, token_hash, expires_at, created_at)
+		values ($1, $2, $3, nullif($4, 0), $5, $6, $7) returning id`
+
+	err := m.conn().QueryRowContext(ctx, stmt,
+		i.OrganizationID,
+		i.Email,
+		roleID,
+		i.InvitedBy,
+		i.TokenHash,
+		i.ExpiresAt,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// invitationQuery selects invitations from i, which can be the invitations table or
+// a common table expression with its columns, with their organization and role names.
+const invitationQuery = `select
+		i.id, i.organization_id, o.name, i.email, coalesce(r.name, ''), coalesce(i.invited_by, 0),
+		i.token_hash, i.expires_at, i.created_at
+	from
+		i
+	join organizations o on(o.id = i.organization_id)
+	left join roles r on(r.id = i.role_id)`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanInvitation(row scanner) (*data.Invitation, error) {
+	var i data.Invitation
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.OrganizationName,
+		&i.Email,
+		&i.Role,
+		&i.InvitedBy,
+		&i.TokenHash,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// GetInvitation returns a pending invitation: one that hasn't been accepted,
+// revoked, or expired.
+func (m *PostgresDBRepo) GetInvitation(tokenHash string) (*data.Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `with i as (
+			select * from invitations
+			where token_hash = $1 and accepted_at is null and revoked_at is null and expires_at > $2
+		) ` + invitationQuery
+
+	return scanInvitation(m.conn().QueryRowContext(ctx, query, tokenHash, time.Now()))
+}
+
+// ConsumeInvitation marks a pending invitation as accepted and returns it. Marking
+// and checking happen in one statement, so an invitation can only be accepted once.
+func (m *PostgresDBRepo) ConsumeInvitation(tokenHash string) (*data.Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `with i as (
+			update invitations set accepted_at = $1
+			where token_hash = $2 and accepted_at is null and revoked_at is null and expires_at > $1
+			returning *
+		) ` + invitationQuery
+
+	return scanInvitation(m.conn().QueryRowContext(ctx, query, time.Now(), tokenHash))
+}
+
+// PendingInvitations returns the pending invitations of an organization, newest first
+func (m *PostgresDBRepo) PendingInvitations(organizationID int) ([]*data.Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `with i as (
+			select * from invitations
+			where organization_id = $1 and accepted_at is null and revoked_at is null and expires_at > $2
+		) ` + invitationQuery + `
+		order by i.created_at desc`
+
+	rows, err := m.conn().QueryContext(ctx, query, organizationID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*data.Invitation
+
+	for rows.Next() {
+		i, err := scanInvitation(rows)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, err
+		}
+		invitations = append(invitations, i)
+	}
+
+	return invitations, rows.Err()
+}
+
+// RevokeInvitation withdraws a pending invitation of an organization, so its link stops
+// working.
+func (m *PostgresDBRepo) RevokeInvitation(organizationID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update invitations set revoked_at = $1
+		where organization_id = $2 and id = $3 and accepted_at is null and revoked_at is null`
+
+	result, err := m.conn().ExecContext(ctx, stmt, time.Now(), organizationID, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
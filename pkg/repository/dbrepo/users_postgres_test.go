@@ -1,18 +1,22 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	_ "github.com/jackc/pgconn"
+	"github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/ory/dockertest/v3"
 	"github.com/ory/dockertest/v3/docker"
 	"log"
 	"os"
+	"strings"
 	"testing"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/passwords"
 	"webapp/pkg/repository"
 )
 
@@ -27,6 +31,10 @@ var pool *dockertest.Pool
 var testDB *sql.DB
 var testRepo repository.DatabaseRepo
 
+// appDB connects as the role the app uses, which row-level security applies to,
+// unlike to the superuser of testDB.
+var appDB *sql.DB
+
 func TestMain(m *testing.M) {
 	var err error
 	//connect to docker; fail if docker not running
@@ -77,7 +85,12 @@ func TestMain(m *testing.M) {
 		log.Fatalf("could not read the data from file: %s", err)
 	}
 
-	testRepo = &PostgresDBRepo{DB: testDB}
+	appDB, err = sql.Open("pgx", fmt.Sprintf(dsn, host, port, "webapp", "webapp", dbName))
+	if err != nil {
+		log.Fatalf("could not connect as the app role: %s", err)
+	}
+
+	testRepo = &PostgresDBRepo{DB: appDB}
 
 	//run the tests
 	code := m.Run()
@@ -116,7 +129,6 @@ func TestPostgresDBRepoInsertUser(t *testing.T) {
 		LastName:  "User",
 		Email:     "admin@example.com",
 		Password:  "secret",
-		IsAdmin:   1,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -146,7 +158,6 @@ func TestPostgresDBRepoAllUsers(t *testing.T) {
 		LastName:  "Smith",
 		Email:     "Jack@smith.com",
 		Password:  "secret",
-		IsAdmin:   1,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -190,6 +201,21 @@ func TestPostgresDBRepo_GetUserByEmail(t *testing.T) {
 	if user.ID != 2 {
 		t.Errorf("getUser returned a wrong ID: expected 2, but got %d", user.ID)
 	}
+
+	user, err = testRepo.GetUserByEmail("ADMIN@EXAMPLE.COM")
+	if err != nil || user.ID != 1 {
+		t.Errorf("getUserByEmail should ignore case: got %d, %v", user.ID, err)
+	}
+
+	_, err = testRepo.InsertUser(data.User{
+		FirstName: "Other",
+		LastName:  "Admin",
+		Email:     "Admin@Example.com",
+		Password:  "secret",
+	})
+	if !errors.Is(err, repository.ErrEmailTaken) {
+		t.Errorf("insertUser should report a taken email in another case, got %v", err)
+	}
 }
 
 func TestPostgresDBRepo_UpdateUser(t *testing.T) {
@@ -199,7 +225,6 @@ func TestPostgresDBRepo_UpdateUser(t *testing.T) {
 		Email:     "upadate@example.com",
 		FirstName: "John",
 		LastName:  "Doe",
-		IsAdmin:   0,
 	}
 	//calling UpdateUser func
 	err := testRepo.UpdateUser(updateUser)
@@ -224,10 +249,6 @@ func TestPostgresDBRepo_UpdateUser(t *testing.T) {
 		t.Errorf("Updataed Last Name doesn't match: expected Doe, but got %v", newUser.LastName)
 
 	}
-	if newUser.IsAdmin != updateUser.IsAdmin {
-		t.Errorf("Updataed admin status doesn't match: expected 0, but got %v", newUser.IsAdmin)
-
-	}
 
 }
 
@@ -254,7 +275,6 @@ func TestPostgresDBRepo_DeleteUser2(t *testing.T) {
 		LastName:  "User",
 		Email:     "todelete@example.com",
 		Password:  "secret",
-		IsAdmin:   0,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -270,8 +290,17 @@ func TestPostgresDBRepo_DeleteUser2(t *testing.T) {
 	}
 
 	deletedUser, err := testRepo.GetUser(insertedID)
-	if deletedUser != nil {
-		t.Errorf("expected %v to be deleted, but got him in the base", deletedUser)
+	if err != nil || deletedUser.Status != data.UserDeleted || deletedUser.DeletedAt == nil {
+		t.Errorf("expected %v to be marked deleted, but got %+v", insertedID, deletedUser)
+	}
+
+	err = testRepo.SetUserStatus(insertedID, data.UserActive)
+	if err != nil {
+		t.Error("restoring user failed", err)
+	}
+	restoredUser, _ := testRepo.GetUser(insertedID)
+	if restoredUser.Status != data.UserActive || restoredUser.DeletedAt != nil {
+		t.Errorf("expected %v to be restored, but got %+v", insertedID, restoredUser)
 	}
 }
 
@@ -280,6 +309,16 @@ func TestPostgresDBRepo_DeleteUser(t *testing.T) {
 	if err != nil {
 		t.Error("error got while deleting user with ID 2", err)
 	}
+
+	purged, err := testRepo.PurgeDeletedUsers(time.Now().Add(-time.Hour))
+	if err != nil || len(purged) != 0 {
+		t.Errorf("expected a just deleted user to be kept, but purged %d: %v", len(purged), err)
+	}
+
+	purged, err = testRepo.PurgeDeletedUsers(time.Now().Add(time.Hour))
+	if err != nil || len(purged) != 1 || purged[0].ID != 2 {
+		t.Errorf("expected user 2 to be purged, but got %v: %v", purged, err)
+	}
 	_, err = testRepo.GetUser(2)
 	if err == nil {
 		t.Errorf("expected to have no user by getting user with ID 2")
@@ -321,4 +360,653 @@ func TestPostgresDBRepo_InsertUserImage(t *testing.T) {
 	if err == nil {
 		t.Error("inserted a user image with non-existing user id")
 	}
+
+	if inUse, err := testRepo.ImageInUse("test.jpg", 1); err != nil || inUse {
+		t.Errorf("expected the image of user 1 not to count for them, but got %v, %v", inUse, err)
+	}
+	if inUse, err := testRepo.ImageInUse("test.jpg", 2); err != nil || !inUse {
+		t.Errorf("expected the image of user 1 to be in use, but got %v, %v", inUse, err)
+	}
+}
+
+func TestPostgresDBRepo_EnableUserTOTP(t *testing.T) {
+	err := testRepo.EnableUserTOTP(1, "JBSWY3DPEHPK3PXP", 100, []string{"first-hash", "second-hash"})
+	if err != nil {
+		t.Error("enabling totp failed", err)
+	}
+
+	user, _ := testRepo.GetUser(1)
+	secret, _ := testRepo.GetTOTPSecret(1)
+	if !user.TOTPEnabled || secret != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("expected totp to be enabled with the stored secret, but got %v %q", user.TOTPEnabled, secret)
+	}
+
+	for _, e := range []struct {
+		step     int64
+		expected bool
+	}{{100, false}, {101, true}, {101, false}, {99, false}, {102, true}} {
+		if ok, err := testRepo.UseTOTPStep(1, e.step); err != nil || ok != e.expected {
+			t.Errorf("using step %d: expected %v, but got %v %v", e.step, e.expected, ok, err)
+		}
+	}
+
+	ok, err := testRepo.UseRecoveryCode(1, "first-hash")
+	if err != nil || !ok {
+		t.Errorf("expected an unused recovery code to be accepted: %v", err)
+	}
+
+	ok, _ = testRepo.UseRecoveryCode(1, "first-hash")
+	if ok {
+		t.Error("expected a used recovery code to be rejected")
+	}
+
+	ok, _ = testRepo.UseRecoveryCode(1, "unknown-hash")
+	if ok {
+		t.Error("expected an unknown recovery code to be rejected")
+	}
+}
+
+func TestPostgresDBRepo_UserTokens(t *testing.T) {
+	token := data.UserToken{
+		UserID:    1,
+		TokenHash: "4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945",
+		Purpose:   data.TokenMagicLink,
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+	_, err := testRepo.InsertUserToken(token)
+	if err != nil {
+		t.Error("inserting user token failed", err)
+	}
+
+	limited := token
+	limited.TokenHash = "1111111111111111111111111111111111111111111111111111111111111111"
+	stored, err := testRepo.InsertUserTokenLimited(limited, 1, time.Now().Add(-time.Hour))
+	if err != nil || stored {
+		t.Errorf("expected the limit of one token an hour to be reached, but got %v (%v)", stored, err)
+	}
+	stored, err = testRepo.InsertUserTokenLimited(limited, 2, time.Now().Add(-time.Hour))
+	if err != nil || !stored {
+		t.Errorf("expected a token under the limit to be stored, but got %v (%v)", stored, err)
+	}
+
+	consumed, err := testRepo.ConsumeUserToken(token.TokenHash, data.TokenMagicLink)
+	if err != nil {
+		t.Fatal("consuming a fresh token failed", err)
+	}
+	if consumed.UserID != 1 {
+		t.Errorf("consumed token belongs to user %d, expected 1", consumed.UserID)
+	}
+
+	_, err = testRepo.ConsumeUserToken(token.TokenHash, data.TokenMagicLink)
+	if err == nil {
+		t.Error("expected a used token to be rejected")
+	}
+
+	token.TokenHash = "0000000000000000000000000000000000000000000000000000000000000000"
+	token.ExpiresAt = time.Now().Add(-time.Minute)
+	_, _ = testRepo.InsertUserToken(token)
+	_, err = testRepo.ConsumeUserToken(token.TokenHash, data.TokenMagicLink)
+	if err == nil {
+		t.Error("expected an expired token to be rejected")
+	}
+}
+
+func TestPostgresDBRepo_UserIdentities(t *testing.T) {
+	_, err := testRepo.InsertUserIdentity(data.UserIdentity{
+		UserID:  1,
+		Issuer:  "https://accounts.example.com",
+		Subject: "1234",
+		Email:   "admin@example.com",
+	})
+	if err != nil {
+		t.Error("inserting user identity failed", err)
+	}
+
+	user, err := testRepo.GetUserByIdentity("https://accounts.example.com", "1234")
+	if err != nil {
+		t.Fatal("getting user by identity failed", err)
+	}
+	if user.ID != 1 {
+		t.Errorf("expected linked user 1, but got %d", user.ID)
+	}
+
+	_, err = testRepo.GetUserByIdentity("https://other.example.com", "1234")
+	if err == nil {
+		t.Error("expected no user for an identity of another issuer")
+	}
+
+	_, err = testRepo.InsertUserIdentity(data.UserIdentity{UserID: 1, Issuer: "https://accounts.example.com", Subject: "1234"})
+	if err == nil {
+		t.Error("expected linking the same identity twice to fail")
+	}
+}
+
+func TestPostgresDBRepo_OAuthClients(t *testing.T) {
+	_, err := testRepo.InsertOAuthClient(data.OAuthClient{
+		ClientID:     "wiki",
+		SecretHash:   data.HashClientSecret("wiki-secret"),
+		Name:         "Wiki",
+		RedirectURIs: []string{"https://wiki.example.com/callback", "http://localhost:8080/callback"},
+	})
+	if err != nil {
+		t.Fatal("inserting oauth client failed", err)
+	}
+
+	client, err := testRepo.GetOAuthClient("wiki")
+	if err != nil {
+		t.Fatal("getting oauth client failed", err)
+	}
+	if len(client.RedirectURIs) != 2 || !client.SecretMatches("wiki-secret") {
+		t.Errorf("unexpected client %+v", client)
+	}
+
+	err = testRepo.InsertAuthorizationCode(data.AuthorizationCode{
+		CodeHash:      "code-hash",
+		ClientID:      "wiki",
+		UserID:        1,
+		RedirectURI:   "https://wiki.example.com/callback",
+		Scope:         "openid",
+		CodeChallenge: "challenge",
+		ExpiresAt:     time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal("inserting authorization code failed", err)
+	}
+
+	code, err := testRepo.ConsumeAuthorizationCode("code-hash")
+	if err != nil {
+		t.Fatal("consuming authorization code failed", err)
+	}
+	if code.UserID != 1 || code.ClientID != "wiki" {
+		t.Errorf("unexpected authorization code %+v", code)
+	}
+
+	_, err = testRepo.ConsumeAuthorizationCode("code-hash")
+	if err == nil {
+		t.Error("expected an authorization code to work only once")
+	}
+
+	err = testRepo.DeleteOAuthClient("wiki")
+	if err != nil {
+		t.Error("deleting oauth client failed", err)
+	}
+
+	clients, _ := testRepo.AllOAuthClients()
+	if len(clients) != 0 {
+		t.Errorf("expected no clients, but got %d", len(clients))
+	}
+}
+
+func TestPostgresDBRepo_SetUserStatus(t *testing.T) {
+	err := testRepo.SetUserStatus(1, data.UserSuspended)
+	if err != nil {
+		t.Error("setting user status failed", err)
+	}
+
+	user, _ := testRepo.GetUser(1)
+	if user.Status != data.UserSuspended {
+		t.Errorf("expected status %s, but got %s", data.UserSuspended, user.Status)
+	}
+
+	_ = testRepo.SetUserStatus(1, data.UserActive)
+}
+
+func TestPostgresDBRepo_Roles(t *testing.T) {
+	roles, err := testRepo.AllRoles()
+	if err != nil {
+		t.Fatal("listing roles failed", err)
+	}
+	if len(roles) != 2 || roles[0].Name != data.RoleAdmin || len(roles[0].Permissions) != 3 {
+		t.Errorf("expected the seeded admin and viewer roles, but got %+v", roles)
+	}
+
+	_, err = testRepo.InsertRole(data.Role{Name: "support", Permissions: []string{data.PermissionUsersRead}})
+	if err != nil {
+		t.Fatal("inserting role failed", err)
+	}
+	_, err = testRepo.InsertRole(data.Role{Name: "broken", Permissions: []string{"nothing:here"}})
+	if err == nil {
+		t.Error("expected a role with an unknown permission to be refused")
+	}
+
+	organizationID, err := testRepo.InsertOrganization(data.Organization{Name: "Roles", Slug: "roles"})
+	if err != nil {
+		t.Fatal("inserting organization failed", err)
+	}
+	_ = testRepo.AddOrganizationMember(organizationID, 1)
+	orgRepo := testRepo.ForOrganization(organizationID)
+
+	err = orgRepo.AssignUserRole(1, "support")
+	if err != nil {
+		t.Fatal("assigning role failed", err)
+	}
+	err = orgRepo.AssignUserRole(1, "support")
+	if err != nil {
+		t.Error("expected assigning a role twice to be a no-op, but got", err)
+	}
+	err = orgRepo.AssignUserRole(1, "nobody")
+	if err == nil {
+		t.Error("expected assigning an unknown role to fail")
+	}
+
+	err = testRepo.GrantPermission("support", data.PermissionUsersWrite)
+	if err != nil {
+		t.Error("granting permission failed", err)
+	}
+
+	userRoles, _ := orgRepo.GetUserRoles(1)
+	permissions, _ := orgRepo.GetUserPermissions(1)
+	if len(userRoles) != 1 || userRoles[0] != "support" || len(permissions) != 2 {
+		t.Errorf("unexpected roles %v and permissions %v", userRoles, permissions)
+	}
+
+	_ = testRepo.RevokePermission("support", data.PermissionUsersWrite)
+	_ = orgRepo.RemoveUserRole(1, "support")
+
+	permissions, _ = orgRepo.GetUserPermissions(1)
+	if len(permissions) != 0 {
+		t.Errorf("expected no permissions after removing the role, but got %v", permissions)
+	}
+}
+
+func TestPostgresDBRepo_Organizations(t *testing.T) {
+	acme, err := testRepo.InsertOrganization(data.Organization{Name: "Acme", Slug: "acme"})
+	if err != nil {
+		t.Fatal("inserting organization failed", err)
+	}
+	globex, _ := testRepo.InsertOrganization(data.Organization{Name: "Globex", Slug: "globex"})
+
+	_, err = testRepo.InsertOrganization(data.Organization{Name: "Acme again", Slug: "acme"})
+	if err == nil {
+		t.Error("expected a duplicate slug to be refused")
+	}
+
+	if err = testRepo.AddOrganizationMember(acme, 1); err != nil {
+		t.Fatal("adding member failed", err)
+	}
+
+	if o, err := testRepo.GetOrganization(acme); err != nil || o.Slug != "acme" {
+		t.Errorf("expected to get organization %d, but got %+v, %v", acme, o, err)
+	}
+
+	organizations, _ := testRepo.GetUserOrganizations(1)
+	found := false
+	for _, o := range organizations {
+		found = found || o.ID == acme
+	}
+	if !found {
+		t.Errorf("expected user 1 to be a member of %d, but got %+v", acme, organizations)
+	}
+
+	users, _ := testRepo.ForOrganization(acme).AllUsers()
+	if len(users) != 1 || users[0].ID != 1 {
+		t.Errorf("expected only user 1 in the organization, but got %d users", len(users))
+	}
+
+	_, err = testRepo.ForOrganization(globex).GetUser(1)
+	if err == nil {
+		t.Error("expected a user of another organization not to be found")
+	}
+	err = testRepo.ForOrganization(globex).SetUserStatus(1, data.UserSuspended)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected suspending a user of another organization to find no rows, but got %v", err)
+	}
+	err = testRepo.ForOrganization(globex).AssignUserRole(1, data.RoleViewer)
+	if err == nil {
+		t.Error("expected assigning a role to a non-member to fail")
+	}
+
+	// reads in one unit of work still see only what their own repository may
+	err = testRepo.WithTx(context.Background(), func(repo repository.DatabaseRepo) error {
+		if _, err := repo.ForOrganization(acme).GetUser(1); err != nil {
+			return err
+		}
+		if _, err := repo.ForOrganization(globex).GetUser(1); err == nil {
+			t.Error("expected a user of another organization not to be found in a unit of work")
+		}
+		// a nested unit that rolls back takes the scope it set along
+		_ = repo.ForOrganization(acme).WithTx(context.Background(), func(repo repository.DatabaseRepo) error {
+			_, _ = repo.GetUser(1)
+			return errors.New("failed")
+		})
+		if _, err := repo.ForOrganization(acme).GetUser(1); err != nil {
+			t.Error("expected to find the member after a nested unit rolled back", err)
+		}
+		_, err := repo.GetUser(1)
+		return err
+	})
+	if err != nil {
+		t.Error("reading in a unit of work failed", err)
+	}
+
+	err = testRepo.AssignUserRole(1, data.RoleViewer)
+	if err == nil {
+		t.Error("expected assigning a role without an organization to fail")
+	}
+
+	_ = testRepo.ForOrganization(acme).AssignUserRole(1, data.RoleViewer)
+	if err = testRepo.RemoveOrganizationMember(acme, 1); err != nil {
+		t.Error("removing member failed", err)
+	}
+	roles, _ := testRepo.ForOrganization(acme).GetUserRoles(1)
+	if len(roles) != 0 {
+		t.Errorf("expected the roles to go with the membership, but got %v", roles)
+	}
+}
+
+func TestPostgresDBRepo_Invitations(t *testing.T) {
+	organizationID, err := testRepo.InsertOrganization(data.Organization{Name: "Invites", Slug: "invites"})
+	if err != nil {
+		t.Fatal("inserting organization failed", err)
+	}
+
+	invitation := data.Invitation{
+		OrganizationID: organizationID,
+		Email:          "invitee@example.com",
+		Role:           data.RoleViewer,
+		TokenHash:      data.HashClientSecret("invite-token"),
+		ExpiresAt:      time.Now().Add(time.Hour),
+	}
+	_, err = testRepo.InsertInvitation(invitation)
+	if err != nil {
+		t.Fatal("inserting invitation failed", err)
+	}
+
+	expired := invitation
+	expired.TokenHash = data.HashClientSecret("expired-token")
+	expired.ExpiresAt = time.Now().Add(-time.Hour)
+	_, _ = testRepo.InsertInvitation(expired)
+
+	unknownRole := invitation
+	unknownRole.TokenHash = data.HashClientSecret("owner-token")
+	unknownRole.Role = "owner"
+	if _, err = testRepo.InsertInvitation(unknownRole); err == nil {
+		t.Error("expected an invitation with an unknown role to be refused")
+	}
+
+	got, err := testRepo.GetInvitation(invitation.TokenHash)
+	if err != nil {
+		t.Fatal("getting invitation failed", err)
+	}
+	if got.OrganizationName != "Invites" || got.Role != data.RoleViewer || got.InvitedBy != 0 {
+		t.Errorf("unexpected invitation %+v", got)
+	}
+	if _, err = testRepo.GetInvitation(expired.TokenHash); err == nil {
+		t.Error("expected an expired invitation not to be found")
+	}
+
+	pending, _ := testRepo.PendingInvitations(organizationID)
+	if len(pending) != 1 {
+		t.Errorf("expected 1 pending invitation, but got %d", len(pending))
+	}
+
+	if _, err = testRepo.ConsumeInvitation(invitation.TokenHash); err != nil {
+		t.Error("consuming invitation failed", err)
+	}
+	if _, err = testRepo.ConsumeInvitation(invitation.TokenHash); err == nil {
+		t.Error("expected an invitation to only be accepted once")
+	}
+	if err = testRepo.RevokeInvitation(organizationID, got.ID); err == nil {
+		t.Error("expected an accepted invitation not to be revocable")
+	}
+
+	another := invitation
+	another.TokenHash = data.HashClientSecret("another-token")
+	id, _ := testRepo.InsertInvitation(another)
+	if err = testRepo.RevokeInvitation(organizationID+1, id); err == nil {
+		t.Error("expected revoking another organization's invitation to fail")
+	}
+	if err = testRepo.RevokeInvitation(organizationID, id); err != nil {
+		t.Error("revoking invitation failed", err)
+	}
+	if _, err = testRepo.GetInvitation(another.TokenHash); err == nil {
+		t.Error("expected a revoked invitation not to be found")
+	}
+}
+
+func TestPostgresDBRepo_Export(t *testing.T) {
+	id, err := testRepo.InsertUser(data.User{
+		FirstName: "Erase",
+		LastName:  "Me",
+		Email:     "erase@example.com",
+		Password:  "secret",
+	})
+	if err != nil {
+		t.Fatal("inserting user failed", err)
+	}
+	_, _ = testRepo.InsertUserImage(data.UserImage{UserID: id, FileName: "erase.png"})
+	_, _ = testRepo.InsertUserIdentity(data.UserIdentity{UserID: id, Issuer: "https://accounts.example.com", Subject: "erase"})
+
+	token := data.UserToken{
+		UserID:    id,
+		TokenHash: data.HashClientSecret("export-token"),
+		Purpose:   data.TokenDataExport,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	_, _ = testRepo.InsertUserToken(token)
+
+	// an export link can be used more than once until it expires
+	for i := 0; i < 2; i++ {
+		got, err := testRepo.GetUserToken(token.TokenHash, data.TokenDataExport)
+		if err != nil || got.UserID != id {
+			t.Fatalf("getting export token failed: %v %v", got, err)
+		}
+	}
+	if _, err = testRepo.GetUserToken(token.TokenHash, data.TokenMagicLink); err == nil {
+		t.Error("expected a token not to be found for another purpose")
+	}
+
+	image, err := testRepo.GetUserImage(id)
+	if err != nil || image.FileName != "erase.png" {
+		t.Errorf("unexpected user image %v %v", image, err)
+	}
+	identities, err := testRepo.GetUserIdentities(id)
+	if err != nil || len(identities) != 1 {
+		t.Errorf("expected 1 identity, but got %d (%v)", len(identities), err)
+	}
+
+	if err = testRepo.EraseUser(id); err != nil {
+		t.Fatal("erasing user failed", err)
+	}
+	if _, err = testRepo.GetUser(id); err == nil {
+		t.Error("expected an erased user to be gone")
+	}
+	if _, err = testRepo.GetUserImage(id); err == nil {
+		t.Error("expected the image of an erased user to be gone")
+	}
+	if _, err = testRepo.GetUserToken(token.TokenHash, data.TokenDataExport); err == nil {
+		t.Error("expected the tokens of an erased user to be gone")
+	}
+}
+
+func TestPostgresDBRepo_ImportUsers(t *testing.T) {
+	organizationID, err := testRepo.InsertOrganization(data.Organization{Name: "Imports", Slug: "imports"})
+	if err != nil {
+		t.Fatal("inserting organization failed", err)
+	}
+	orgRepo := testRepo.ForOrganization(organizationID)
+
+	users := []data.ImportedUser{
+		{User: data.User{FirstName: "Jane", LastName: "Import", Email: "jane.import@example.com", Password: "secret"}, Role: data.RoleAdmin},
+		{User: data.User{FirstName: "John", LastName: "Import", Email: "john.import@example.com", Password: "secret"}, Role: data.RoleViewer},
+	}
+	ids, err := orgRepo.ImportUsers(users)
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("importing users failed: %v %v", ids, err)
+	}
+
+	members, _ := orgRepo.AllUsers()
+	if len(members) != 2 {
+		t.Errorf("expected 2 members, but got %d", len(members))
+	}
+	roles, _ := orgRepo.GetUserRoles(ids[0])
+	if len(roles) != 1 || roles[0] != data.RoleAdmin {
+		t.Errorf("expected the admin role, but got %v", roles)
+	}
+
+	// a duplicate rolls back the whole transaction
+	again := []data.ImportedUser{
+		{User: data.User{FirstName: "New", LastName: "Import", Email: "new.import@example.com", Password: "secret"}},
+		users[0],
+	}
+	if _, err = orgRepo.ImportUsers(again); err == nil {
+		t.Error("expected importing an existing email to fail")
+	}
+	if _, err = testRepo.GetUserByEmail("new.import@example.com"); err == nil {
+		t.Error("expected nothing to be imported when one user fails")
+	}
+}
+
+func TestPostgresDBRepo_WithTx(t *testing.T) {
+	ctx := context.Background()
+	insert := func(repo repository.DatabaseRepo, email string) error {
+		_, err := repo.InsertUser(data.User{FirstName: "Tx", LastName: "User", Email: email, Password: "secret"})
+		return err
+	}
+	exists := func(email string) bool {
+		_, err := testRepo.GetUserByEmail(email)
+		return err == nil
+	}
+
+	err := testRepo.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		return insert(repo, "committed@example.com")
+	})
+	if err != nil || !exists("committed@example.com") {
+		t.Errorf("expected the unit of work to commit: %v", err)
+	}
+
+	failed := errors.New("failed")
+	err = testRepo.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		if err := insert(repo, "rolledback@example.com"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed || exists("rolledback@example.com") {
+		t.Errorf("expected the unit of work to roll back: %v", err)
+	}
+
+	// a failing nested unit only undoes its own part
+	err = testRepo.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		if err := insert(repo, "outer@example.com"); err != nil {
+			return err
+		}
+		nested := repo.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+			if err := insert(repo, "inner@example.com"); err != nil {
+				return err
+			}
+			return insert(repo, "outer@example.com")
+		})
+		if nested == nil {
+			t.Error("expected the nested unit to fail on the duplicate email")
+		}
+		return nil
+	})
+	if err != nil || !exists("outer@example.com") || exists("inner@example.com") {
+		t.Errorf("expected only the nested unit to roll back: %v", err)
+	}
+
+	attempts := 0
+	err = testRepo.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		attempts++
+		if attempts < 2 {
+			return &pgconn.PgError{Code: "40001"}
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("expected a serialization failure to be retried once, but got %d attempts (%v)", attempts, err)
+	}
+}
+
+func TestPostgresDBRepo_ResetPasswordInvalidatesTokens(t *testing.T) {
+	token := data.UserToken{
+		UserID:    1,
+		TokenHash: data.HashClientSecret("reset-token"),
+		Purpose:   data.TokenMagicLink,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	_, _ = testRepo.InsertUserToken(token)
+
+	if err := testRepo.ResetPassword(1, "verysecret"); err != nil {
+		t.Fatal("resetting password failed", err)
+	}
+	if _, err := testRepo.ConsumeUserToken(token.TokenHash, data.TokenMagicLink); err == nil {
+		t.Error("expected tokens issued before the reset to stop working")
+	}
+}
+
+func TestPostgresDBRepo_SetPasswordHash(t *testing.T) {
+	id, err := testRepo.InsertUser(data.User{FirstName: "Hash", LastName: "User", Email: "hash@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal("inserting user failed", err)
+	}
+
+	user, _ := testRepo.GetUser(id)
+	if !strings.HasPrefix(user.Password, "$argon2id$") {
+		t.Errorf("expected an argon2id hash, but got %s", user.Password)
+	}
+
+	bcryptHash, _ := passwords.Policy{Algorithm: passwords.Bcrypt, BcryptCost: 4}.Hash("secret")
+	if err = testRepo.SetPasswordHash(id, bcryptHash); err != nil {
+		t.Fatal("setting password hash failed", err)
+	}
+
+	user, _ = testRepo.GetUser(id)
+	if ok, _ := user.PasswordMatches("secret"); !ok || user.Password != bcryptHash {
+		t.Error("expected the new hash to be stored")
+	}
+}
+
+func TestPostgresDBRepo_PasswordHistory(t *testing.T) {
+	id, err := testRepo.InsertUser(data.User{FirstName: "History", LastName: "User", Email: "history@example.com", Password: "first secret"})
+	if err != nil {
+		t.Fatal("inserting user failed", err)
+	}
+	_ = testRepo.ResetPassword(id, "second secret")
+	_ = testRepo.ResetPassword(id, "third secret")
+
+	hashes, err := testRepo.PasswordHistory(id, 2)
+	if err != nil {
+		t.Fatal("getting password history failed", err)
+	}
+	if len(hashes) != 2 {
+		t.Fatalf("expected 2 hashes, but got %d", len(hashes))
+	}
+	if ok, _ := passwords.Verify("third secret", hashes[0]); !ok {
+		t.Error("expected the current password first")
+	}
+	if !passwords.Reused("second secret", hashes) || passwords.Reused("first secret", hashes) {
+		t.Error("expected only the 2 latest passwords in the history")
+	}
+}
+
+func TestPostgresDBRepo_RowLevelSecurityFailsClosed(t *testing.T) {
+	var total int
+	if err := testDB.QueryRow(`select count(*) from users`).Scan(&total); err != nil || total == 0 {
+		t.Fatalf("expected users in the database, but got %d, %v", total, err)
+	}
+
+	var n int
+	if err := appDB.QueryRow(`select count(*) from users`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("expected a connection without a scope to see no users, but it saw %d", n)
+	}
+
+	if err := appDB.QueryRow(`select count(*) from user_roles`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("expected a connection without a scope to see no roles, but it saw %d", n)
+	}
+
+	users, err := testRepo.AllUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != total {
+		t.Errorf("expected an unscoped repository to see all %d users, but it saw %d", total, len(users))
+	}
 }
@@ -1,13 +1,68 @@
 package dbrepo
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"slices"
+	"strings"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/totp"
 )
 
-type TestDBRepo struct{}
+type TestDBRepo struct {
+	organization *int
+}
+
+// testMembers are the members of the test organizations, 1 (Acme) and 2 (Globex).
+// The admin is an admin in Acme and a viewer in Globex.
+var testMembers = map[int][]int{1: {1, 2, 3, 4, 5}, 2: {1}}
+
+// ForOrganization returns a repository that only sees the members of an organization.
+func (m *TestDBRepo) ForOrganization(id int) repository.DatabaseRepo {
+	return &TestDBRepo{organization: &id}
+}
+
+// WithTx runs fn with the repository itself; there is nothing to roll back.
+func (m *TestDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return fn(m)
+}
+
+func (m *TestDBRepo) inOrganization(userID int) bool {
+	return m.organization == nil || slices.Contains(testMembers[*m.organization], userID)
+}
+
+// TestTOTPSecret and TestRecoveryCode belong to the 2FA enrolled test user, totp@example.com.
+const (
+	TestTOTPSecret   = "JBSWY3DPEHPK3PXP"
+	TestRecoveryCode = "aaaaa-bbbbb"
+)
+
+// The registered test OAuth clients: a confidential one with TestOAuthClientSecret, and a
+// public one. Both redirect to TestOAuthRedirectURI.
+const (
+	TestOAuthClientSecret = "test-secret"
+	TestOAuthRedirectURI  = "https://client.example.com/callback"
+)
+
+// TestAuthorizationCode is the only code ConsumeAuthorizationCode accepts. It was issued
+// to test-client for user 1 in organization 1, with the PKCE verifier TestCodeVerifier.
+const (
+	TestAuthorizationCode = "valid-code"
+	TestCodeVerifier      = "test-verifier-test-verifier-test-verifier-1234"
+)
+
+// TestUserTokenHash is the hash of the only token ConsumeUserToken accepts, "valid-token".
+const TestUserTokenHash = "397a2a9c5bf5e2ccec38c2596b682bb1bd05fe6e4ecea6c10cf42755ff225403"
+
+// TestInvitationToken is the token of the only pending invitation, for new@example.com
+// to join organization 1 as a viewer.
+const TestInvitationToken = "valid-invitation"
 
 func (m *TestDBRepo) Connection() *sql.DB {
 	return nil
@@ -15,11 +70,31 @@ func (m *TestDBRepo) Connection() *sql.DB {
 
 func (m *TestDBRepo) AllUsers() ([]*data.User, error) {
 	var users []*data.User
+	for _, id := range []int{1, 3} {
+		if user, err := m.GetUser(id); err == nil {
+			users = append(users, user)
+		}
+	}
 	return users, nil
 }
 
+// EachUser calls fn with the users of AllUsers, one at a time.
+func (m *TestDBRepo) EachUser(ctx context.Context, fn func(u *data.User) error) error {
+	users, _ := m.AllUsers()
+	for _, u := range users {
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetUser returns one user by id
 func (m *TestDBRepo) GetUser(id int) (*data.User, error) {
+	if !m.inOrganization(id) {
+		return nil, sql.ErrNoRows
+	}
+
 	var user = data.User{}
 	if id == 1 {
 		user = data.User{
@@ -27,14 +102,50 @@ func (m *TestDBRepo) GetUser(id int) (*data.User, error) {
 			FirstName: "Admin",
 			LastName:  "User",
 			Email:     "admin@example.com",
+			Password:  "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+			Status:    data.UserActive,
 		}
 		return &user, nil
 	}
-	return nil, errors.New("user not found")
+	if id == 3 {
+		user = data.User{
+			ID:          3,
+			FirstName:   "Totp",
+			LastName:    "User",
+			Email:       "totp@example.com",
+			TOTPEnabled: true,
+			Status:      data.UserActive,
+		}
+		return &user, nil
+	}
+	if id == 2 {
+		// the user InsertUser pretends to create; they aren't in any organization
+		user = data.User{
+			ID:        2,
+			FirstName: "New",
+			LastName:  "User",
+			Email:     "new@example.com",
+			Status:    data.UserActive,
+		}
+		return &user, nil
+	}
+	if id == 4 || id == 5 {
+		return testUserByEmail(map[int]string{4: "suspended@example.com", 5: "deleted@example.com"}[id])
+	}
+	return nil, sql.ErrNoRows
 }
 
 // GetUserByEmail returns one user by email address
 func (m *TestDBRepo) GetUserByEmail(email string) (*data.User, error) {
+	user, err := testUserByEmail(email)
+	if err != nil || !m.inOrganization(user.ID) {
+		return nil, errors.New("not found")
+	}
+	return user, nil
+}
+
+func testUserByEmail(email string) (*data.User, error) {
+	email = strings.ToLower(email)
 	if email == "admin@example.com" {
 		user := data.User{
 			ID:        1,
@@ -42,7 +153,49 @@ func (m *TestDBRepo) GetUserByEmail(email string) (*data.User, error) {
 			LastName:  "User",
 			Email:     "admin@example.com",
 			Password:  "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
-			IsAdmin:   1,
+			Status:    data.UserActive,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		return &user, nil
+	}
+	if email == "totp@example.com" {
+		user := data.User{
+			ID:          3,
+			FirstName:   "Totp",
+			LastName:    "User",
+			Email:       "totp@example.com",
+			Password:    "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+			TOTPEnabled: true,
+			Status:      data.UserActive,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		}
+		return &user, nil
+	}
+	if email == "suspended@example.com" {
+		user := data.User{
+			ID:        4,
+			FirstName: "Suspended",
+			LastName:  "User",
+			Email:     "suspended@example.com",
+			Password:  "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+			Status:    data.UserSuspended,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		return &user, nil
+	}
+	if email == "deleted@example.com" {
+		deletedAt := time.Now().Add(-24 * time.Hour)
+		user := data.User{
+			ID:        5,
+			FirstName: "Deleted",
+			LastName:  "User",
+			Email:     "deleted@example.com",
+			Password:  "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+			Status:    data.UserDeleted,
+			DeletedAt: &deletedAt,
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		}
@@ -54,14 +207,42 @@ func (m *TestDBRepo) GetUserByEmail(email string) (*data.User, error) {
 
 // UpdateUser updates one user in the database
 func (m *TestDBRepo) UpdateUser(u data.User) error {
-	if u.ID == 1 {
+	if u.ID == 1 && m.inOrganization(u.ID) {
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+// SetUserStatus activates, suspends, or deletes a user.
+func (m *TestDBRepo) SetUserStatus(id int, status string) error {
+	if (id == 1 || id == 3 || id == 4 || id == 5) && m.inOrganization(id) {
 		return nil
 	}
-	return errors.New("update failed, no user found")
+	return sql.ErrNoRows
 }
 
-// DeleteUser deletes one user from the database, by id
+// DeleteUser marks one user as deleted, by id
 func (m *TestDBRepo) DeleteUser(id int) error {
+	if !m.inOrganization(id) {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PurgeDeletedUsers removes the users deleted before a point in time, and returns
+// them. The deleted test user was deleted a day ago.
+func (m *TestDBRepo) PurgeDeletedUsers(before time.Time) ([]*data.User, error) {
+	if before.After(time.Now().Add(-24 * time.Hour)) {
+		return []*data.User{{ID: 5, Email: "deleted@example.com", ProfilePic: data.UserImage{FileName: testImages[5]}}}, nil
+	}
+	return nil, nil
+}
+
+// EraseUser removes a user and everything hanging off them straight away
+func (m *TestDBRepo) EraseUser(id int) error {
+	if !m.inOrganization(id) {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
@@ -70,6 +251,15 @@ func (m *TestDBRepo) InsertUser(user data.User) (int, error) {
 	return 2, nil
 }
 
+// ImportUsers pretends to insert users, numbering them from 100.
+func (m *TestDBRepo) ImportUsers(users []data.ImportedUser) ([]int, error) {
+	ids := make([]int, len(users))
+	for i := range users {
+		ids[i] = 100 + i
+	}
+	return ids, nil
+}
+
 // ResetPassword is the method we will use to change a user's password.
 func (m *TestDBRepo) ResetPassword(id int, password string) error {
 	return nil
@@ -79,3 +269,351 @@ func (m *TestDBRepo) ResetPassword(id int, password string) error {
 func (m *TestDBRepo) InsertUserImage(i data.UserImage) (int, error) {
 	return 1, nil
 }
+
+// testImages are the file names of the test users' profile images. The suspended and
+// the deleted user uploaded files by the same name.
+var testImages = map[int]string{1: "img.png", 4: "shared.png", 5: "shared.png"}
+
+// GetUserImage returns the metadata of a user's profile image.
+func (m *TestDBRepo) GetUserImage(userID int) (*data.UserImage, error) {
+	if name, ok := testImages[userID]; ok {
+		return &data.UserImage{ID: userID, UserID: userID, FileName: name, CreatedAt: time.Now(), UpdatedAt: time.Now()}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ImageInUse reports whether a user other than exceptUserID has a profile image by
+// that file name.
+func (m *TestDBRepo) ImageInUse(fileName string, exceptUserID int) (bool, error) {
+	for id, name := range testImages {
+		if name == fileName && id != exceptUserID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// EnableUserTOTP stores a confirmed authenticator secret for a user.
+func (m *TestDBRepo) EnableUserTOTP(id int, secret string, step int64, recoveryCodeHashes []string) error {
+	return nil
+}
+
+// GetTOTPSecret returns the secret of a user's authenticator. Only the 2FA test user
+// has one.
+func (m *TestDBRepo) GetTOTPSecret(userID int) (string, error) {
+	if userID == 3 {
+		return TestTOTPSecret, nil
+	}
+	return "", sql.ErrNoRows
+}
+
+// testTOTPSteps are the latest time steps UseTOTPStep accepted, by user.
+var testTOTPSteps = map[int]int64{}
+
+// UseTOTPStep records that a user logged in with the code of a time step, unless they
+// already used that step or a later one.
+func (m *TestDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	if step <= testTOTPSteps[userID] {
+		return false, nil
+	}
+	testTOTPSteps[userID] = step
+	return true, nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used.
+func (m *TestDBRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	return userID == 3 && codeHash == totp.HashRecoveryCode(TestRecoveryCode), nil
+}
+
+// InsertUserToken stores the hash of a single-use token.
+func (m *TestDBRepo) InsertUserToken(t data.UserToken) (int, error) {
+	return 1, nil
+}
+
+// GetUserToken returns an unused, unexpired token without using it up.
+func (m *TestDBRepo) GetUserToken(tokenHash, purpose string) (*data.UserToken, error) {
+	return m.ConsumeUserToken(tokenHash, purpose)
+}
+
+// ConsumeUserToken marks an unused, unexpired token as used and returns it.
+func (m *TestDBRepo) ConsumeUserToken(tokenHash, purpose string) (*data.UserToken, error) {
+	if tokenHash == TestUserTokenHash {
+		return &data.UserToken{ID: 1, UserID: 1, TokenHash: tokenHash, Purpose: purpose, ExpiresAt: time.Now().Add(time.Minute)}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// SetPasswordHash replaces a user's password hash, keeping the password.
+func (m *TestDBRepo) SetPasswordHash(id int, hash string) error {
+	return nil
+}
+
+// PasswordHistory returns the hashes of a user's n latest passwords, the current one
+// first.
+func (m *TestDBRepo) PasswordHistory(userID, n int) ([]string, error) {
+	if userID != 1 || n == 0 {
+		return nil, nil
+	}
+	return []string{"$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK"}, nil
+}
+
+// InvalidateUserTokens uses up all of a user's unused tokens.
+func (m *TestDBRepo) InvalidateUserTokens(userID int) error {
+	return nil
+}
+
+// InsertUserTokenLimited stores a token unless the user reached the limit. The 2FA
+// test user has used up every allowance.
+func (m *TestDBRepo) InsertUserTokenLimited(t data.UserToken, limit int, since time.Time) (bool, error) {
+	return t.UserID != 3, nil
+}
+
+// GetUserByIdentity returns the user linked to an external provider's subject.
+func (m *TestDBRepo) GetUserByIdentity(issuer, subject string) (*data.User, error) {
+	if subject == "linked-subject" {
+		return m.GetUser(1)
+	}
+	return nil, sql.ErrNoRows
+}
+
+// testIdentities are the identities InsertUserIdentity linked, by user.
+var testIdentities = map[int][]*data.UserIdentity{}
+
+// InsertUserIdentity links a user to an external provider's subject.
+func (m *TestDBRepo) InsertUserIdentity(i data.UserIdentity) (int, error) {
+	i.ID = len(testIdentities[i.UserID]) + 2
+	testIdentities[i.UserID] = append(testIdentities[i.UserID], &i)
+	return i.ID, nil
+}
+
+// GetUserIdentities returns the external provider accounts linked to a user
+func (m *TestDBRepo) GetUserIdentities(userID int) ([]*data.UserIdentity, error) {
+	if userID == 1 {
+		return append([]*data.UserIdentity{{ID: 1, UserID: 1, Issuer: "https://sso.example.com", Subject: "linked-subject", Email: "admin@example.com"}}, testIdentities[1]...), nil
+	}
+	return testIdentities[userID], nil
+}
+
+// AllOAuthClients returns every registered OAuth client
+func (m *TestDBRepo) AllOAuthClients() ([]*data.OAuthClient, error) {
+	confidential, _ := m.GetOAuthClient("test-client")
+	public, _ := m.GetOAuthClient("public-client")
+	return []*data.OAuthClient{confidential, public}, nil
+}
+
+// GetOAuthClient returns one OAuth client by its public client id
+func (m *TestDBRepo) GetOAuthClient(clientID string) (*data.OAuthClient, error) {
+	switch clientID {
+	case "test-client":
+		return &data.OAuthClient{
+			ID:           1,
+			ClientID:     clientID,
+			SecretHash:   data.HashClientSecret(TestOAuthClientSecret),
+			Name:         "Test Client",
+			RedirectURIs: []string{TestOAuthRedirectURI},
+		}, nil
+	case "public-client":
+		return &data.OAuthClient{
+			ID:           2,
+			ClientID:     clientID,
+			Name:         "Public Client",
+			RedirectURIs: []string{TestOAuthRedirectURI},
+		}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// InsertOAuthClient registers an OAuth client
+func (m *TestDBRepo) InsertOAuthClient(c data.OAuthClient) (int, error) {
+	return 3, nil
+}
+
+// DeleteOAuthClient removes an OAuth client
+func (m *TestDBRepo) DeleteOAuthClient(clientID string) error {
+	return nil
+}
+
+// InsertAuthorizationCode stores the hash of an issued authorization code.
+func (m *TestDBRepo) InsertAuthorizationCode(c data.AuthorizationCode) error {
+	return nil
+}
+
+// ConsumeAuthorizationCode marks an unused, unexpired code as used and returns it.
+func (m *TestDBRepo) ConsumeAuthorizationCode(codeHash string) (*data.AuthorizationCode, error) {
+	sum := sha256.Sum256([]byte(TestAuthorizationCode))
+	if codeHash != hex.EncodeToString(sum[:]) {
+		return nil, sql.ErrNoRows
+	}
+
+	challenge := sha256.Sum256([]byte(TestCodeVerifier))
+	return &data.AuthorizationCode{
+		CodeHash:       codeHash,
+		ClientID:       "test-client",
+		UserID:         1,
+		RedirectURI:    TestOAuthRedirectURI,
+		Scope:          "openid profile email",
+		Nonce:          "test-nonce",
+		CodeChallenge:  base64.RawURLEncoding.EncodeToString(challenge[:]),
+		ExpiresAt:      time.Now().Add(time.Minute),
+		OrganizationID: 1,
+	}, nil
+}
+
+// AllRoles returns all roles with the names of their permissions
+func (m *TestDBRepo) AllRoles() ([]*data.Role, error) {
+	return []*data.Role{
+		{ID: 1, Name: data.RoleAdmin, Permissions: []string{data.PermissionRolesWrite, data.PermissionUsersRead, data.PermissionUsersWrite}},
+		{ID: 2, Name: data.RoleViewer, Permissions: []string{data.PermissionUsersRead}},
+	}, nil
+}
+
+// InsertRole inserts a role with its permissions, and returns the new role's id
+func (m *TestDBRepo) InsertRole(r data.Role) (int, error) {
+	return 3, nil
+}
+
+// GrantPermission adds a permission to a role.
+func (m *TestDBRepo) GrantPermission(role, permission string) error {
+	return nil
+}
+
+// RevokePermission takes a permission away from a role.
+func (m *TestDBRepo) RevokePermission(role, permission string) error {
+	return nil
+}
+
+// GetUserRoles returns the names of a user's roles. Only user 1 has any: admin in
+// organization 1 and viewer in organization 2.
+func (m *TestDBRepo) GetUserRoles(userID int) ([]string, error) {
+	if userID != 1 {
+		return nil, nil
+	}
+	switch {
+	case m.organization == nil:
+		return []string{data.RoleAdmin, data.RoleViewer}, nil
+	case *m.organization == 1:
+		return []string{data.RoleAdmin}, nil
+	case *m.organization == 2:
+		return []string{data.RoleViewer}, nil
+	}
+	return nil, nil
+}
+
+// GetUserPermissions returns the names of all permissions a user has through their roles
+func (m *TestDBRepo) GetUserPermissions(userID int) ([]string, error) {
+	roles, _ := m.GetUserRoles(userID)
+	if slices.Contains(roles, data.RoleAdmin) {
+		return []string{data.PermissionRolesWrite, data.PermissionUsersRead, data.PermissionUsersWrite}, nil
+	}
+	if slices.Contains(roles, data.RoleViewer) {
+		return []string{data.PermissionUsersRead}, nil
+	}
+	return nil, nil
+}
+
+// AssignUserRole gives a member of the repository's organization a role there.
+func (m *TestDBRepo) AssignUserRole(userID int, role string) error {
+	if m.organization == nil || !m.inOrganization(userID) {
+		return errors.New("not a member of the organization")
+	}
+	if role != data.RoleAdmin && role != data.RoleViewer {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RemoveUserRole takes a role away from a user.
+func (m *TestDBRepo) RemoveUserRole(userID int, role string) error {
+	return nil
+}
+
+// InsertOrganization inserts an organization, and returns its id
+func (m *TestDBRepo) InsertOrganization(o data.Organization) (int, error) {
+	return 3, nil
+}
+
+func testOrganizations() []*data.Organization {
+	return []*data.Organization{{ID: 1, Name: "Acme", Slug: "acme"}, {ID: 2, Name: "Globex", Slug: "globex"}}
+}
+
+// GetOrganization returns one organization by id
+func (m *TestDBRepo) GetOrganization(id int) (*data.Organization, error) {
+	for _, o := range testOrganizations() {
+		if o.ID == id {
+			return o, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// GetUserOrganizations returns the organizations a user is a member of
+func (m *TestDBRepo) GetUserOrganizations(userID int) ([]*data.Organization, error) {
+	var organizations []*data.Organization
+	for _, o := range testOrganizations() {
+		if slices.Contains(testMembers[o.ID], userID) {
+			organizations = append(organizations, o)
+		}
+	}
+	return organizations, nil
+}
+
+// AddOrganizationMember makes a user a member of an organization.
+func (m *TestDBRepo) AddOrganizationMember(organizationID, userID int) error {
+	return nil
+}
+
+// RemoveOrganizationMember takes a user out of an organization, with their roles there.
+func (m *TestDBRepo) RemoveOrganizationMember(organizationID, userID int) error {
+	return nil
+}
+
+// InsertInvitation stores an invitation, and returns its id
+func (m *TestDBRepo) InsertInvitation(i data.Invitation) (int, error) {
+	if i.Role != "" && i.Role != data.RoleAdmin && i.Role != data.RoleViewer {
+		return 0, sql.ErrNoRows
+	}
+	return 2, nil
+}
+
+func testInvitation(tokenHash string) (*data.Invitation, error) {
+	if tokenHash != data.HashClientSecret(TestInvitationToken) {
+		return nil, sql.ErrNoRows
+	}
+	return &data.Invitation{
+		ID:               1,
+		OrganizationID:   1,
+		OrganizationName: "Acme",
+		Email:            "new@example.com",
+		Role:             data.RoleViewer,
+		InvitedBy:        1,
+		TokenHash:        tokenHash,
+		ExpiresAt:        time.Now().Add(data.InvitationLifetime),
+	}, nil
+}
+
+// GetInvitation returns a pending invitation
+func (m *TestDBRepo) GetInvitation(tokenHash string) (*data.Invitation, error) {
+	return testInvitation(tokenHash)
+}
+
+// ConsumeInvitation marks a pending invitation as accepted and returns it
+func (m *TestDBRepo) ConsumeInvitation(tokenHash string) (*data.Invitation, error) {
+	return testInvitation(tokenHash)
+}
+
+// PendingInvitations returns the pending invitations of an organization
+func (m *TestDBRepo) PendingInvitations(organizationID int) ([]*data.Invitation, error) {
+	if organizationID != 1 {
+		return nil, nil
+	}
+	i, _ := testInvitation(data.HashClientSecret(TestInvitationToken))
+	return []*data.Invitation{i}, nil
+}
+
+// RevokeInvitation withdraws a pending invitation of an organization
+func (m *TestDBRepo) RevokeInvitation(organizationID, id int) error {
+	if organizationID != 1 || id != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -1,18 +1,74 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"time"
 	"webapp/pkg/data"
 )
 
+// ErrEmailTaken is returned when a user would get an email address another user has,
+// whatever its case.
+var ErrEmailTaken = errors.New("email address is taken")
+
 type DatabaseRepo interface {
 	Connection() *sql.DB
+	ForOrganization(id int) DatabaseRepo
+	// WithTx runs fn as a unit of work: everything done through the repository it is
+	// passed commits together, or rolls back when fn returns an error.
+	WithTx(ctx context.Context, fn func(repo DatabaseRepo) error) error
 	AllUsers() ([]*data.User, error)
+	EachUser(ctx context.Context, fn func(u *data.User) error) error
 	GetUser(id int) (*data.User, error)
 	GetUserByEmail(email string) (*data.User, error)
 	UpdateUser(u data.User) error
+	SetUserStatus(id int, status string) error
 	DeleteUser(id int) error
+	PurgeDeletedUsers(before time.Time) ([]*data.User, error)
+	EraseUser(id int) error
 	InsertUser(user data.User) (int, error)
+	ImportUsers(users []data.ImportedUser) ([]int, error)
 	ResetPassword(id int, password string) error
+	SetPasswordHash(id int, hash string) error
+	PasswordHistory(userID, n int) ([]string, error)
 	InsertUserImage(i data.UserImage) (int, error)
+	GetUserImage(userID int) (*data.UserImage, error)
+	ImageInUse(fileName string, exceptUserID int) (bool, error)
+	EnableUserTOTP(id int, secret string, step int64, recoveryCodeHashes []string) error
+	GetTOTPSecret(userID int) (string, error)
+	UseTOTPStep(userID int, step int64) (bool, error)
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	InsertUserToken(t data.UserToken) (int, error)
+	GetUserToken(tokenHash, purpose string) (*data.UserToken, error)
+	ConsumeUserToken(tokenHash, purpose string) (*data.UserToken, error)
+	InvalidateUserTokens(userID int) error
+	InsertUserTokenLimited(t data.UserToken, limit int, since time.Time) (bool, error)
+	GetUserByIdentity(issuer, subject string) (*data.User, error)
+	InsertUserIdentity(i data.UserIdentity) (int, error)
+	GetUserIdentities(userID int) ([]*data.UserIdentity, error)
+	AllOAuthClients() ([]*data.OAuthClient, error)
+	GetOAuthClient(clientID string) (*data.OAuthClient, error)
+	InsertOAuthClient(c data.OAuthClient) (int, error)
+	DeleteOAuthClient(clientID string) error
+	InsertAuthorizationCode(c data.AuthorizationCode) error
+	ConsumeAuthorizationCode(codeHash string) (*data.AuthorizationCode, error)
+	AllRoles() ([]*data.Role, error)
+	InsertRole(r data.Role) (int, error)
+	GrantPermission(role, permission string) error
+	RevokePermission(role, permission string) error
+	GetUserRoles(userID int) ([]string, error)
+	GetUserPermissions(userID int) ([]string, error)
+	AssignUserRole(userID int, role string) error
+	RemoveUserRole(userID int, role string) error
+	InsertOrganization(o data.Organization) (int, error)
+	GetOrganization(id int) (*data.Organization, error)
+	GetUserOrganizations(userID int) ([]*data.Organization, error)
+	AddOrganizationMember(organizationID, userID int) error
+	RemoveOrganizationMember(organizationID, userID int) error
+	InsertInvitation(i data.Invitation) (int, error)
+	GetInvitation(tokenHash string) (*data.Invitation, error)
+	ConsumeInvitation(tokenHash string) (*data.Invitation, error)
+	PendingInvitations(organizationID int) ([]*data.Invitation, error)
+	RevokeInvitation(organizationID, id int) error
 }
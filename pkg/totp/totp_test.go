@@ -0,0 +1,96 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 test vectors from RFC 6238, appendix B, truncated to six digits
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	var tests = []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "287082"},
+		{unix: 1111111109, expected: "081804"},
+		{unix: 1111111111, expected: "050471"},
+		{unix: 1234567890, expected: "005924"},
+		{unix: 2000000000, expected: "279037"},
+	}
+
+	for _, e := range tests {
+		code, err := Code(secret, time.Unix(e.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != e.expected {
+			t.Errorf("at %d: expected %s, but got %s", e.unix, e.expected, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, _ := Code(secret, now)
+
+	if !Validate(secret, code, now) {
+		t.Error("expected the current code to validate")
+	}
+	if !Validate(secret, code, now.Add(Period)) {
+		t.Error("expected the previous step's code to validate")
+	}
+	if Validate(secret, code, now.Add(3*Period)) {
+		t.Error("expected an old code to be rejected")
+	}
+	if Validate(secret, "12345", now) {
+		t.Error("expected a short code to be rejected")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, _ := Code(secret, now)
+
+	step, ok := Match(secret, code, now.Add(Period))
+	if !ok || step != Step(now) {
+		t.Errorf("expected the code to match step %d, but got %d %v", Step(now), step, ok)
+	}
+	if _, ok = Match("", code, now); ok {
+		t.Error("expected an empty secret to match nothing")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("WebApp", "admin@example.com", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/WebApp:admin@example.com?") || !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") {
+		t.Errorf("unexpected uri %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("expected 10 codes, but got %d", len(codes))
+	}
+	if codes[0] == codes[1] {
+		t.Error("expected distinct codes")
+	}
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+strings.ToUpper(codes[0])) {
+		t.Error("expected hashing to ignore case and spaces")
+	}
+}
@@ -0,0 +1,20 @@
+-- Adds two-factor authentication: an authenticator secret per user and one-time
+-- recovery codes. Migrations run in order of their numbers, each once, starting from
+-- a database created with the original sql/users.sql:
+--
+--   psql "$DSN" -f sql/migrations/001_two_factor.sql
+
+BEGIN;
+
+ALTER TABLE public.users ADD COLUMN totp_secret character varying(64);
+ALTER TABLE public.users ADD COLUMN totp_enabled boolean DEFAULT false NOT NULL;
+
+CREATE TABLE public.user_recovery_codes (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    code_hash character varying(64) NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone
+);
+
+COMMIT;
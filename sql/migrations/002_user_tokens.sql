@@ -0,0 +1,18 @@
+-- Adds single-use tokens, such as the ones in magic sign-in links. Only their hashes
+-- are stored.
+--
+--   psql "$DSN" -f sql/migrations/002_user_tokens.sql
+
+BEGIN;
+
+CREATE TABLE public.user_tokens (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    token_hash character(64) NOT NULL UNIQUE,
+    purpose character varying(32) NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone
+);
+
+COMMIT;
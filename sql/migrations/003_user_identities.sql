@@ -0,0 +1,17 @@
+-- Links users to their accounts at external OpenID Connect providers.
+--
+--   psql "$DSN" -f sql/migrations/003_user_identities.sql
+
+BEGIN;
+
+CREATE TABLE public.user_identities (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    issuer character varying(255) NOT NULL,
+    subject character varying(255) NOT NULL,
+    email character varying(255),
+    created_at timestamp without time zone,
+    UNIQUE (issuer, subject)
+);
+
+COMMIT;
@@ -0,0 +1,30 @@
+-- Adds the clients that sign their users in through us, and the authorization codes
+-- issued to them.
+--
+--   psql "$DSN" -f sql/migrations/004_oauth.sql
+
+BEGIN;
+
+CREATE TABLE public.oauth_clients (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    client_id character varying(64) NOT NULL UNIQUE,
+    secret_hash character varying(64) DEFAULT ''::character varying NOT NULL,
+    name character varying(255) NOT NULL,
+    redirect_uris text NOT NULL,
+    created_at timestamp without time zone
+);
+
+CREATE TABLE public.oauth_authorization_codes (
+    code_hash character(64) PRIMARY KEY,
+    client_id character varying(64) NOT NULL REFERENCES public.oauth_clients(client_id) ON UPDATE CASCADE ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    redirect_uri text NOT NULL,
+    scope character varying(255) NOT NULL,
+    nonce character varying(255) DEFAULT ''::character varying NOT NULL,
+    code_challenge character varying(128) NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone
+);
+
+COMMIT;
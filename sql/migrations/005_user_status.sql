@@ -0,0 +1,9 @@
+-- Adds the account status directories provision: active or suspended users.
+--
+--   psql "$DSN" -f sql/migrations/005_user_status.sql
+
+BEGIN;
+
+ALTER TABLE public.users ADD COLUMN status character varying(16) DEFAULT 'active'::character varying NOT NULL;
+
+COMMIT;
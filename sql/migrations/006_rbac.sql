@@ -0,0 +1,53 @@
+-- Replaces users.is_admin with roles and permissions. Users with is_admin = 1 get
+-- the admin role.
+--
+--   psql "$DSN" -f sql/migrations/006_rbac.sql
+
+BEGIN;
+
+CREATE TABLE public.roles (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name character varying(64) NOT NULL UNIQUE,
+    description character varying(255) DEFAULT ''::character varying NOT NULL,
+    created_at timestamp without time zone
+);
+
+CREATE TABLE public.permissions (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name character varying(64) NOT NULL UNIQUE,
+    description character varying(255) DEFAULT ''::character varying NOT NULL
+);
+
+CREATE TABLE public.role_permissions (
+    role_id integer NOT NULL REFERENCES public.roles(id) ON DELETE CASCADE,
+    permission_id integer NOT NULL REFERENCES public.permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE public.user_roles (
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    role_id integer NOT NULL REFERENCES public.roles(id) ON DELETE CASCADE,
+    created_at timestamp without time zone,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO public.permissions (name, description) VALUES
+    ('users:read', 'View users'),
+    ('users:write', 'Create, change and delete users'),
+    ('roles:write', 'Assign roles to users');
+
+INSERT INTO public.roles (name, description, created_at) VALUES
+    ('admin', 'Full access', now()),
+    ('viewer', 'Read-only access to users', now());
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM public.roles r, public.permissions p
+    WHERE r.name = 'admin' OR (r.name = 'viewer' AND p.name = 'users:read');
+
+INSERT INTO public.user_roles (user_id, role_id, created_at)
+    SELECT u.id, r.id, now() FROM public.users u, public.roles r
+    WHERE u.is_admin = 1 AND r.name = 'admin';
+
+ALTER TABLE public.users DROP COLUMN is_admin;
+
+COMMIT;
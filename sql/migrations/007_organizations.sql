@@ -0,0 +1,64 @@
+-- Adds organizations. Every existing user becomes a member of a "Default"
+-- organization, which also takes over their roles.
+--
+-- Row-level security keeps queries the app scopes to an organization (by setting
+-- app.organization_id) from seeing users of other organizations. Postgres doesn't
+-- apply it to superusers, so the app has to connect as an ordinary role for it to
+-- take effect.
+--
+--   psql "$DSN" -f sql/migrations/007_organizations.sql
+
+BEGIN;
+
+CREATE TABLE public.organizations (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name character varying(255) NOT NULL,
+    slug character varying(64) NOT NULL UNIQUE,
+    created_at timestamp without time zone
+);
+
+CREATE TABLE public.organization_members (
+    organization_id integer NOT NULL REFERENCES public.organizations(id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at timestamp without time zone,
+    PRIMARY KEY (organization_id, user_id)
+);
+
+INSERT INTO public.organizations (name, slug, created_at) VALUES ('Default', 'default', now());
+
+INSERT INTO public.organization_members (organization_id, user_id, created_at)
+    SELECT o.id, u.id, now() FROM public.organizations o, public.users u
+    WHERE o.slug = 'default';
+
+ALTER TABLE public.user_roles ADD COLUMN organization_id integer;
+UPDATE public.user_roles SET organization_id = (SELECT id FROM public.organizations WHERE slug = 'default');
+ALTER TABLE public.user_roles ALTER COLUMN organization_id SET NOT NULL;
+ALTER TABLE public.user_roles DROP CONSTRAINT user_roles_pkey;
+ALTER TABLE public.user_roles ADD CONSTRAINT user_roles_pkey PRIMARY KEY (organization_id, user_id, role_id);
+ALTER TABLE public.user_roles ADD CONSTRAINT user_roles_organization_id_user_id_fkey
+    FOREIGN KEY (organization_id, user_id) REFERENCES public.organization_members(organization_id, user_id)
+    ON UPDATE CASCADE ON DELETE CASCADE;
+
+-- Authorization codes remember the organization the user consented in, so the
+-- roles in the tokens we issue are theirs there.
+ALTER TABLE public.oauth_authorization_codes ADD COLUMN organization_id integer
+    REFERENCES public.organizations(id) ON DELETE CASCADE;
+
+ALTER TABLE public.users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.users FORCE ROW LEVEL SECURITY;
+CREATE POLICY users_tenant_isolation ON public.users USING (
+    nullif(current_setting('app.organization_id', true), '') IS NULL
+    OR EXISTS (
+        SELECT 1 FROM public.organization_members m
+        WHERE m.user_id = users.id AND m.organization_id = current_setting('app.organization_id')::integer
+    )
+);
+
+ALTER TABLE public.user_roles ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.user_roles FORCE ROW LEVEL SECURITY;
+CREATE POLICY user_roles_tenant_isolation ON public.user_roles USING (
+    nullif(current_setting('app.organization_id', true), '') IS NULL
+    OR organization_id = current_setting('app.organization_id')::integer
+);
+
+COMMIT;
@@ -0,0 +1,20 @@
+-- Adds invitations to join an organization.
+--
+--   psql "$DSN" -f sql/migrations/008_invitations.sql
+
+BEGIN;
+
+CREATE TABLE public.invitations (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    organization_id integer NOT NULL REFERENCES public.organizations(id) ON DELETE CASCADE,
+    email character varying(255) NOT NULL,
+    role_id integer REFERENCES public.roles(id) ON DELETE SET NULL,
+    invited_by integer REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE SET NULL,
+    token_hash character(64) NOT NULL UNIQUE,
+    expires_at timestamp without time zone NOT NULL,
+    accepted_at timestamp without time zone,
+    revoked_at timestamp without time zone,
+    created_at timestamp without time zone
+);
+
+COMMIT;
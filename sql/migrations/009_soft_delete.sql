@@ -0,0 +1,10 @@
+-- Deleting a user now only marks it deleted. The row is purged once the retention
+-- period has passed, and can be restored until then.
+--
+--   psql "$DSN" -f sql/migrations/009_soft_delete.sql
+
+BEGIN;
+
+ALTER TABLE public.users ADD COLUMN deleted_at timestamp without time zone;
+
+COMMIT;
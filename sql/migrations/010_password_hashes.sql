@@ -0,0 +1,11 @@
+-- Widens the password column for argon2id hashes, which are longer than the 60
+-- characters of a bcrypt hash. Existing bcrypt hashes keep working and are upgraded
+-- the next time their user logs in.
+--
+--   psql "$DSN" -f sql/migrations/010_password_hashes.sql
+
+BEGIN;
+
+ALTER TABLE public.users ALTER COLUMN password TYPE character varying(255);
+
+COMMIT;
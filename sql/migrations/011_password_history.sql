@@ -0,0 +1,15 @@
+-- Keeps the hashes of users' earlier passwords, so the password policy can refuse
+-- reusing them.
+--
+--   psql "$DSN" -f sql/migrations/011_password_history.sql
+
+BEGIN;
+
+CREATE TABLE public.password_history (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    password_hash character varying(255) NOT NULL,
+    created_at timestamp without time zone
+);
+
+COMMIT;
@@ -0,0 +1,10 @@
+-- Remembers the time step of the last authenticator code each user signed in with,
+-- so a code can't be used a second time while it is still valid.
+--
+--   psql "$DSN" -f sql/migrations/012_totp_last_step.sql
+
+BEGIN;
+
+ALTER TABLE public.users ADD COLUMN totp_last_step bigint;
+
+COMMIT;
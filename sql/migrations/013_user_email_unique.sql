@@ -0,0 +1,12 @@
+-- Makes email addresses unique regardless of case. Users log in with them and
+-- directories provision users by them, and an organization can't see the addresses
+-- of another's users to check for itself. Fails while two users share an address;
+-- merge them first.
+--
+--   psql "$DSN" -f sql/migrations/013_user_email_unique.sql
+
+BEGIN;
+
+CREATE UNIQUE INDEX users_email_key ON public.users (lower(email));
+
+COMMIT;
@@ -0,0 +1,39 @@
+-- Makes the row-level security policies fail closed and adds the role the app
+-- connects as.
+--
+-- The policies used to let every row through when app.organization_id wasn't set,
+-- so a query that forgot its scope saw all organizations. Now an unset scope sees
+-- nothing; the app sets app.organization_id to an organization's id, or to
+-- 'system' for the queries that have to see everyone.
+--
+-- Postgres doesn't apply row-level security to superusers, so the app must not
+-- connect as one. Run this as the owner of the tables, then give webapp a password
+-- and point the app's -dsn at it:
+--
+--   psql "$DSN" -f sql/migrations/014_app_role.sql
+--   psql "$DSN" -c "\password webapp"
+
+BEGIN;
+
+DROP POLICY users_tenant_isolation ON public.users;
+CREATE POLICY users_tenant_isolation ON public.users USING (
+    current_setting('app.organization_id', true) = 'system'
+    OR EXISTS (
+        SELECT 1 FROM public.organization_members m
+        WHERE m.user_id = users.id AND m.organization_id::text = current_setting('app.organization_id', true)
+    )
+);
+
+DROP POLICY user_roles_tenant_isolation ON public.user_roles;
+CREATE POLICY user_roles_tenant_isolation ON public.user_roles USING (
+    current_setting('app.organization_id', true) = 'system'
+    OR organization_id::text = current_setting('app.organization_id', true)
+);
+
+CREATE ROLE webapp LOGIN;
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO webapp;
+GRANT USAGE ON ALL SEQUENCES IN SCHEMA public TO webapp;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO webapp;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE ON SEQUENCES TO webapp;
+
+COMMIT;